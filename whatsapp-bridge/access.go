@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Chat scopes limit which chats a caller of the REST API can read. The API key
+// a request carries selects a scope from API_KEYS_FILE, and the X-Chat-Scope
+// header can narrow it further, which is how the MCP server applies its profile.
+// Scopes cover the chat and message reads, not sending.
+
+// ChatScope holds the visibility rules of a caller. An empty scope sees every chat.
+type ChatScope struct {
+	// Allow lists the visible chats as phone numbers or JIDs
+	Allow []string `json:"allow,omitempty"`
+	// Deny hides chats, even allowed or labeled ones
+	Deny []string `json:"deny,omitempty"`
+	// GroupsOnly hides direct chats
+	GroupsOnly bool `json:"groups_only,omitempty"`
+	// Labels makes the chats with one of these labels visible, besides Allow
+	Labels []string `json:"labels,omitempty"`
+}
+
+// APIKeys maps API keys to the scope they read with
+type APIKeys struct {
+	// AllowAnonymous gives requests without a key full access. Otherwise they
+	// are refused as soon as any key is defined.
+	AllowAnonymous bool                 `json:"allow_anonymous"`
+	Keys           map[string]ChatScope `json:"keys"`
+}
+
+var apiKeys = &APIKeys{}
+
+// loadAPIKeys reads the JSON file named by API_KEYS_FILE, if set
+func loadAPIKeys() (*APIKeys, error) {
+	path, ok := os.LookupEnv("API_KEYS_FILE")
+	if !ok || path == "" {
+		return &APIKeys{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API_KEYS_FILE: %v", err)
+	}
+	var keys APIKeys
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid API_KEYS_FILE %s: %v", path, err)
+	}
+	for key := range keys.Keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid API_KEYS_FILE %s: empty key", path)
+		}
+	}
+	fmt.Printf("Loaded %d API keys from %s\n", len(keys.Keys), path)
+	if len(keys.Keys) > 0 && keys.AllowAnonymous {
+		fmt.Println("Warning: requests without an API key have full access (allow_anonymous)")
+	}
+	return &keys, nil
+}
+
+// requireKey reports whether requests without a key are refused
+func (k *APIKeys) requireKey() bool {
+	return len(k.Keys) > 0 && !k.AllowAnonymous
+}
+
+// lookup returns the scope of a key, comparing in constant time
+func (k *APIKeys) lookup(key string) (ChatScope, bool) {
+	for candidate, scope := range k.Keys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			return scope, true
+		}
+	}
+	return ChatScope{}, false
+}
+
+// chatRule is a ChatScope with its phone numbers resolved to chat JIDs
+type chatRule struct {
+	allow, deny []string
+	labels      []string
+	groupsOnly  bool
+	// restricted means only allowed or labeled chats are visible
+	restricted bool
+}
+
+// chatAccess is what a request may read: a chat must pass every rule
+type chatAccess []chatRule
+
+// restricted reports whether some chats are hidden
+func (a chatAccess) restricted() bool {
+	return len(a) > 0
+}
+
+// chatRule resolves a scope. It returns false for a scope without rules.
+func (store *MessageStore) chatRule(scope ChatScope) (chatRule, bool) {
+	rule := chatRule{
+		allow:      store.scopeChats(scope.Allow),
+		deny:       store.scopeChats(scope.Deny),
+		groupsOnly: scope.GroupsOnly,
+	}
+	for _, label := range scope.Labels {
+		if label = strings.ToLower(strings.TrimSpace(label)); label != "" {
+			rule.labels = append(rule.labels, label)
+		}
+	}
+	rule.restricted = len(scope.Allow) > 0 || len(rule.labels) > 0
+	return rule, rule.restricted || rule.groupsOnly || len(rule.deny) > 0
+}
+
+// scopeChats turns phone numbers and JIDs into the chat JIDs they can be
+// stored under, both the phone number and the LID for people
+func (store *MessageStore) scopeChats(entries []string) []string {
+	var chats []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "@") {
+			phone, err := normalizePhoneNumber(entry)
+			if err != nil {
+				fmt.Printf("Ignoring invalid chat scope entry %q: %v\n", entry, err)
+				continue
+			}
+			entry = phone
+		}
+		_, identities := store.contactIdentities(entry)
+		chats = append(chats, identities...)
+	}
+	return chats
+}
+
+// condition returns an SQL condition that holds for the visible chats in column.
+// arg adds a value to the query and returns its placeholder.
+func (a chatAccess) condition(column string, arg func(any) string) string {
+	var conds []string
+	for _, rule := range a {
+		conds = append(conds, rule.condition(column, arg))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func (r chatRule) condition(column string, arg func(any) string) string {
+	in := func(values []string) string {
+		params := make([]string, len(values))
+		for i, v := range values {
+			params[i] = arg(v)
+		}
+		return strings.Join(params, ", ")
+	}
+
+	var conds []string
+	if r.groupsOnly {
+		conds = append(conds, column+" LIKE '%@g.us'")
+	}
+	if r.restricted {
+		var visible []string
+		if len(r.allow) > 0 {
+			visible = append(visible, column+" IN ("+in(r.allow)+")")
+		}
+		if len(r.labels) > 0 {
+			visible = append(visible, column+` IN (
+				SELECT cl.chat_jid FROM chat_labels cl JOIN labels l ON l.id = cl.label_id
+				WHERE LOWER(l.name) IN (`+in(r.labels)+`))`)
+		}
+		if len(visible) == 0 {
+			// Nothing on the allow list could be resolved
+			visible = append(visible, "1 = 0")
+		}
+		conds = append(conds, "("+strings.Join(visible, " OR ")+")")
+	}
+	if len(r.deny) > 0 {
+		conds = append(conds, column+" NOT IN ("+in(r.deny)+")")
+	}
+	return "(" + strings.Join(conds, " AND ") + ")"
+}
+
+// chatVisible reports whether a request with this access may read a chat
+func (store *MessageStore) chatVisible(access chatAccess, chatJID string) bool {
+	if !access.restricted() {
+		return true
+	}
+	var args []any
+	cond := access.condition("v.jid", func(v any) string {
+		args = append(args, v)
+		return "?"
+	})
+	var count int
+	err := store.db.QueryRow(rebind("SELECT COUNT(*) FROM (SELECT CAST(? AS TEXT) AS jid) v WHERE "+cond),
+		append([]any{chatJID}, args...)...).Scan(&count)
+	if err != nil {
+		fmt.Printf("Failed to check access to %s: %v\n", chatJID, err)
+		return false
+	}
+	return count > 0
+}
+
+// contactVisible reports whether a contact's direct chat is visible, under
+// its phone number or its LID. jid may also be a formatted phone number.
+func (store *MessageStore) contactVisible(access chatAccess, jid string) bool {
+	if !access.restricted() {
+		return true
+	}
+	if !strings.Contains(jid, "@") {
+		phone, err := normalizePhoneNumber(jid)
+		if err != nil {
+			return false
+		}
+		jid = phone
+	}
+	_, chats := store.contactIdentities(jid)
+	for _, chat := range chats {
+		if store.chatVisible(access, chat) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireFullAccess refuses requests limited to some chats with 403, for data
+// that spans chats. It returns false when the request was refused.
+func requireFullAccess(w http.ResponseWriter, r *http.Request, what string) bool {
+	if requestAccess(r).restricted() {
+		http.Error(w, what+" needs full access", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+type accessContextKey struct{}
+
+// withChatAccess checks the API key of every request and resolves what it may read
+func withChatAccess(store *MessageStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var scopes []ChatScope
+		if key := requestKey(r); key != "" {
+			scope, ok := apiKeys.lookup(key)
+			if !ok {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			scopes = append(scopes, scope)
+		} else if apiKeys.requireKey() {
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+
+		// The header can only hide more chats, since every rule must pass
+		if header := r.Header.Get("X-Chat-Scope"); header != "" {
+			var scope ChatScope
+			if err := json.Unmarshal([]byte(header), &scope); err != nil {
+				http.Error(w, "Invalid X-Chat-Scope header", http.StatusBadRequest)
+				return
+			}
+			scopes = append(scopes, scope)
+		}
+
+		var access chatAccess
+		for _, scope := range scopes {
+			if rule, ok := store.chatRule(scope); ok {
+				access = append(access, rule)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessContextKey{}, access)))
+	})
+}
+
+// requestKey returns the API key of a request, from X-API-Key or a bearer token
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// requestAccess returns the chats a request may read
+func requestAccess(r *http.Request) chatAccess {
+	access, _ := r.Context().Value(accessContextKey{}).(chatAccess)
+	return access
+}
+
+// Label is a chat label, synced from WhatsApp Business or added through the API
+type Label struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Chats []string `json:"chats"`
+}
+
+// StoreLabel saves the name of a label, or removes it when deleted
+func (store *MessageStore) StoreLabel(id, name string, deleted bool) error {
+	if deleted {
+		if _, err := store.db.Exec(rebind("DELETE FROM chat_labels WHERE label_id = ?"), id); err != nil {
+			return fmt.Errorf("failed to delete label: %v", err)
+		}
+		if _, err := store.db.Exec(rebind("DELETE FROM labels WHERE id = ?"), id); err != nil {
+			return fmt.Errorf("failed to delete label: %v", err)
+		}
+		return nil
+	}
+	_, err := store.db.Exec(rebind(`
+		INSERT INTO labels (id, name) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name`),
+		id, name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store label: %v", err)
+	}
+	return nil
+}
+
+// SetChatLabel adds a label to a chat or removes it
+func (store *MessageStore) SetChatLabel(chatJID, labelID string, labeled bool) error {
+	var err error
+	if labeled {
+		_, err = store.db.Exec(rebind(`
+			INSERT INTO chat_labels (chat_jid, label_id) VALUES (?, ?)
+			ON CONFLICT (chat_jid, label_id) DO NOTHING`),
+			chatJID, labelID,
+		)
+	} else {
+		_, err = store.db.Exec(rebind("DELETE FROM chat_labels WHERE chat_jid = ? AND label_id = ?"), chatJID, labelID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update chat label: %v", err)
+	}
+	return nil
+}
+
+// labelID finds a label by name, creating a local one if there is none
+func (store *MessageStore) labelID(name string) (string, error) {
+	var id string
+	err := store.db.QueryRow(rebind("SELECT id FROM labels WHERE LOWER(name) = LOWER(?)"), name).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	// Local labels never collide with the numeric IDs of WhatsApp
+	id = "local:" + strings.ToLower(name)
+	return id, store.StoreLabel(id, name, false)
+}
+
+// ListLabels returns the labels with the chats visible to access that have them
+func (store *MessageStore) ListLabels(access chatAccess) ([]Label, error) {
+	q := "SELECT l.id, l.name, cl.chat_jid FROM labels l LEFT JOIN chat_labels cl ON cl.label_id = l.id"
+	var args []any
+	if access.restricted() {
+		q += " AND " + access.condition("cl.chat_jid", func(v any) string {
+			args = append(args, v)
+			return "?"
+		})
+	}
+	q += " ORDER BY l.name, cl.chat_jid"
+
+	rows, err := store.db.Query(rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := []Label{}
+	for rows.Next() {
+		var id, name string
+		var chat sql.NullString
+		if err := rows.Scan(&id, &name, &chat); err != nil {
+			return nil, err
+		}
+		if len(labels) == 0 || labels[len(labels)-1].ID != id {
+			labels = append(labels, Label{ID: id, Name: name, Chats: []string{}})
+		}
+		if chat.Valid {
+			labels[len(labels)-1].Chats = append(labels[len(labels)-1].Chats, chat.String)
+		}
+	}
+	return labels, rows.Err()
+}
+
+// handleLabelEvent keeps the labels of a WhatsApp Business account in sync
+func handleLabelEvent(client *whatsmeow.Client, messageStore *MessageStore, evt interface{}) {
+	var err error
+	switch v := evt.(type) {
+	case *events.LabelEdit:
+		err = messageStore.StoreLabel(v.LabelID, v.Action.GetName(), v.Action.GetDeleted())
+	case *events.LabelAssociationChat:
+		chat := v.JID
+		if chat.Server != types.GroupServer {
+			chat = canonicalJID(client, messageStore, chat)
+		}
+		err = messageStore.SetChatLabel(chat.String(), v.LabelID, v.Action.GetLabeled())
+	}
+	if err != nil {
+		fmt.Printf("Failed to sync label: %v\n", err)
+	}
+}
+
+// LabelRequest adds a label to a chat or removes it
+type LabelRequest struct {
+	ChatJID string `json:"chat_jid"`
+	Label   string `json:"label"`
+	Remove  bool   `json:"remove"`
+}
+
+// registerLabelRoutes adds the chat label endpoints
+func registerLabelRoutes(messageStore *MessageStore) {
+	// GET /api/labels lists labels and their chats
+	// POST /api/labels labels a chat locally, or unlabels it with remove
+	http.HandleFunc("/api/labels", func(w http.ResponseWriter, r *http.Request) {
+		access := requestAccess(r)
+		switch r.Method {
+		case http.MethodGet:
+			labels, err := messageStore.ListLabels(access)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{"labels": labels})
+
+		case http.MethodPost:
+			// Labels decide what scoped keys see, so only full access may change them
+			if access.restricted() {
+				http.Error(w, "Labels can only be changed with full access", http.StatusForbidden)
+				return
+			}
+			var req LabelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			req.Label = strings.TrimSpace(req.Label)
+			if req.ChatJID == "" || req.Label == "" {
+				http.Error(w, "Chat JID and label are required", http.StatusBadRequest)
+				return
+			}
+			id, err := messageStore.labelID(req.Label)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if err := messageStore.SetChatLabel(req.ChatJID, id, !req.Remove); err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": fmt.Sprintf("Updated label %s of %s", req.Label, req.ChatJID),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
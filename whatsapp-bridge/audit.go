@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The audit log records every API call that changes something, which covers
+// sends, downloads and admin calls, and every delivery attempt of the outbox,
+// so scheduled and bulk sends show up too. Entries are only ever appended, and
+// dropped once older than AUDIT_MAX_AGE when that is set. GET /api/audit
+// queries them and exports them as JSON lines.
+
+const (
+	auditKindRequest  = "request"
+	auditKindDelivery = "delivery"
+
+	// Request bodies beyond this size are logged by size only
+	auditMaxBody = 64 << 10
+	// Error responses are kept up to this size
+	auditMaxError = 1024
+	// auditPruneInterval is how often entries past AUDIT_MAX_AGE are deleted
+	auditPruneInterval = time.Hour
+)
+
+// AuditEntry is one record of the audit log
+type AuditEntry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Caller is the fingerprint of the API key, or anonymous
+	Caller    string          `json:"caller,omitempty"`
+	Remote    string          `json:"remote,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status,omitempty"`
+	Outcome   string          `json:"outcome"`
+	LatencyMS int64           `json:"latency_ms"`
+	Request   json.RawMessage `json:"request,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// auditRedacted are the request fields whose values the log leaves out,
+// set with AUDIT_REDACT as a comma separated list or "none"
+var auditRedacted = loadAuditRedacted()
+
+func loadAuditRedacted() map[string]bool {
+	list := "message,caption,question,options,variables"
+	if val, ok := os.LookupEnv("AUDIT_REDACT"); ok {
+		list = val
+	}
+	fields := make(map[string]bool)
+	if strings.TrimSpace(list) == "none" {
+		return fields
+	}
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+// redact replaces the values of redacted fields anywhere in a JSON value
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if !auditRedacted[strings.ToLower(key)] {
+				v[key] = redact(val)
+				continue
+			}
+			if s, ok := val.(string); ok {
+				v[key] = fmt.Sprintf("[redacted %d chars]", len(s))
+			} else if val != nil {
+				v[key] = "[redacted]"
+			}
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = redact(val)
+		}
+	}
+	return v
+}
+
+// auditBody returns the loggable form of a request body
+func auditBody(body []byte) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var v any
+	if len(body) > auditMaxBody || json.Unmarshal(body, &v) != nil {
+		data, _ := json.Marshal(fmt.Sprintf("[%d bytes]", len(body)))
+		return data
+	}
+	data, err := json.Marshal(redact(v))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// callerID identifies the API key of a request without revealing it
+func callerID(r *http.Request) string {
+	key := requestKey(r)
+	if key == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
+
+// AppendAudit adds an entry to the audit log
+func (store *MessageStore) AppendAudit(e *AuditEntry) error {
+	if e.ID == "" {
+		e.ID = newRandomID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	var request any
+	if len(e.Request) > 0 {
+		request = string(e.Request)
+	}
+	_, err := store.db.Exec(rebind(`
+		INSERT INTO audit_log (id, time, kind, caller, remote, method, path, status, outcome, latency_ms, request, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		e.ID, e.Time.UTC(), e.Kind, e.Caller, e.Remote, e.Method, e.Path, e.Status, e.Outcome, e.LatencyMS, request, e.Error,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+// AuditQuery filters the audit log. Zero values match everything.
+type AuditQuery struct {
+	Since, Until time.Time
+	Kind         string
+	Caller       string
+	PathPrefix   string
+	Outcome      string
+	Limit        int
+}
+
+// QueryAudit calls fn for the matching entries, newest first
+func (store *MessageStore) QueryAudit(q AuditQuery, fn func(*AuditEntry) error) error {
+	var where []string
+	var args []any
+	if !q.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, q.Until.UTC())
+	}
+	for _, f := range []struct{ column, value string }{
+		{"kind", q.Kind}, {"caller", q.Caller}, {"outcome", q.Outcome},
+	} {
+		if f.value != "" {
+			where = append(where, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+	if q.PathPrefix != "" {
+		where = append(where, "path LIKE ?")
+		args = append(args, q.PathPrefix+"%")
+	}
+
+	query := "SELECT id, time, kind, caller, remote, method, path, status, outcome, latency_ms, request, error FROM audit_log"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC, id"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := store.db.Query(rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		var caller, remote, method, request, errMsg sql.NullString
+		if err := rows.Scan(&e.ID, &e.Time, &e.Kind, &caller, &remote, &method, &e.Path, &e.Status, &e.Outcome, &e.LatencyMS, &request, &errMsg); err != nil {
+			return err
+		}
+		e.Caller, e.Remote, e.Method, e.Error = caller.String, remote.String, method.String, errMsg.String
+		if request.Valid && request.String != "" {
+			e.Request = json.RawMessage(request.String)
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// auditRecorder keeps the status and error body of a response
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (a *auditRecorder) WriteHeader(status int) {
+	a.status = status
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditRecorder) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	if a.status >= 400 && a.body.Len() < auditMaxError {
+		a.body.Write(b[:min(len(b), auditMaxError-a.body.Len())])
+	}
+	return a.ResponseWriter.Write(b)
+}
+
+// withAudit records every request that isn't a plain read
+func withAudit(store *MessageStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Read the body for the log and hand it on unchanged
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, auditMaxBody+1))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		}
+
+		start := time.Now()
+		rec := &auditRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		entry := &AuditEntry{
+			Time:      start,
+			Kind:      auditKindRequest,
+			Caller:    callerID(r),
+			Remote:    r.RemoteAddr,
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Status:    rec.status,
+			Outcome:   "ok",
+			LatencyMS: time.Since(start).Milliseconds(),
+			Request:   auditBody(body),
+		}
+		if rec.status >= 400 {
+			entry.Outcome = "error"
+			entry.Error = strings.TrimSpace(rec.body.String())
+		}
+		if err := store.AppendAudit(entry); err != nil {
+			fmt.Printf("Failed to audit %s: %v\n", entry.Path, err)
+		}
+	})
+}
+
+// auditDelivery records a delivery attempt of the outbox
+func auditDelivery(messageStore *MessageStore, item *OutboxItem, started time.Time) {
+	request, _ := json.Marshal(map[string]interface{}{
+		"recipient":  item.Recipient,
+		"kind":       item.Kind,
+		"attempt":    item.Attempts,
+		"message_id": item.MessageID,
+	})
+	err := messageStore.AppendAudit(&AuditEntry{
+		Time:      started,
+		Kind:      auditKindDelivery,
+		Path:      "outbox/" + item.ID,
+		Outcome:   item.Status,
+		LatencyMS: time.Since(started).Milliseconds(),
+		Request:   request,
+		Error:     item.LastError,
+	})
+	if err != nil {
+		fmt.Printf("Failed to audit delivery of %s: %v\n", item.ID, err)
+	}
+}
+
+// loadAuditMaxAge reads AUDIT_MAX_AGE, a Go duration such as 2160h. Empty or 0
+// keeps entries forever.
+func loadAuditMaxAge() (time.Duration, error) {
+	val, ok := os.LookupEnv("AUDIT_MAX_AGE")
+	if !ok || val == "" {
+		return 0, nil
+	}
+	maxAge, err := time.ParseDuration(val)
+	if err != nil || maxAge < 0 {
+		return 0, fmt.Errorf("invalid AUDIT_MAX_AGE: %q", val)
+	}
+	return maxAge, nil
+}
+
+// PruneAudit deletes the entries from before cutoff and returns how many
+func (store *MessageStore) PruneAudit(cutoff time.Time) (int64, error) {
+	res, err := store.db.Exec(rebind("DELETE FROM audit_log WHERE time < ?"), cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// runAuditRetention deletes entries older than maxAge until the process exits
+func runAuditRetention(messageStore *MessageStore, maxAge time.Duration) {
+	if maxAge == 0 {
+		return
+	}
+	ticker := time.NewTicker(auditPruneInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := messageStore.PruneAudit(time.Now().Add(-maxAge)); err != nil {
+			fmt.Printf("Failed to prune the audit log: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("Removed %d audit log entries older than %s\n", n, maxAge)
+		}
+		<-ticker.C
+	}
+}
+
+// registerAuditRoutes adds the audit log endpoint
+func registerAuditRoutes(messageStore *MessageStore) {
+	// GET /api/audit?since=&until=&kind=&caller=&path=&outcome=&limit=
+	// With format=jsonl all matching entries are exported as JSON lines
+	http.HandleFunc("/api/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if requestAccess(r).restricted() {
+			http.Error(w, "The audit log needs full access", http.StatusForbidden)
+			return
+		}
+
+		v := r.URL.Query()
+		q := AuditQuery{
+			Kind:       v.Get("kind"),
+			Caller:     v.Get("caller"),
+			PathPrefix: v.Get("path"),
+			Outcome:    v.Get("outcome"),
+			Limit:      100,
+		}
+		for _, t := range []struct {
+			name string
+			dst  *time.Time
+		}{{"since", &q.Since}, {"until", &q.Until}} {
+			if val := v.Get(t.name); val != "" {
+				parsed, err := time.Parse(time.RFC3339, val)
+				if err != nil {
+					http.Error(w, "Invalid "+t.name+" time, use RFC 3339", http.StatusBadRequest)
+					return
+				}
+				*t.dst = parsed
+			}
+		}
+		jsonl := v.Get("format") == "jsonl"
+		if jsonl {
+			q.Limit = 0
+		}
+		if val := v.Get("limit"); val != "" {
+			limit, err := strconv.Atoi(val)
+			if err != nil || limit < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			q.Limit = limit
+		}
+
+		if jsonl {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+			enc := json.NewEncoder(w)
+			if err := messageStore.QueryAudit(q, func(e *AuditEntry) error { return enc.Encode(e) }); err != nil {
+				fmt.Printf("Failed to export audit log: %v\n", err)
+			}
+			return
+		}
+
+		entries := []*AuditEntry{}
+		err := messageStore.QueryAudit(q, func(e *AuditEntry) error {
+			entries = append(entries, e)
+			return nil
+		})
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"entries": entries,
+			"count":   len(entries),
+		})
+	})
+}
@@ -0,0 +1,817 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// A bulk send renders a message template for every recipient and stores the job.
+// A runner then puts one recipient at a time in the outbox, as fast as the send
+// limits allow, so a large job is paced instead of refused. The outcome of each
+// recipient is read from its outbox item.
+
+const (
+	bulkRunning   = "running"
+	bulkDone      = "done"
+	bulkCancelled = "cancelled"
+
+	// Recipient states before the outbox takes over
+	bulkPending = "pending"
+	bulkQueued  = "queued"
+	bulkSkipped = "skipped"
+	// bulkReady is a recipient a dry run would send to
+	bulkReady = "ready"
+
+	bulkMaxRecipients = 1000
+	bulkPollInterval  = 15 * time.Second
+)
+
+// bulkMediaDir holds the file of a bulk send until every recipient is queued
+const bulkMediaDir = "store/bulk"
+
+// BulkRecipient is a recipient with its own template variables. In JSON it is
+// either a phone number or JID, or {"recipient": ..., "variables": {...}}.
+type BulkRecipient struct {
+	Recipient string            `json:"recipient"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+func (r *BulkRecipient) UnmarshalJSON(data []byte) error {
+	var recipient string
+	if err := json.Unmarshal(data, &recipient); err == nil {
+		*r = BulkRecipient{Recipient: recipient}
+		return nil
+	}
+	type plain BulkRecipient
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// BulkSendRequest is the body of POST /api/bulk
+type BulkSendRequest struct {
+	Recipients []BulkRecipient   `json:"recipients,omitempty"`
+	List       string            `json:"list,omitempty"`       // name of a saved recipient list
+	Message    string            `json:"message,omitempty"`    // template like "Hi {{first_name|there}}, ..."
+	MediaPath  string            `json:"media_path,omitempty"` // sent with the message as caption
+	Variables  map[string]string `json:"variables,omitempty"`  // values for every recipient
+	DryRun     bool              `json:"dry_run,omitempty"`
+}
+
+// BulkOutcome is how a bulk send went for one recipient
+type BulkOutcome struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message,omitempty"`
+	Status    string `json:"status"`
+	OutboxID  string `json:"outbox_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BulkJob is a stored bulk send
+type BulkJob struct {
+	ID        string         `json:"id"`
+	Status    string         `json:"status"`
+	Message   string         `json:"message,omitempty"`
+	MediaPath string         `json:"media_path,omitempty"`
+	Total     int            `json:"total"`
+	Counts    map[string]int `json:"counts"`
+	// Finished is set once every recipient was sent, failed or skipped
+	Finished   bool          `json:"finished"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Recipients []BulkOutcome `json:"recipients,omitempty"`
+}
+
+// templateVariable matches {{name}} and {{name|default}}
+var templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*(\|[^}]*)?\}\}`)
+
+// renderTemplate fills in the variables of a template. Variables without a
+// value or default are an error, so nobody gets a message with a hole in it.
+func renderTemplate(template string, vars map[string]string) (string, error) {
+	var missing []string
+	out := templateVariable.ReplaceAllStringFunc(template, func(match string) string {
+		m := templateVariable.FindStringSubmatch(match)
+		if value := vars[m[1]]; value != "" {
+			return value
+		}
+		if m[2] != "" {
+			return strings.TrimSpace(m[2][1:])
+		}
+		missing = append(missing, m[1])
+		return match
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// contactVariables are the variables every recipient has: name, first_name and
+// phone, as far as they are known
+func (store *MessageStore) contactVariables(key string) map[string]string {
+	vars := map[string]string{}
+	jid, err := types.ParseJID(key)
+	if err != nil {
+		return vars
+	}
+	if jid.Server == types.DefaultUserServer {
+		vars["phone"] = "+" + jid.User
+	}
+	if jid.Server == types.GroupServer {
+		if name := store.chatName(key); name != "" {
+			vars["name"] = name
+		}
+		return vars
+	}
+	if c, err := store.GetContact(key); err == nil {
+		vars["name"] = c.DisplayName()
+		vars["first_name"] = c.FirstName
+		if vars["first_name"] == "" {
+			vars["first_name"], _, _ = strings.Cut(c.DisplayName(), " ")
+		}
+	}
+	return vars
+}
+
+// chatName returns the stored name of a chat
+func (store *MessageStore) chatName(jid string) string {
+	var name sql.NullString
+	store.db.QueryRow(rebind("SELECT name FROM chats WHERE jid = ?"), jid).Scan(&name)
+	return name.String
+}
+
+// prepareBulk renders the message for every recipient. Recipients that can't be
+// sent to are skipped with the reason.
+func prepareBulk(messageStore *MessageStore, req BulkSendRequest, recipients []BulkRecipient) []BulkOutcome {
+	outcomes := make([]BulkOutcome, 0, len(recipients))
+	seen := make(map[string]bool)
+	for _, r := range recipients {
+		outcome := BulkOutcome{Recipient: strings.TrimSpace(r.Recipient), Status: bulkPending}
+		key, err := validRecipient(messageStore, outcome.Recipient)
+		switch {
+		case err != nil:
+			outcome.Status, outcome.Error = bulkSkipped, err.Error()
+		case seen[key]:
+			outcome.Status, outcome.Error = bulkSkipped, "duplicate recipient"
+		default:
+			seen[key] = true
+			vars := make(map[string]string)
+			for _, set := range []map[string]string{req.Variables, messageStore.contactVariables(key), r.Variables} {
+				for k, v := range set {
+					if v != "" {
+						vars[k] = v
+					}
+				}
+			}
+			outcome.Message, err = renderTemplate(req.Message, vars)
+			if err != nil {
+				outcome.Status, outcome.Error = bulkSkipped, err.Error()
+			}
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
+
+// validRecipient checks a recipient without asking WhatsApp and returns the key
+// it is counted under
+func validRecipient(messageStore *MessageStore, recipient string) (string, error) {
+	if recipient == "" {
+		return "", fmt.Errorf("empty recipient")
+	}
+	if strings.Contains(recipient, "@") {
+		if _, err := types.ParseJID(recipient); err != nil {
+			return "", fmt.Errorf("invalid JID %q: %v", recipient, err)
+		}
+	} else if _, err := normalizePhoneNumber(recipient); err != nil {
+		return "", err
+	}
+	return recipientKey(messageStore, recipient), nil
+}
+
+func bulkCounts(outcomes []BulkOutcome) map[string]int {
+	counts := make(map[string]int)
+	for _, o := range outcomes {
+		counts[o.Status]++
+	}
+	return counts
+}
+
+// CreateBulkJob stores a job and its recipients
+func (store *MessageStore) CreateBulkJob(job *BulkJob, outcomes []BulkOutcome) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := job.CreatedAt.UTC()
+	_, err = tx.Exec(rebind(`
+		INSERT INTO bulk_jobs (id, status, message, media_path, total, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		job.ID, job.Status, job.Message, job.MediaPath, job.Total, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store bulk send: %v", err)
+	}
+
+	stmt, err := tx.Prepare(rebind(`
+		INSERT INTO bulk_recipients (job_id, position, recipient, message, status, error)
+		VALUES (?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, o := range outcomes {
+		if _, err := stmt.Exec(job.ID, i, o.Recipient, o.Message, o.Status, o.Error); err != nil {
+			return fmt.Errorf("failed to store bulk recipient %s: %v", o.Recipient, err)
+		}
+	}
+	return tx.Commit()
+}
+
+const bulkJobColumns = `id, status, COALESCE(message, ''), COALESCE(media_path, ''), total, created_at, updated_at`
+
+func scanBulkJob(row interface{ Scan(...any) error }) (*BulkJob, error) {
+	var job BulkJob
+	err := row.Scan(&job.ID, &job.Status, &job.Message, &job.MediaPath, &job.Total, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// bulkOutcomes returns the recipients of a job with their current outcome. Once
+// a recipient is queued, the outbox knows how it went.
+func (store *MessageStore) bulkOutcomes(jobID string) ([]BulkOutcome, error) {
+	rows, err := store.db.Query(rebind(`
+		SELECT r.recipient, COALESCE(r.message, ''),
+			CASE WHEN r.status = ? AND o.status IS NOT NULL THEN o.status ELSE r.status END,
+			COALESCE(r.outbox_id, ''), COALESCE(o.message_id, ''),
+			COALESCE(NULLIF(r.error, ''), o.last_error, '')
+		FROM bulk_recipients r
+		LEFT JOIN outbox o ON o.id = r.outbox_id
+		WHERE r.job_id = ?
+		ORDER BY r.position`), bulkQueued, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	outcomes := []BulkOutcome{}
+	for rows.Next() {
+		var o BulkOutcome
+		if err := rows.Scan(&o.Recipient, &o.Message, &o.Status, &o.OutboxID, &o.MessageID, &o.Error); err != nil {
+			return nil, err
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes, rows.Err()
+}
+
+// GetBulkJob returns a job with the outcome for every recipient
+func (store *MessageStore) GetBulkJob(id string) (*BulkJob, error) {
+	job, err := scanBulkJob(store.db.QueryRow(rebind("SELECT "+bulkJobColumns+" FROM bulk_jobs WHERE id = ?"), id))
+	if err != nil {
+		return nil, err
+	}
+	if job.Recipients, err = store.bulkOutcomes(id); err != nil {
+		return nil, err
+	}
+	job.summarize()
+	return job, nil
+}
+
+// ListBulkJobs returns the jobs, newest first, without their recipients
+func (store *MessageStore) ListBulkJobs(limit int) ([]*BulkJob, error) {
+	rows, err := store.db.Query(rebind("SELECT "+bulkJobColumns+" FROM bulk_jobs ORDER BY created_at DESC LIMIT ?"), limit)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*BulkJob
+	for rows.Next() {
+		job, err := scanBulkJob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		outcomes, err := store.bulkOutcomes(job.ID)
+		if err != nil {
+			return nil, err
+		}
+		job.Recipients = outcomes
+		job.summarize()
+		job.Recipients = nil
+	}
+	return jobs, nil
+}
+
+func (job *BulkJob) summarize() {
+	job.Counts = bulkCounts(job.Recipients)
+	job.Finished = job.Status != bulkRunning &&
+		job.Counts[bulkPending]+job.Counts[outboxQueued]+job.Counts[outboxSending] == 0
+}
+
+// nextBulkRecipient returns the first recipient of the oldest running job that
+// isn't queued yet and isn't held back by a limit of its own at now
+func (store *MessageStore) nextBulkRecipient(now time.Time) (jobID string, position int, recipient, message, mediaPath string, err error) {
+	err = store.db.QueryRow(rebind(`
+		SELECT r.job_id, r.position, r.recipient, COALESCE(r.message, ''), COALESCE(j.media_path, '')
+		FROM bulk_recipients r
+		JOIN bulk_jobs j ON j.id = r.job_id
+		WHERE j.status = ? AND r.status = ? AND (r.not_before IS NULL OR r.not_before <= ?)
+		ORDER BY j.created_at, r.position
+		LIMIT 1`), bulkRunning, bulkPending, now.UTC(),
+	).Scan(&jobID, &position, &recipient, &message, &mediaPath)
+	return
+}
+
+// holdBulkRecipient puts off a recipient until notBefore, with the reason
+func (store *MessageStore) holdBulkRecipient(jobID string, position int, notBefore time.Time, reason string) error {
+	_, err := store.db.Exec(rebind(`
+		UPDATE bulk_recipients SET not_before = ?, error = ? WHERE job_id = ? AND position = ?`),
+		notBefore.UTC(), reason, jobID, position,
+	)
+	return err
+}
+
+// setBulkRecipient records that a recipient was queued, or why it wasn't
+func (store *MessageStore) setBulkRecipient(jobID string, position int, status, outboxID, errMsg string) error {
+	_, err := store.db.Exec(rebind(`
+		UPDATE bulk_recipients SET status = ?, outbox_id = ?, error = ? WHERE job_id = ? AND position = ?`),
+		status, outboxID, errMsg, jobID, position,
+	)
+	return err
+}
+
+// finishBulkJob marks a running job done once no recipient is left to queue. It
+// returns whether it did.
+func (store *MessageStore) finishBulkJob(jobID string) (bool, error) {
+	res, err := store.db.Exec(rebind(`
+		UPDATE bulk_jobs SET status = ?, updated_at = ?
+		WHERE id = ? AND status = ?
+			AND NOT EXISTS (SELECT 1 FROM bulk_recipients WHERE job_id = ? AND status = ?)`),
+		bulkDone, time.Now().UTC(), jobID, bulkRunning, jobID, bulkPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CancelBulkJob stops a running job. Recipients already queued are still sent.
+func (store *MessageStore) CancelBulkJob(id string) (bool, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(rebind("UPDATE bulk_jobs SET status = ?, updated_at = ? WHERE id = ? AND status = ?"),
+		bulkCancelled, time.Now().UTC(), id, bulkRunning)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	_, err = tx.Exec(rebind("UPDATE bulk_recipients SET status = ?, error = ? WHERE job_id = ? AND status = ?"),
+		bulkSkipped, "cancelled", id, bulkPending)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SaveRecipientList stores a named recipient list, replacing one of that name
+func (store *MessageStore) SaveRecipientList(name string, recipients []BulkRecipient) error {
+	data, err := json.Marshal(recipients)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(rebind(`
+		INSERT INTO recipient_lists (name, recipients, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET recipients = EXCLUDED.recipients, updated_at = EXCLUDED.updated_at`),
+		name, string(data), time.Now().UTC(),
+	)
+	return err
+}
+
+// GetRecipientList returns a saved recipient list
+func (store *MessageStore) GetRecipientList(name string) ([]BulkRecipient, error) {
+	var data string
+	if err := store.db.QueryRow(rebind("SELECT recipients FROM recipient_lists WHERE name = ?"), name).Scan(&data); err != nil {
+		return nil, err
+	}
+	var recipients []BulkRecipient
+	if err := json.Unmarshal([]byte(data), &recipients); err != nil {
+		return nil, fmt.Errorf("invalid stored list %s: %v", name, err)
+	}
+	return recipients, nil
+}
+
+// RecipientListInfo describes a saved recipient list
+type RecipientListInfo struct {
+	Name       string    `json:"name"`
+	Recipients int       `json:"recipients"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ListRecipientLists returns the saved recipient lists by name
+func (store *MessageStore) ListRecipientLists() ([]RecipientListInfo, error) {
+	rows, err := store.db.Query("SELECT name, recipients, updated_at FROM recipient_lists ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []RecipientListInfo{}
+	for rows.Next() {
+		var (
+			info RecipientListInfo
+			data string
+		)
+		if err := rows.Scan(&info.Name, &data, &info.UpdatedAt); err != nil {
+			return nil, err
+		}
+		var recipients []BulkRecipient
+		json.Unmarshal([]byte(data), &recipients)
+		info.Recipients = len(recipients)
+		lists = append(lists, info)
+	}
+	return lists, rows.Err()
+}
+
+// DeleteRecipientList removes a saved recipient list
+func (store *MessageStore) DeleteRecipientList(name string) (bool, error) {
+	res, err := store.db.Exec(rebind("DELETE FROM recipient_lists WHERE name = ?"), name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// bulkRunner queues the recipients of running bulk sends
+type bulkRunner struct {
+	wake chan struct{}
+}
+
+var bulk = &bulkRunner{wake: make(chan struct{}, 1)}
+
+// notify makes the runner look for recipients now
+func (b *bulkRunner) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run queues bulk recipients until the process exits
+func (b *bulkRunner) run(client *whatsmeow.Client, messageStore *MessageStore) {
+	for {
+		wait := bulkPollInterval
+		// Queuing while disconnected would only let the limits run ahead of delivery
+		if client.IsConnected() {
+			wait = b.queuePending(client, messageStore)
+		}
+		select {
+		case <-b.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// queuePending queues recipients until none are left or a send limit is
+// reached, and returns how long to wait before going on
+func (b *bulkRunner) queuePending(client *whatsmeow.Client, messageStore *MessageStore) time.Duration {
+	for {
+		now := time.Now()
+		jobID, position, recipient, message, mediaPath, err := messageStore.nextBulkRecipient(now)
+		if err == sql.ErrNoRows {
+			return bulkPollInterval
+		}
+		if err != nil {
+			fmt.Printf("Failed to read bulk sends: %v\n", err)
+			return bulkPollInterval
+		}
+		if limit := sendLimits.allow(messageStore, recipient, now); limit != nil {
+			// Only the global limit holds up everyone, others just put off this recipient
+			if limit.Scope == limitScopeGlobal {
+				return limit.RetryAfter
+			}
+			if err := messageStore.holdBulkRecipient(jobID, position, now.Add(limit.RetryAfter), limit.Error()); err != nil {
+				fmt.Printf("Failed to update bulk send %s: %v\n", jobID, err)
+				return bulkPollInterval
+			}
+			continue
+		}
+
+		id := string(client.GenerateMessageID())
+		status, errMsg := bulkQueued, ""
+		if err := enqueueBulkMessage(messageStore, id, recipient, message, mediaPath); err != nil {
+			status, errMsg, id = bulkSkipped, err.Error(), ""
+		}
+		if err := messageStore.setBulkRecipient(jobID, position, status, id, errMsg); err != nil {
+			fmt.Printf("Failed to update bulk send %s: %v\n", jobID, err)
+			return bulkPollInterval
+		}
+		if id != "" {
+			outbox.notify()
+		}
+
+		if done, err := messageStore.finishBulkJob(jobID); err != nil {
+			fmt.Printf("Failed to update bulk send %s: %v\n", jobID, err)
+		} else if done {
+			removeBulkMedia(jobID)
+			fmt.Printf("Bulk send %s queued all recipients\n", jobID)
+		}
+	}
+}
+
+func enqueueBulkMessage(messageStore *MessageStore, id, recipient, message, mediaPath string) error {
+	req := SendMessageRequest{Recipient: recipient, Message: message}
+	if mediaPath != "" {
+		path, err := copyOutboxMedia(id, mediaPath)
+		if err != nil {
+			return err
+		}
+		req.MediaPath = path
+	}
+	payload, err := json.Marshal(req)
+	if err == nil {
+		_, err = messageStore.EnqueueOutbox(id, outboxKindMessage, recipient, payload)
+	}
+	if err != nil {
+		removeOutboxMedia(id)
+	}
+	return err
+}
+
+func removeBulkMedia(id string) {
+	if err := os.RemoveAll(filepath.Join(bulkMediaDir, id)); err != nil {
+		fmt.Printf("Failed to remove bulk send media of %s: %v\n", id, err)
+	}
+}
+
+// startBulk checks a bulk send request and either previews it or stores the job
+func startBulk(w http.ResponseWriter, messageStore *MessageStore, req BulkSendRequest) {
+	recipients := req.Recipients
+	if req.List != "" {
+		list, err := messageStore.GetRecipientList(req.List)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("No recipient list named %q", req.List))
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		recipients = append(list, recipients...)
+	}
+	if len(recipients) == 0 {
+		http.Error(w, "recipients or list is required", http.StatusBadRequest)
+		return
+	}
+	if len(recipients) > bulkMaxRecipients {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d recipients per bulk send", bulkMaxRecipients))
+		return
+	}
+	if req.Message == "" && req.MediaPath == "" {
+		http.Error(w, "Message or media path is required", http.StatusBadRequest)
+		return
+	}
+
+	outcomes := prepareBulk(messageStore, req, recipients)
+	if req.DryRun {
+		for i := range outcomes {
+			if outcomes[i].Status == bulkPending {
+				outcomes[i].Status = bulkReady
+			}
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"dry_run":    true,
+			"counts":     bulkCounts(outcomes),
+			"recipients": outcomes,
+		})
+		return
+	}
+
+	job := &BulkJob{
+		ID:        newRandomID(),
+		Status:    bulkRunning,
+		Message:   req.Message,
+		Total:     len(outcomes),
+		CreatedAt: time.Now(),
+	}
+	job.UpdatedAt = job.CreatedAt
+	// The caller's file may be gone before the last recipient is queued
+	if req.MediaPath != "" {
+		path, err := copyMediaFile(filepath.Join(bulkMediaDir, job.ID), req.MediaPath)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		job.MediaPath = path
+	}
+	if err := messageStore.CreateBulkJob(job, outcomes); err != nil {
+		removeBulkMedia(job.ID)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	bulk.notify()
+
+	job.Recipients = outcomes
+	job.summarize()
+	// Only the skipped recipients are news at this point
+	job.Recipients = nil
+	for _, o := range outcomes {
+		if o.Status == bulkSkipped {
+			job.Recipients = append(job.Recipients, o)
+		}
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Bulk send %s started for %d recipients (%d skipped)", job.ID, job.Total-job.Counts[bulkSkipped], job.Counts[bulkSkipped]),
+		"job":     job,
+	})
+}
+
+// registerBulkRoutes serves GET and POST /api/bulk, GET and DELETE /api/bulk/{id}
+// and the saved recipient lists under /api/bulk/lists
+func registerBulkRoutes(messageStore *MessageStore) {
+	http.HandleFunc("/api/bulk", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if !requireFullAccess(w, r, "Listing bulk sends") {
+				return
+			}
+			jobs, err := messageStore.ListBulkJobs(50)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+
+		case http.MethodPost:
+			var req BulkSendRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			startBulk(w, messageStore, req)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/bulk/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/bulk/")
+		if id == "" {
+			http.Error(w, "Missing bulk send ID", http.StatusBadRequest)
+			return
+		}
+		// A job spans many chats, so it can't be shown in part
+		if !requireFullAccess(w, r, "A bulk send") {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			job, err := messageStore.GetBulkJob(id)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Bulk send not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{"job": job})
+
+		case http.MethodDelete:
+			cancelled, err := messageStore.CancelBulkJob(id)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !cancelled {
+				http.Error(w, "No running bulk send with this ID", http.StatusNotFound)
+				return
+			}
+			removeBulkMedia(id)
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": fmt.Sprintf("Bulk send %s cancelled", id),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/bulk/lists", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !requireFullAccess(w, r, "Recipient lists") {
+			return
+		}
+		lists, err := messageStore.ListRecipientLists()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"lists": lists})
+	})
+
+	http.HandleFunc("/api/bulk/lists/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/bulk/lists/")
+		if name == "" {
+			http.Error(w, "Missing list name", http.StatusBadRequest)
+			return
+		}
+		if !requireFullAccess(w, r, "Recipient lists") {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			recipients, err := messageStore.GetRecipientList(name)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Recipient list not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{"name": name, "recipients": recipients})
+
+		case http.MethodPut:
+			var req struct {
+				Recipients []BulkRecipient `json:"recipients"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if len(req.Recipients) == 0 || len(req.Recipients) > bulkMaxRecipients {
+				respondError(w, http.StatusBadRequest, fmt.Sprintf("A list needs 1 to %d recipients", bulkMaxRecipients))
+				return
+			}
+			for _, recipient := range req.Recipients {
+				if _, err := validRecipient(messageStore, recipient.Recipient); err != nil {
+					respondError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+			if err := messageStore.SaveRecipientList(name, req.Recipients); err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": fmt.Sprintf("Saved list %s with %d recipients", name, len(req.Recipients)),
+			})
+
+		case http.MethodDelete:
+			deleted, err := messageStore.DeleteRecipientList(name)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !deleted {
+				http.Error(w, "Recipient list not found", http.StatusNotFound)
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": fmt.Sprintf("Deleted list %s", name),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
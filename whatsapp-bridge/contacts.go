@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxContactResults caps the number of contacts a search returns
+const maxContactResults = 50
+
+// ContactRecord is a row of the contacts table. Empty fields never overwrite
+// what is already stored, so partial updates from different sources combine.
+type ContactRecord struct {
+	JID          string
+	PhoneNumber  string
+	FullName     string
+	FirstName    string
+	PushName     string
+	BusinessName string
+}
+
+// DisplayName picks the best name to show for a contact: the address book
+// name, then the verified business name, then the name they set themselves
+func (c ContactRecord) DisplayName() string {
+	for _, name := range []string{c.FullName, c.FirstName, c.BusinessName, c.PushName} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// contactFromJID starts a record for a user JID, filling in the phone number
+// when the JID is phone-number based
+func contactFromJID(jid types.JID) ContactRecord {
+	jid = jid.ToNonAD()
+	c := ContactRecord{JID: jid.String()}
+	if jid.Server == types.DefaultUserServer {
+		c.PhoneNumber = jid.User
+	}
+	return c
+}
+
+// UpsertContacts stores or updates contacts in one transaction
+func (store *MessageStore) UpsertContacts(contacts []ContactRecord) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(rebind(`
+		INSERT INTO contacts (jid, phone_number, full_name, first_name, push_name, business_name, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET
+			phone_number = COALESCE(NULLIF(EXCLUDED.phone_number, ''), contacts.phone_number),
+			full_name = COALESCE(NULLIF(EXCLUDED.full_name, ''), contacts.full_name),
+			first_name = COALESCE(NULLIF(EXCLUDED.first_name, ''), contacts.first_name),
+			push_name = COALESCE(NULLIF(EXCLUDED.push_name, ''), contacts.push_name),
+			business_name = COALESCE(NULLIF(EXCLUDED.business_name, ''), contacts.business_name),
+			updated_at = EXCLUDED.updated_at`))
+	if err != nil {
+		return fmt.Errorf("failed to prepare contact upsert: %v", err)
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, c := range contacts {
+		if c.JID == "" {
+			continue
+		}
+		// Contacts known by their phone number are stored under it
+		if jid, err := types.ParseJID(c.JID); err == nil && jid.Server == types.HiddenUserServer {
+			if pn, ok := store.pnForLID(jid); ok {
+				c.JID, c.PhoneNumber = pn.String(), pn.User
+			}
+		}
+		_, err := stmt.Exec(c.JID, c.PhoneNumber, c.FullName, c.FirstName, c.PushName, c.BusinessName, now)
+		if err != nil {
+			return fmt.Errorf("failed to store contact %s: %v", c.JID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetContact looks a contact up by JID, or by phone number when given a bare number
+func (store *MessageStore) GetContact(jidOrPhone string) (*ContactRecord, error) {
+	query := "SELECT jid, phone_number, full_name, first_name, push_name, business_name FROM contacts WHERE "
+	var arg string
+	if strings.Contains(jidOrPhone, "@") {
+		query += "jid = ?"
+		if jid, err := types.ParseJID(jidOrPhone); err == nil {
+			jid = jid.ToNonAD()
+			if pn, ok := store.pnForLID(jid); ok && jid.Server == types.HiddenUserServer {
+				jid = pn
+			}
+			jidOrPhone = jid.String()
+		}
+		arg = jidOrPhone
+	} else {
+		query += "phone_number = ?"
+		arg = strings.TrimPrefix(jidOrPhone, "+")
+	}
+
+	contacts, err := store.queryContacts(query+" LIMIT 1", arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &contacts[0], nil
+}
+
+func (store *MessageStore) queryContacts(query string, args ...interface{}) ([]ContactRecord, error) {
+	rows, err := store.db.Query(rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contacts []ContactRecord
+	for rows.Next() {
+		var c ContactRecord
+		var phone, fullName, firstName, pushName, businessName sql.NullString
+		if err := rows.Scan(&c.JID, &phone, &fullName, &firstName, &pushName, &businessName); err != nil {
+			return nil, err
+		}
+		c.PhoneNumber = phone.String
+		c.FullName = fullName.String
+		c.FirstName = firstName.String
+		c.PushName = pushName.String
+		c.BusinessName = businessName.String
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
+
+// SearchContacts finds contacts whose names or phone number match the query.
+// Matching is fuzzy: word prefixes, letters in order and small typos all count,
+// and results are ordered by how well they match.
+func (store *MessageStore) SearchContacts(query string) ([]Contact, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+
+	all, err := store.queryContacts(
+		"SELECT jid, phone_number, full_name, first_name, push_name, business_name FROM contacts WHERE jid NOT LIKE '%@g.us'",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		contact ContactRecord
+		score   int
+	}
+	var matches []scored
+	for _, c := range all {
+		if score := contactMatchScore(c, query); score > 0 {
+			matches = append(matches, scored{c, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return strings.ToLower(matches[i].contact.DisplayName()) < strings.ToLower(matches[j].contact.DisplayName())
+	})
+	if len(matches) > maxContactResults {
+		matches = matches[:maxContactResults]
+	}
+
+	contacts := make([]Contact, 0, len(matches))
+	for _, m := range matches {
+		phone := m.contact.PhoneNumber
+		if phone == "" {
+			phone = strings.Split(m.contact.JID, "@")[0]
+		}
+		contacts = append(contacts, Contact{
+			PhoneNumber:  phone,
+			Name:         m.contact.DisplayName(),
+			JID:          m.contact.JID,
+			FullName:     m.contact.FullName,
+			PushName:     m.contact.PushName,
+			BusinessName: m.contact.BusinessName,
+		})
+	}
+	return contacts, nil
+}
+
+// contactMatchScore rates how well a contact matches a search query, 0 for no match
+func contactMatchScore(c ContactRecord, query string) int {
+	best := 0
+
+	// Queries that look like phone numbers match on digits only
+	if digits := onlyDigits(query); len(digits) >= 3 && len(digits)*2 >= len(query) {
+		phone := c.PhoneNumber
+		if phone == "" {
+			phone = strings.Split(c.JID, "@")[0]
+		}
+		switch {
+		case phone == digits:
+			return 100
+		case strings.HasSuffix(phone, digits):
+			best = 85
+		case strings.Contains(phone, digits):
+			best = 75
+		}
+	}
+
+	q := normalizeName(query)
+	for _, name := range []string{c.FullName, c.FirstName, c.PushName, c.BusinessName} {
+		if name == "" {
+			continue
+		}
+		if score := nameMatchScore(normalizeName(name), q); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+func nameMatchScore(name, query string) int {
+	if name == "" || query == "" {
+		return 0
+	}
+	switch {
+	case name == query:
+		return 100
+	case strings.HasPrefix(name, query):
+		return 90
+	}
+
+	words := strings.Fields(name)
+	for _, w := range words {
+		if strings.HasPrefix(w, query) {
+			return 80
+		}
+	}
+	if strings.Contains(name, query) {
+		return 70
+	}
+
+	// Allow one typo in short queries and two in longer ones
+	queryRunes := []rune(query)
+	maxDist := 0
+	switch {
+	case len(queryRunes) >= 7:
+		maxDist = 2
+	case len(queryRunes) >= 4:
+		maxDist = 1
+	}
+	best := 0
+	if maxDist > 0 {
+		candidates := append(words, name)
+		for _, w := range candidates {
+			// Compare against the start of the word too, so "johm" finds "johnson"
+			if wr := []rune(w); len(wr) > len(queryRunes) {
+				if d := levenshtein(string(wr[:len(queryRunes)]), query); d <= maxDist {
+					best = max(best, 55-d*5)
+				}
+			}
+			if d := levenshtein(w, query); d <= maxDist {
+				best = max(best, 60-d*5)
+			}
+		}
+	}
+	if best == 0 && len(queryRunes) >= 3 && isSubsequence(queryRunes, name) {
+		best = 40
+	}
+	return best
+}
+
+// normalizeName lowercases a name and strips accents and punctuation
+func normalizeName(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// combining accent
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isSubsequence(sub []rune, s string) bool {
+	i := 0
+	for _, r := range s {
+		if i < len(sub) && sub[i] == r {
+			i++
+		}
+	}
+	return i == len(sub)
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// GetSenderName returns the best known name for a sender JID or phone number,
+// falling back to the name of their direct chat and finally the phone number
+func (store *MessageStore) GetSenderName(senderJID string) string {
+	jid, err := senderLookupJID(senderJID)
+	if err != nil {
+		return senderJID
+	}
+	if jid.Server == types.HiddenUserServer {
+		if pn, ok := store.pnForLID(jid); ok {
+			jid = pn
+		}
+	}
+
+	if contact, err := store.GetContact(jid.String()); err == nil {
+		if name := contact.DisplayName(); name != "" {
+			return name
+		}
+	}
+
+	var name string
+	err = store.db.QueryRow(
+		rebind("SELECT name FROM chats WHERE jid = ? LIMIT 1"),
+		jid.String(),
+	).Scan(&name)
+	if err == nil && name != "" {
+		return name
+	}
+
+	if jid.Server == types.DefaultUserServer {
+		return jid.User
+	}
+	return jid.String()
+}
+
+// senderLookupJID parses a sender given as a JID or a bare phone number
+func senderLookupJID(sender string) (types.JID, error) {
+	if strings.Contains(sender, "@") {
+		jid, err := types.ParseJID(sender)
+		if err != nil {
+			return types.JID{}, err
+		}
+		return jid.ToNonAD(), nil
+	}
+	return types.NewJID(strings.TrimPrefix(sender, "+"), types.DefaultUserServer), nil
+}
+
+// importDeviceContacts copies the contacts whatsmeow keeps in the device store
+func importDeviceContacts(client *whatsmeow.Client, messageStore *MessageStore) {
+	all, err := client.Store.Contacts.GetAllContacts(context.Background())
+	if err != nil {
+		fmt.Printf("Failed to read device contacts: %v\n", err)
+		return
+	}
+
+	records := make([]ContactRecord, 0, len(all))
+	for jid, info := range all {
+		if jid.Server == types.GroupServer {
+			continue
+		}
+		c := contactFromJID(jid)
+		c.FullName = info.FullName
+		c.FirstName = info.FirstName
+		c.PushName = info.PushName
+		c.BusinessName = info.BusinessName
+		records = append(records, c)
+	}
+	if err := messageStore.UpsertContacts(records); err != nil {
+		fmt.Printf("Failed to import device contacts: %v\n", err)
+		return
+	}
+	fmt.Printf("Imported %d contacts from the device store\n", len(records))
+}
+
+// handleContactEvent stores contact changes from push names, business names
+// and address book syncs
+func handleContactEvent(messageStore *MessageStore, evt interface{}) {
+	var records []ContactRecord
+	var pairs []lidPair
+	switch v := evt.(type) {
+	case *events.PushName:
+		c := contactFromJID(v.JID)
+		c.PushName = v.NewPushName
+		records = append(records, c)
+		if pair, ok := newLIDPair(v.JID, v.JIDAlt); ok {
+			pairs = append(pairs, pair)
+		}
+	case *events.BusinessName:
+		c := contactFromJID(v.JID)
+		c.BusinessName = v.NewBusinessName
+		records = append(records, c)
+	case *events.Contact:
+		c := contactFromJID(v.JID)
+		c.FullName = v.Action.GetFullName()
+		c.FirstName = v.Action.GetFirstName()
+		records = append(records, c)
+
+		lid, err1 := types.ParseJID(v.Action.GetLidJID())
+		pn, err2 := types.ParseJID(v.Action.GetPnJID())
+		if err1 == nil && err2 == nil {
+			if pair, ok := newLIDPair(lid, pn); ok {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+
+	// Mappings first, so the contact is stored under the phone number
+	if err := messageStore.PutLIDMappings(pairs); err != nil {
+		fmt.Printf("Failed to store LID mapping: %v\n", err)
+	}
+	if err := messageStore.UpsertContacts(records); err != nil {
+		fmt.Printf("Failed to store contact: %v\n", err)
+	}
+}
+
+// historySyncContacts collects the push names included in a history sync
+func historySyncContacts(historySync *events.HistorySync) []ContactRecord {
+	var records []ContactRecord
+	for _, p := range historySync.Data.GetPushnames() {
+		jid, err := types.ParseJID(p.GetID())
+		if err != nil || p.GetPushname() == "" || jid.Server == types.GroupServer {
+			continue
+		}
+		c := contactFromJID(jid)
+		c.PushName = p.GetPushname()
+		records = append(records, c)
+	}
+	return records
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Fields take *, numbers, ranges (1-5), steps
+// (*/15, 8-18/2), lists (1,15) and English names for months and weekdays.
+type cronSchedule struct {
+	minute, hour, dom, month, dow [61]bool
+
+	// Cron matches either day field when both are restricted
+	domAny, dowAny bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseCron parses a cron expression like "0 9 * * 1-5" or an alias like @daily
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[strings.ToLower(expr)]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression needs 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+
+	s := &cronSchedule{
+		domAny: fields[2] == "*" || fields[2] == "?",
+		dowAny: fields[4] == "*" || fields[4] == "?",
+	}
+	specs := []struct {
+		set      *[61]bool
+		min, max int
+		names    map[string]int
+		name     string
+	}{
+		{&s.minute, 0, 59, nil, "minute"},
+		{&s.hour, 0, 23, nil, "hour"},
+		{&s.dom, 1, 31, nil, "day of month"},
+		{&s.month, 1, 12, cronMonthNames, "month"},
+		{&s.dow, 0, 7, cronDayNames, "day of week"},
+	}
+	for i, spec := range specs {
+		if err := parseCronField(fields[i], spec.min, spec.max, spec.names, spec.set); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", spec.name, fields[i], err)
+		}
+	}
+	// 7 is Sunday too
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int, set *[61]bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return fmt.Errorf("bad step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" && rangePart != "?" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = cronValue(from, names); err != nil {
+				return err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(to, names); err != nil {
+					return err
+				}
+			} else if hasStep {
+				// 5/15 means from 5 to the end in steps of 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+func cronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	return v, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the schedule fires, in t's location, or
+// the zero time if it never does (like February 30th). The fields match the
+// wall clock, as in Vixie cron: a time skipped when the clocks go forward fires
+// once the gap ends, and a time shown twice when they go back fires only the
+// first time.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	// Search the wall clock in UTC, which has no gaps or repeats
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+	limit := wall.AddDate(5, 0, 0)
+	for {
+		wall = s.nextWall(wall.Add(time.Minute), limit)
+		if wall.IsZero() {
+			return time.Time{}
+		}
+		// A time in the repeated hour may already be behind t
+		if at := wallTime(wall, loc); at.After(t) {
+			return at
+		}
+	}
+}
+
+// nextWall returns the first wall clock time from t on the schedule matches,
+// or the zero time if there is none before limit
+func (s *cronSchedule) nextWall(t, limit time.Time) time.Time {
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// wallTime returns when the clocks in loc show the wall clock time w: the first
+// time if they show it twice, or the end of the gap if they skip it
+func wallTime(w time.Time, loc *time.Location) time.Time {
+	at := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, loc)
+	start, end := at.ZoneBounds()
+	if !sameWall(at, w) {
+		// time.Date moved the skipped time over the gap, into the zone after
+		// it or the one before
+		if wallAfter(at, w) {
+			return start
+		}
+		return end
+	}
+	if start.IsZero() {
+		return at
+	}
+	// Before the clocks went back, the same time came up an offset change earlier
+	_, offset := at.Zone()
+	_, prevOffset := start.Add(-time.Second).Zone()
+	if prevOffset > offset {
+		if earlier := at.Add(-time.Duration(prevOffset-offset) * time.Second); sameWall(earlier, w) {
+			return earlier
+		}
+	}
+	return at
+}
+
+// sameWall reports whether t shows the wall clock time w, to the minute
+func sameWall(t, w time.Time) bool {
+	y, m, d := t.Date()
+	wy, wm, wd := w.Date()
+	return y == wy && m == wm && d == wd && t.Hour() == w.Hour() && t.Minute() == w.Minute()
+}
+
+// wallAfter reports whether t shows a later wall clock time than w
+func wallAfter(t, w time.Time) bool {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).After(w)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// runs returns the next n times expr fires after from
+func runs(t *testing.T, expr string, from time.Time, n int) []time.Time {
+	t.Helper()
+	s, err := parseCron(expr)
+	if err != nil {
+		t.Fatalf("parseCron(%q): %v", expr, err)
+	}
+	var out []time.Time
+	for range n {
+		from = s.next(from)
+		if from.IsZero() {
+			break
+		}
+		out = append(out, from)
+	}
+	return out
+}
+
+func TestCronNext(t *testing.T) {
+	// Sunday 2026-10-18 10:00 UTC
+	from := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want []time.Time
+	}{
+		{"every minute", "* * * * *", []time.Time{at(10, 18, 10, 1), at(10, 18, 10, 2)}},
+		{"hourly alias", "@hourly", []time.Time{at(10, 18, 11, 0), at(10, 18, 12, 0)}},
+		{"daily alias", "@daily", []time.Time{at(10, 19, 0, 0), at(10, 20, 0, 0)}},
+		{"alias ignores case", "@DAILY", []time.Time{at(10, 19, 0, 0)}},
+		{"weekly alias runs on Sunday", "@weekly", []time.Time{at(10, 25, 0, 0), at(11, 1, 0, 0)}},
+		{"monthly alias", "@monthly", []time.Time{at(11, 1, 0, 0), at(12, 1, 0, 0)}},
+		{"yearly alias", "@yearly", []time.Time{time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)}},
+		{"step from a start", "5/15 10 * * *", []time.Time{at(10, 18, 10, 5), at(10, 18, 10, 20), at(10, 18, 10, 35), at(10, 18, 10, 50), at(10, 19, 10, 5)}},
+		{"step over everything", "*/20 10 * * *", []time.Time{at(10, 18, 10, 20), at(10, 18, 10, 40), at(10, 19, 10, 0)}},
+		{"step over a range", "0 8-14/3 * * *", []time.Time{at(10, 18, 11, 0), at(10, 18, 14, 0), at(10, 19, 8, 0)}},
+		{"list", "0 9,17 * * *", []time.Time{at(10, 18, 17, 0), at(10, 19, 9, 0)}},
+		{"weekday range", "0 9 * * 1-5", []time.Time{at(10, 19, 9, 0), at(10, 20, 9, 0)}},
+		{"weekday names", "0 9 * * mon-fri", []time.Time{at(10, 19, 9, 0), at(10, 20, 9, 0)}},
+		{"Sunday as 7", "0 9 * * 7", []time.Time{at(10, 25, 9, 0), at(11, 1, 9, 0)}},
+		{"Sunday as 0", "0 9 * * 0", []time.Time{at(10, 25, 9, 0), at(11, 1, 9, 0)}},
+		{"Sunday by name", "0 9 * * SUN", []time.Time{at(10, 25, 9, 0)}},
+		{"day of month only", "0 9 13 * *", []time.Time{at(11, 13, 9, 0), at(12, 13, 9, 0)}},
+		// The 13th or any Friday, not only Fridays the 13th
+		{"day of month or week", "0 9 13 * 5", []time.Time{at(10, 23, 9, 0), at(10, 30, 9, 0), at(11, 6, 9, 0), at(11, 13, 9, 0), at(11, 20, 9, 0)}},
+		{"day of month with any weekday", "0 9 13 * ?", []time.Time{at(11, 13, 9, 0)}},
+		{"month names", "0 0 1 jan,jul *", []time.Time{time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2027, 7, 1, 0, 0, 0, 0, time.UTC)}},
+		{"leap day", "0 0 29 2 *", []time.Time{time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)}},
+		{"never", "0 0 30 2 *", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runs(t, tt.expr, from, len(tt.want)+1)
+			if len(tt.want) < len(got) {
+				got = got[:len(tt.want)]
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("%q: got %v, want %v", tt.expr, got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("%q run %d: got %v, want %v", tt.expr, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCronNextKeepsLocation(t *testing.T) {
+	loc := mustLocation(t, "Asia/Tokyo")
+	got := runs(t, "0 9 * * *", time.Date(2026, 10, 18, 10, 0, 0, 0, loc), 1)
+	want := time.Date(2026, 10, 19, 9, 0, 0, 0, loc)
+	if len(got) != 1 || !got[0].Equal(want) || got[0].Location() != loc {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"x * * * *",
+		"* * * foo *",
+		"@fortnightly",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronDaylightSaving(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+	newYork := mustLocation(t, "America/New_York")
+	in := func(loc *time.Location, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, loc)
+	}
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		// Berlin skips 02:00-03:00 on 2026-03-29, 03:00 CEST is 01:00 UTC
+		{"skipped time runs when the gap ends", "30 2 * * *", in(berlin, 3, 28, 12, 0),
+			[]time.Time{utc(3, 29, 1, 0), utc(3, 30, 0, 30)}},
+		{"skipped hour runs once", "*/15 2 * * *", in(berlin, 3, 28, 12, 0),
+			[]time.Time{utc(3, 29, 1, 0), utc(3, 30, 0, 0)}},
+		{"time after the gap is unaffected", "30 3 * * *", in(berlin, 3, 28, 12, 0),
+			[]time.Time{utc(3, 29, 1, 30), utc(3, 30, 1, 30)}},
+		{"every minute across the gap", "* * * * *", in(berlin, 3, 29, 1, 58),
+			[]time.Time{utc(3, 29, 0, 59), utc(3, 29, 1, 0), utc(3, 29, 1, 1)}},
+		// Berlin shows 02:00-03:00 twice on 2026-10-25, first at +02:00
+		{"repeated time runs the first time", "30 2 * * *", in(berlin, 10, 24, 12, 0),
+			[]time.Time{utc(10, 25, 0, 30), utc(10, 26, 1, 30)}},
+		{"repeated hour runs once", "0,30 * * * *", in(berlin, 10, 25, 1, 45),
+			[]time.Time{utc(10, 25, 0, 0), utc(10, 25, 0, 30), utc(10, 25, 2, 0)}},
+		// time.Date picks the second 02:45, at +01:00
+		{"start in the repeated hour", "30 2 * * *", in(berlin, 10, 25, 2, 45),
+			[]time.Time{utc(10, 26, 1, 30)}},
+		// New York skips 02:00-03:00 on 2026-03-08 and repeats 01:00-02:00 on 2026-11-01
+		{"gap in New York", "15 2 * * *", in(newYork, 3, 7, 12, 0),
+			[]time.Time{utc(3, 8, 7, 0), utc(3, 9, 6, 15)}},
+		{"overlap in New York", "30 1 * * *", in(newYork, 10, 31, 12, 0),
+			[]time.Time{utc(11, 1, 5, 30), utc(11, 2, 6, 30)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runs(t, tt.expr, tt.from, len(tt.want))
+			if len(got) != len(tt.want) {
+				t.Fatalf("%q: got %v, want %v", tt.expr, got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("%q run %d: got %v, want %v", tt.expr, i, got[i].UTC(), tt.want[i])
+				}
+				if i > 0 && !got[i].After(got[i-1]) {
+					t.Errorf("%q run %d at %v is not after the previous one", tt.expr, i, got[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// Forwarding rebuilds a stored message and sends it with the forwarded flag.
+// Media is sent with the stored keys, so the file isn't uploaded again, unless
+// it is too old to still be on WhatsApp's servers or the keys are missing.
+
+// mediaReuseMaxAge is how old media may be to forward it with its stored keys.
+// Older media has often been removed from WhatsApp's servers.
+const mediaReuseMaxAge = 14 * 24 * time.Hour
+
+// ForwardMessageRequest is the body of POST /api/forward
+type ForwardMessageRequest struct {
+	ChatJID    string   `json:"chat_jid"`
+	MessageID  string   `json:"message_id"`
+	Recipients []string `json:"recipients"`
+}
+
+// ForwardResult is the outcome of forwarding to one recipient
+type ForwardResult struct {
+	Recipient string `json:"recipient"`
+	Success   bool   `json:"success"`
+	ID        string `json:"id,omitempty"`
+	Status    string `json:"status,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	ChatJID   string `json:"chat_jid,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// setItem fills in the state of the recipient's outbox item
+func (r *ForwardResult) setItem(item *OutboxItem) {
+	r.Success = item.Status != outboxFailed
+	r.ID = item.ID
+	r.Status = item.Status
+	r.MessageID = item.MessageID
+	r.ChatJID = item.ChatJID
+	r.Error = item.LastError
+}
+
+// buildForwardMessage loads a stored message and rebuilds it for sending
+func buildForwardMessage(client *whatsmeow.Client, messageStore *MessageStore, chatJID, messageID string) (*waE2E.Message, error) {
+	var (
+		content   sql.NullString
+		timestamp time.Time
+	)
+	err := messageStore.db.QueryRow(rebind("SELECT content, timestamp FROM messages WHERE id = ? AND chat_jid = ?"),
+		messageID, chatJID).Scan(&content, &timestamp)
+	if err != nil {
+		return nil, err
+	}
+	media, err := messageStore.GetMediaInfo(messageID, chatJID)
+	if err != nil {
+		return nil, err
+	}
+
+	contextInfo := &waE2E.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(1),
+	}
+
+	switch media.Type {
+	case "":
+		if content.String == "" {
+			return nil, fmt.Errorf("message has no content to forward")
+		}
+		return &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text:        proto.String(content.String),
+			ContextInfo: contextInfo,
+		}}, nil
+
+	case mediaTypeLocation, mediaTypeLiveLocation:
+		// A live location can't be shared on behalf of someone else, so the last
+		// known position is sent as a plain pin
+		return &waE2E.Message{LocationMessage: &waE2E.LocationMessage{
+			DegreesLatitude:  proto.Float64(media.Latitude),
+			DegreesLongitude: proto.Float64(media.Longitude),
+			Name:             nonEmpty(media.LocationName),
+			Address:          nonEmpty(media.LocationAddress),
+			ContextInfo:      contextInfo,
+		}}, nil
+
+	case mediaTypeContact:
+		return contactCardMessage(media.VCard, contextInfo)
+
+	case mediaTypePoll:
+		poll, err := messageStore.GetPollResults(messageID, chatJID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load poll: %v", err)
+		}
+		var options []string
+		for _, option := range poll.Options {
+			options = append(options, option.Name)
+		}
+		selectable := 1
+		if poll.MultipleAnswers {
+			selectable = 0
+		}
+		msg := client.BuildPollCreation(poll.Question, options, selectable)
+		if created := extractPoll(msg); created != nil {
+			created.ContextInfo = contextInfo
+		}
+		return msg, nil
+	}
+
+	if _, ok := whatsmeowMediaType(media.Type); !ok {
+		return nil, fmt.Errorf("cannot forward %s messages", media.Type)
+	}
+	complete := (media.URL != "" || media.DirectPath != "") && len(media.MediaKey) > 0 &&
+		len(media.FileSHA256) > 0 && len(media.FileEncSHA256) > 0 && media.FileLength > 0
+	if !complete || time.Since(timestamp) > mediaReuseMaxAge {
+		if err := reuploadMedia(client, messageStore, chatJID, messageID, media); err != nil {
+			return nil, err
+		}
+	}
+	return mediaMessage(media, contextInfo), nil
+}
+
+// reuploadMedia uploads the local copy of a message's media, downloading it
+// first if needed, and points the media info at the new upload
+func reuploadMedia(client *whatsmeow.Client, messageStore *MessageStore, chatJID, messageID string, media *MediaInfo) error {
+	_, _, _, path, err := downloadMedia(client, messageStore, messageID, chatJID)
+	if err != nil {
+		return fmt.Errorf("failed to get media to forward: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read media to forward: %v", err)
+	}
+
+	mediaType, _ := whatsmeowMediaType(media.Type)
+	resp, err := client.Upload(context.Background(), data, mediaType)
+	if err != nil {
+		return fmt.Errorf("error uploading media: %v", err)
+	}
+	media.URL = resp.URL
+	media.DirectPath = resp.DirectPath
+	media.MediaKey = resp.MediaKey
+	media.FileSHA256 = resp.FileSHA256
+	media.FileEncSHA256 = resp.FileEncSHA256
+	media.FileLength = resp.FileLength
+	return nil
+}
+
+// mediaMessage builds the message for stored media
+func mediaMessage(media *MediaInfo, contextInfo *waE2E.ContextInfo) *waE2E.Message {
+	switch media.Type {
+	case "image":
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileSHA256:    media.FileSHA256,
+			FileEncSHA256: media.FileEncSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			Mimetype:      proto.String(media.Mimetype),
+			Width:         proto.Uint32(media.Width),
+			Height:        proto.Uint32(media.Height),
+			Caption:       nonEmpty(media.Caption),
+			ContextInfo:   contextInfo,
+		}}
+	case "video", "ptv":
+		video := &waE2E.VideoMessage{
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileSHA256:    media.FileSHA256,
+			FileEncSHA256: media.FileEncSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			Mimetype:      proto.String(media.Mimetype),
+			Width:         proto.Uint32(media.Width),
+			Height:        proto.Uint32(media.Height),
+			Seconds:       proto.Uint32(media.Seconds),
+			Caption:       nonEmpty(media.Caption),
+			ContextInfo:   contextInfo,
+		}
+		if media.Type == "ptv" {
+			return &waE2E.Message{PtvMessage: video}
+		}
+		return &waE2E.Message{VideoMessage: video}
+	case "audio":
+		return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileSHA256:    media.FileSHA256,
+			FileEncSHA256: media.FileEncSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			Mimetype:      proto.String(media.Mimetype),
+			Seconds:       proto.Uint32(media.Seconds),
+			// Voice notes are Ogg Opus, other audio was shared as a file
+			PTT:         proto.Bool(strings.Contains(media.Mimetype, "ogg")),
+			ContextInfo: contextInfo,
+		}}
+	case "sticker":
+		return &waE2E.Message{StickerMessage: &waE2E.StickerMessage{
+			URL:           proto.String(media.URL),
+			DirectPath:    proto.String(media.DirectPath),
+			MediaKey:      media.MediaKey,
+			FileSHA256:    media.FileSHA256,
+			FileEncSHA256: media.FileEncSHA256,
+			FileLength:    proto.Uint64(media.FileLength),
+			Mimetype:      proto.String(media.Mimetype),
+			Width:         proto.Uint32(media.Width),
+			Height:        proto.Uint32(media.Height),
+			ContextInfo:   contextInfo,
+		}}
+	}
+
+	fileName := media.OriginalFilename
+	if fileName == "" {
+		fileName = media.Filename
+	}
+	return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+		URL:           proto.String(media.URL),
+		DirectPath:    proto.String(media.DirectPath),
+		MediaKey:      media.MediaKey,
+		FileSHA256:    media.FileSHA256,
+		FileEncSHA256: media.FileEncSHA256,
+		FileLength:    proto.Uint64(media.FileLength),
+		Mimetype:      proto.String(media.Mimetype),
+		Title:         proto.String(fileName),
+		FileName:      proto.String(fileName),
+		Caption:       nonEmpty(media.Caption),
+		ContextInfo:   contextInfo,
+	}}
+}
+
+// contactCardMessage rebuilds a stored contact card, which holds one vCard or
+// several joined by newlines
+func contactCardMessage(vcards string, contextInfo *waE2E.ContextInfo) (*waE2E.Message, error) {
+	var cards []*waE2E.ContactMessage
+	for _, part := range strings.SplitAfter(vcards, "END:VCARD") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		cards = append(cards, &waE2E.ContactMessage{
+			DisplayName: proto.String(parseVCard(part).Name),
+			Vcard:       proto.String(part),
+		})
+	}
+
+	switch len(cards) {
+	case 0:
+		return nil, fmt.Errorf("contact card has no vCard")
+	case 1:
+		cards[0].ContextInfo = contextInfo
+		return &waE2E.Message{ContactMessage: cards[0]}, nil
+	}
+	return &waE2E.Message{ContactsArrayMessage: &waE2E.ContactsArrayMessage{
+		DisplayName: proto.String(fmt.Sprintf("%d contacts", len(cards))),
+		Contacts:    cards,
+		ContextInfo: contextInfo,
+	}}, nil
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return proto.String(s)
+}
+
+// registerForwardRoutes serves POST /api/forward
+func registerForwardRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/forward", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ForwardMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" || req.MessageID == "" || len(req.Recipients) == 0 {
+			http.Error(w, "chat_jid, message_id and recipients are required", http.StatusBadRequest)
+			return
+		}
+		if !client.IsConnected() {
+			respondSend(w, false, "Not connected to WhatsApp")
+			return
+		}
+
+		// Messages of chats hidden from the caller can't be forwarded either
+		if !messageStore.chatVisible(requestAccess(r), req.ChatJID) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+
+		msg, err := buildForwardMessage(client, messageStore, req.ChatJID, req.MessageID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Every recipient gets its own outbox item, which decodes a fresh copy
+		payload, err := proto.Marshal(msg)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		results := make([]ForwardResult, 0, len(req.Recipients))
+		queued := 0
+		var refused []*rateLimitError
+		for _, recipient := range req.Recipients {
+			result := ForwardResult{Recipient: recipient}
+			item, err := queueSend(client, messageStore, string(client.GenerateMessageID()), outboxKindPrepared, recipient, payload)
+			var limited *rateLimitError
+			if errors.As(err, &limited) {
+				refused = append(refused, limited)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.setItem(item)
+				queued++
+			}
+			results = append(results, result)
+		}
+
+		// Wait for the queued forwards together, within the one wait allowed
+		if wait := sendWait(r); wait > 0 && queued > 0 {
+			deadline := time.Now().Add(wait)
+			for i := range results {
+				if results[i].ID == "" {
+					continue
+				}
+				if item, err := outbox.wait(messageStore, results[i].ID, max(0, time.Until(deadline))); err == nil {
+					results[i].setItem(item)
+				}
+			}
+		}
+
+		// Forwards still queued count, as the worker goes on delivering them
+		accepted := 0
+		for _, result := range results {
+			if result.Success {
+				accepted++
+			}
+		}
+
+		// Nothing was sent, so the caller should just try again later
+		if len(refused) == len(req.Recipients) {
+			soonest := refused[0]
+			for _, err := range refused[1:] {
+				if err.RetryAfter < soonest.RetryAfter {
+					soonest = err
+				}
+			}
+			respondRateLimited(w, soonest)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": accepted == len(req.Recipients),
+			"message": fmt.Sprintf("Forwarded to %d of %d recipients", accepted, len(req.Recipients)),
+			"results": results,
+		})
+	})
+}
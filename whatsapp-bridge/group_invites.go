@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// GroupInviteResponse is a group's current invite link
+type GroupInviteResponse struct {
+	JID  string `json:"jid"`
+	Link string `json:"link"`
+	Code string `json:"code"`
+}
+
+// GroupJoinRequest is the body of POST /api/groups/join
+type GroupJoinRequest struct {
+	Link string `json:"link"`
+}
+
+// GroupMembershipRequest is a pending request to join a group that needs admin approval
+type GroupMembershipRequest struct {
+	JID         string    `json:"jid"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// inviteCode extracts the code from an invite link, with or without the scheme,
+// or returns a bare code as is
+func inviteCode(link string) (string, error) {
+	code := strings.TrimSpace(link)
+	code = strings.TrimPrefix(code, "https://")
+	code = strings.TrimPrefix(code, "http://")
+	code = strings.TrimPrefix(code, "chat.whatsapp.com/")
+	code = strings.TrimPrefix(code, "invite/")
+	if i := strings.IndexAny(code, "?#"); i >= 0 {
+		code = code[:i]
+	}
+	code = strings.TrimSuffix(code, "/")
+	if code == "" || strings.ContainsAny(code, "/ ") {
+		return "", fmt.Errorf("invalid invite link or code %q", link)
+	}
+	return code, nil
+}
+
+// registerGroupInviteRoutes adds the invite link endpoints:
+//
+//	GET  /api/groups/preview?link=...   group info from a link without joining
+//	POST /api/groups/join               join with a link or code
+//
+// and, dispatched from the /api/groups/{jid} handler:
+//
+//	GET  /api/groups/{jid}/invite        current invite link
+//	POST /api/groups/{jid}/invite/reset  revoke the link and create a new one
+//	GET  /api/groups/{jid}/requests      pending membership requests
+//	POST /api/groups/{jid}/requests      approve or reject requests
+func registerGroupInviteRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/groups/preview", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		code, err := inviteCode(r.URL.Query().Get("link"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		info, err := client.GetGroupInfoFromLink(context.Background(), code)
+		if err != nil {
+			respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to get group info from link: %v", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"group": groupSummary(info, true),
+		})
+	})
+
+	http.HandleFunc("/api/groups/join", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req GroupJoinRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		code, err := inviteCode(req.Link)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		jid, err := client.JoinGroupWithLink(context.Background(), code)
+		if err != nil {
+			respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to join group: %v", err))
+			return
+		}
+
+		// Groups with admin approval don't let us read the info until we're accepted
+		name := ""
+		if info, err := client.GetGroupInfo(context.Background(), jid); err == nil {
+			name = info.Name
+		}
+		if err := messageStore.StoreChat(jid.String(), name, time.Now()); err != nil {
+			fmt.Printf("Failed to store joined group chat: %v\n", err)
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"jid":     jid.String(),
+			"name":    name,
+		})
+	})
+}
+
+// handleGroupInviteAction serves the invite and membership request actions of one group
+func handleGroupInviteAction(client *whatsmeow.Client, w http.ResponseWriter, r *http.Request, jid types.JID, action string) {
+	switch action {
+	case "invite", "invite/reset":
+		reset := action == "invite/reset"
+		if (reset && r.Method != http.MethodPost) || (!reset && r.Method != http.MethodGet) {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		link, err := client.GetGroupInviteLink(context.Background(), jid, reset)
+		if err != nil {
+			respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to get invite link: %v", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, GroupInviteResponse{
+			JID:  jid.String(),
+			Link: link,
+			Code: strings.TrimPrefix(link, whatsmeow.InviteLinkPrefix),
+		})
+
+	case "requests":
+		switch r.Method {
+		case http.MethodGet:
+			pending, err := client.GetGroupRequestParticipants(context.Background(), jid)
+			if err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to get membership requests: %v", err))
+				return
+			}
+			requests := make([]GroupMembershipRequest, 0, len(pending))
+			for _, p := range pending {
+				requests = append(requests, GroupMembershipRequest{JID: p.JID.String(), RequestedAt: p.RequestedAt})
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"requests": requests,
+				"count":    len(requests),
+			})
+
+		case http.MethodPost:
+			var req UpdateParticipantsRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			change := whatsmeow.ParticipantRequestChange(strings.ToLower(req.Action))
+			if change != whatsmeow.ParticipantChangeApprove && change != whatsmeow.ParticipantChangeReject {
+				http.Error(w, "Action must be approve or reject", http.StatusBadRequest)
+				return
+			}
+			participants, err := parseUserJIDs(req.Participants)
+			if err != nil || len(participants) == 0 {
+				http.Error(w, "At least one valid participant is required", http.StatusBadRequest)
+				return
+			}
+
+			result, err := client.UpdateGroupRequestParticipants(context.Background(), jid, participants, change)
+			if err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to %s membership requests: %v", change, err))
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success":      true,
+				"participants": participantInfos(result),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
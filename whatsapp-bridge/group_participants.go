@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Membership changes recorded in group_membership_events
+const (
+	membershipJoined        = "joined"
+	membershipJoinedViaLink = "joined_via_link"
+	membershipAdded         = "added"
+	membershipLeft          = "left"
+	membershipRemoved       = "removed"
+	membershipPromoted      = "promoted"
+	membershipDemoted       = "demoted"
+)
+
+// MembershipEvent is one change to who is in a group or who administers it
+type MembershipEvent struct {
+	GroupJID    string    `json:"group_jid"`
+	Participant string    `json:"participant"`
+	Action      string    `json:"action"`
+	Actor       string    `json:"actor,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// groupsSeen holds the groups whose participants were loaded since startup, so
+// GetGroupInfo is asked at most once per group for messages from unknown groups
+var groupsSeen sync.Map
+
+// SyncGroupParticipants replaces the stored participants of a group with the given list
+func (store *MessageStore) SyncGroupParticipants(groupJID string, participants []types.GroupParticipant) error {
+	// Group info carries both forms for members addressed by LID
+	var pairs []lidPair
+	for _, p := range participants {
+		if pair, ok := newLIDPair(p.JID, p.PhoneNumber); ok {
+			pairs = append(pairs, pair)
+		} else if pair, ok := newLIDPair(p.JID, p.LID); ok {
+			pairs = append(pairs, pair)
+		}
+	}
+	if err := store.PutLIDMappings(pairs); err != nil {
+		return err
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rebind("DELETE FROM group_participants WHERE group_jid = ?"), groupJID); err != nil {
+		return fmt.Errorf("failed to clear group participants: %v", err)
+	}
+	now := time.Now()
+	for _, p := range participants {
+		var phone, lid string
+		if !p.PhoneNumber.IsEmpty() {
+			phone = p.PhoneNumber.String()
+		}
+		if !p.LID.IsEmpty() {
+			lid = p.LID.String()
+		}
+		participant := p.JID.ToNonAD()
+		if participant.Server == types.HiddenUserServer && !p.PhoneNumber.IsEmpty() {
+			participant = p.PhoneNumber.ToNonAD()
+		}
+		_, err := tx.Exec(rebind(`
+			INSERT INTO group_participants (group_jid, participant_jid, phone_number, lid, is_admin, is_super_admin, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (group_jid, participant_jid) DO NOTHING`),
+			groupJID, participant.String(), phone, lid, p.IsAdmin || p.IsSuperAdmin, p.IsSuperAdmin, now,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store group participant: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// GetGroupParticipants returns the stored participants of a group
+func (store *MessageStore) GetGroupParticipants(groupJID string) ([]GroupParticipantInfo, error) {
+	rows, err := store.db.Query(rebind(`
+		SELECT participant_jid, phone_number, lid, is_admin, is_super_admin
+		FROM group_participants WHERE group_jid = ?
+		ORDER BY is_super_admin DESC, is_admin DESC, participant_jid`),
+		groupJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []GroupParticipantInfo
+	for rows.Next() {
+		var p GroupParticipantInfo
+		var phone, lid sql.NullString
+		if err := rows.Scan(&p.JID, &phone, &lid, &p.IsAdmin, &p.IsSuperAdmin); err != nil {
+			return nil, err
+		}
+		p.PhoneNumber, p.LID = phone.String, lid.String
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
+
+// hasGroupParticipants reports whether any participants are stored for a group
+func (store *MessageStore) hasGroupParticipants(groupJID string) bool {
+	var one int
+	err := store.db.QueryRow(
+		rebind("SELECT 1 FROM group_participants WHERE group_jid = ? LIMIT 1"), groupJID,
+	).Scan(&one)
+	return err == nil
+}
+
+// applyMembershipChange updates the stored participants and logs the change
+func (store *MessageStore) applyMembershipChange(groupJID string, participants []types.JID, action, actor string, timestamp time.Time) error {
+	for _, jid := range participants {
+		participant := store.storedJID(jid).String()
+
+		var err error
+		switch action {
+		case membershipJoined, membershipJoinedViaLink, membershipAdded:
+			_, err = store.db.Exec(rebind(`
+				INSERT INTO group_participants (group_jid, participant_jid, is_admin, is_super_admin, updated_at)
+				VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (group_jid, participant_jid) DO UPDATE SET updated_at = EXCLUDED.updated_at`),
+				groupJID, participant, false, false, timestamp,
+			)
+		case membershipLeft, membershipRemoved:
+			_, err = store.db.Exec(rebind(
+				"DELETE FROM group_participants WHERE group_jid = ? AND participant_jid = ?"),
+				groupJID, participant,
+			)
+		case membershipPromoted, membershipDemoted:
+			_, err = store.db.Exec(rebind(
+				"UPDATE group_participants SET is_admin = ?, updated_at = ? WHERE group_jid = ? AND participant_jid = ?"),
+				action == membershipPromoted, timestamp, groupJID, participant,
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update group participant: %v", err)
+		}
+
+		_, err = store.db.Exec(rebind(`
+			INSERT INTO group_membership_events (group_jid, participant_jid, action, actor_jid, timestamp)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (group_jid, participant_jid, action, timestamp) DO NOTHING`),
+			groupJID, participant, action, actor, timestamp,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to log membership change: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetMembershipEvents returns the membership changes between from and to, for
+// one group or for all of them when groupJID is empty
+func (store *MessageStore) GetMembershipEvents(groupJID string, from, to time.Time) ([]MembershipEvent, error) {
+	query := `
+		SELECT group_jid, participant_jid, action, actor_jid, timestamp
+		FROM group_membership_events
+		WHERE timestamp >= ? AND timestamp <= ?`
+	args := []interface{}{from, to}
+	if groupJID != "" {
+		query += " AND group_jid = ?"
+		args = append(args, groupJID)
+	}
+	query += " ORDER BY timestamp"
+
+	rows, err := store.db.Query(rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []MembershipEvent
+	for rows.Next() {
+		var e MembershipEvent
+		var actor sql.NullString
+		if err := rows.Scan(&e.GroupJID, &e.Participant, &e.Action, &actor, &e.Timestamp); err != nil {
+			return nil, err
+		}
+		e.Actor = actor.String
+		changes = append(changes, e)
+	}
+	return changes, rows.Err()
+}
+
+// describeMembershipEvent renders a change as a sentence, e.g. "Alice added Bob"
+func (store *MessageStore) describeMembershipEvent(e MembershipEvent) string {
+	who := store.GetSenderName(e.Participant)
+	by := ""
+	if e.Actor != "" && e.Actor != e.Participant {
+		by = store.GetSenderName(e.Actor)
+	}
+
+	switch e.Action {
+	case membershipJoinedViaLink:
+		return who + " joined using an invite link"
+	case membershipAdded:
+		if by != "" {
+			return by + " added " + who
+		}
+		return who + " was added"
+	case membershipLeft:
+		return who + " left"
+	case membershipRemoved:
+		if by != "" {
+			return by + " removed " + who
+		}
+		return who + " was removed"
+	case membershipPromoted, membershipDemoted:
+		role := "now an admin"
+		if e.Action == membershipDemoted {
+			role = "no longer an admin"
+		}
+		if by != "" {
+			return fmt.Sprintf("%s is %s (by %s)", who, role, by)
+		}
+		return fmt.Sprintf("%s is %s", who, role)
+	}
+	return who + " joined"
+}
+
+// withMembershipEvents merges the membership changes of the shown time range
+// into a list of messages as system lines, keeping the list's order
+func (store *MessageStore) withMembershipEvents(msgs []MessageInteraction, groupJID string, from, to time.Time, newestFirst bool) []MessageInteraction {
+	if groupJID != "" && !strings.HasSuffix(groupJID, "@"+types.GroupServer) {
+		return msgs
+	}
+	changes, err := store.GetMembershipEvents(groupJID, from, to)
+	if err != nil {
+		fmt.Printf("Failed to load membership changes: %v\n", err)
+		return msgs
+	}
+	if len(changes) == 0 {
+		return msgs
+	}
+
+	chatNames := make(map[string]string)
+	for _, m := range msgs {
+		chatNames[m.ChatJID] = m.ChatName
+	}
+	for _, e := range changes {
+		name, ok := chatNames[e.GroupJID]
+		if !ok {
+			store.db.QueryRow(rebind("SELECT name FROM chats WHERE jid = ?"), e.GroupJID).Scan(&name)
+			chatNames[e.GroupJID] = name
+		}
+		msgs = append(msgs, MessageInteraction{
+			Timestamp: e.Timestamp,
+			Sender:    e.Actor,
+			Content:   store.describeMembershipEvent(e),
+			ChatJID:   e.GroupJID,
+			ChatName:  name,
+			IsSystem:  true,
+		})
+	}
+
+	sort.SliceStable(msgs, func(i, j int) bool {
+		if newestFirst {
+			return msgs[i].Timestamp.After(msgs[j].Timestamp)
+		}
+		return msgs[i].Timestamp.Before(msgs[j].Timestamp)
+	})
+	return msgs
+}
+
+// loadGroupParticipants fetches the participants of a group the first time a
+// message from it is seen, if none are stored yet
+func loadGroupParticipants(client *whatsmeow.Client, messageStore *MessageStore, jid types.JID) {
+	if jid.Server != types.GroupServer {
+		return
+	}
+	if _, seen := groupsSeen.LoadOrStore(jid.String(), true); seen {
+		return
+	}
+	if messageStore.hasGroupParticipants(jid.String()) {
+		return
+	}
+
+	go func() {
+		info, err := client.GetGroupInfo(context.Background(), jid)
+		if err != nil {
+			fmt.Printf("Failed to get participants of %s: %v\n", jid, err)
+			return
+		}
+		if err := messageStore.SyncGroupParticipants(jid.String(), info.Participants); err != nil {
+			fmt.Printf("Failed to store participants of %s: %v\n", jid, err)
+		}
+	}()
+}
+
+// handleGroupInfo keeps the stored participants and group name current
+func handleGroupInfo(messageStore *MessageStore, evt *events.GroupInfo) {
+	groupJID := evt.JID.String()
+	timestamp := evt.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	if evt.Sender != nil && evt.SenderPN != nil {
+		if pair, ok := newLIDPair(*evt.Sender, *evt.SenderPN); ok {
+			if err := messageStore.PutLIDMappings([]lidPair{pair}); err != nil {
+				fmt.Printf("Failed to store LID mapping: %v\n", err)
+			}
+		}
+	}
+
+	actor := ""
+	var actorJID types.JID
+	if evt.Sender != nil {
+		actorJID = messageStore.storedJID(*evt.Sender)
+		actor = actorJID.String()
+	}
+
+	// Someone in Join or Leave is either acting on themselves or acted on by the sender
+	var joined, added, left, removed []types.JID
+	for _, jid := range evt.Join {
+		switch {
+		case evt.JoinReason == "invite":
+			joined = append(joined, jid)
+		case actor == "" || messageStore.storedJID(jid) == actorJID:
+			joined = append(joined, jid)
+		default:
+			added = append(added, jid)
+		}
+	}
+	for _, jid := range evt.Leave {
+		if actor == "" || messageStore.storedJID(jid) == actorJID {
+			left = append(left, jid)
+		} else {
+			removed = append(removed, jid)
+		}
+	}
+
+	joinAction := membershipJoined
+	if evt.JoinReason == "invite" {
+		joinAction = membershipJoinedViaLink
+	}
+	for _, change := range []struct {
+		jids   []types.JID
+		action string
+	}{
+		{joined, joinAction},
+		{added, membershipAdded},
+		{left, membershipLeft},
+		{removed, membershipRemoved},
+		{evt.Promote, membershipPromoted},
+		{evt.Demote, membershipDemoted},
+	} {
+		if len(change.jids) == 0 {
+			continue
+		}
+		if err := messageStore.applyMembershipChange(groupJID, change.jids, change.action, actor, timestamp); err != nil {
+			fmt.Printf("Failed to record membership change in %s: %v\n", groupJID, err)
+		}
+	}
+
+	if evt.Name != nil && evt.Name.Name != "" {
+		if err := messageStore.UpdateChatName(groupJID, evt.Name.Name); err != nil {
+			fmt.Printf("Failed to rename stored chat: %v\n", err)
+		}
+	}
+}
+
+// handleJoinedGroup stores a group we were added to or joined, with its participants
+func handleJoinedGroup(client *whatsmeow.Client, messageStore *MessageStore, evt *events.JoinedGroup) {
+	groupJID := evt.JID.String()
+	groupsSeen.Store(groupJID, true)
+
+	if err := messageStore.StoreChat(groupJID, evt.Name, time.Now()); err != nil {
+		fmt.Printf("Failed to store joined group chat: %v\n", err)
+	}
+	if err := messageStore.SyncGroupParticipants(groupJID, evt.Participants); err != nil {
+		fmt.Printf("Failed to store participants of %s: %v\n", groupJID, err)
+	}
+
+	if client.Store.ID == nil {
+		return
+	}
+	self := client.Store.ID.ToNonAD()
+	action, actor := membershipJoined, ""
+	if evt.Reason == "invite" {
+		action = membershipJoinedViaLink
+	} else if evt.Sender != nil && messageStore.storedJID(*evt.Sender) != self {
+		action, actor = membershipAdded, messageStore.storedJID(*evt.Sender).String()
+	}
+	if err := messageStore.applyMembershipChange(groupJID, []types.JID{self}, action, actor, time.Now()); err != nil {
+		fmt.Printf("Failed to record joining %s: %v\n", groupJID, err)
+	}
+}
+
+// storedJID is the form a group member is stored under: their phone number JID
+// when the mapping from their LID is known
+func (store *MessageStore) storedJID(jid types.JID) types.JID {
+	jid = jid.ToNonAD()
+	if jid.Server == types.HiddenUserServer {
+		if pn, ok := store.pnForLID(jid); ok {
+			return pn
+		}
+	}
+	return jid
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"golang.org/x/image/draw"
+)
+
+// groupPhotoSize is the side of the square picture WhatsApp expects for groups
+const groupPhotoSize = 640
+
+// GroupSummary is a group as returned by the group endpoints
+type GroupSummary struct {
+	JID              string                 `json:"jid"`
+	Name             string                 `json:"name"`
+	Topic            string                 `json:"topic,omitempty"`
+	Owner            string                 `json:"owner,omitempty"`
+	Created          *time.Time             `json:"created,omitempty"`
+	ParticipantCount int                    `json:"participant_count"`
+	IsAnnounce       bool                   `json:"is_announce"`
+	IsLocked         bool                   `json:"is_locked"`
+	Participants     []GroupParticipantInfo `json:"participants,omitempty"`
+}
+
+// GroupParticipantInfo is one member of a group
+type GroupParticipantInfo struct {
+	JID          string `json:"jid"`
+	PhoneNumber  string `json:"phone_number,omitempty"`
+	LID          string `json:"lid,omitempty"`
+	IsAdmin      bool   `json:"is_admin"`
+	IsSuperAdmin bool   `json:"is_super_admin"`
+	Error        int    `json:"error,omitempty"`
+}
+
+// CreateGroupRequest is the body of POST /api/groups
+type CreateGroupRequest struct {
+	Name         string   `json:"name"`
+	Participants []string `json:"participants"`
+}
+
+// UpdateParticipantsRequest is the body of POST /api/groups/{jid}/participants
+type UpdateParticipantsRequest struct {
+	Action       string   `json:"action"`
+	Participants []string `json:"participants"`
+}
+
+// UpdateGroupRequest carries the new subject, description or photo of a group
+type UpdateGroupRequest struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImagePath   string `json:"image_path,omitempty"`
+}
+
+// parseUserJID accepts a full JID or a bare phone number
+func parseUserJID(s string) (types.JID, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "@") {
+		return types.ParseJID(s)
+	}
+	phone, err := normalizePhoneNumber(s)
+	if err != nil {
+		return types.JID{}, err
+	}
+	return types.NewJID(phone, types.DefaultUserServer), nil
+}
+
+// parseGroupJID accepts a full group JID or just its numeric part
+func parseGroupJID(s string) (types.JID, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "@") {
+		s += "@" + types.GroupServer
+	}
+	jid, err := types.ParseJID(s)
+	if err != nil {
+		return jid, err
+	}
+	if jid.Server != types.GroupServer {
+		return jid, fmt.Errorf("%s is not a group JID", s)
+	}
+	return jid, nil
+}
+
+func parseUserJIDs(values []string) ([]types.JID, error) {
+	jids := make([]types.JID, 0, len(values))
+	for _, v := range values {
+		jid, err := parseUserJID(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid participant %q: %v", v, err)
+		}
+		jids = append(jids, jid)
+	}
+	return jids, nil
+}
+
+func groupSummary(info *types.GroupInfo, withParticipants bool) GroupSummary {
+	summary := GroupSummary{
+		JID:              info.JID.String(),
+		Name:             info.Name,
+		Topic:            info.Topic,
+		ParticipantCount: info.ParticipantCount,
+		IsAnnounce:       info.IsAnnounce,
+		IsLocked:         info.IsLocked,
+	}
+	if !info.OwnerJID.IsEmpty() {
+		summary.Owner = info.OwnerJID.String()
+	}
+	if !info.GroupCreated.IsZero() {
+		created := info.GroupCreated
+		summary.Created = &created
+	}
+	if summary.ParticipantCount == 0 {
+		summary.ParticipantCount = len(info.Participants)
+	}
+	if withParticipants {
+		summary.Participants = participantInfos(info.Participants)
+	}
+	return summary
+}
+
+func participantInfos(participants []types.GroupParticipant) []GroupParticipantInfo {
+	infos := make([]GroupParticipantInfo, 0, len(participants))
+	for _, p := range participants {
+		info := GroupParticipantInfo{
+			JID:          p.JID.String(),
+			IsAdmin:      p.IsAdmin,
+			IsSuperAdmin: p.IsSuperAdmin,
+			Error:        p.Error,
+		}
+		if !p.PhoneNumber.IsEmpty() {
+			info.PhoneNumber = p.PhoneNumber.String()
+		}
+		if !p.LID.IsEmpty() {
+			info.LID = p.LID.String()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// groupPhoto loads an image and turns it into the square JPEG WhatsApp accepts
+func groupPhoto(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	// Center crop to a square, then scale
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+	size := min(side, groupPhotoSize)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode photo: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UpdateChatName Rename a chat, e.g. after its group subject changed
+func (store *MessageStore) UpdateChatName(jid, name string) error {
+	_, err := store.db.Exec(rebind("UPDATE chats SET name = ? WHERE jid = ?"), name, jid)
+	return err
+}
+
+// registerGroupRoutes adds the group management endpoints:
+//
+//	GET  /api/groups                          list joined groups
+//	POST /api/groups                          create a group
+//	GET  /api/groups/{jid}                    group info with participants
+//	POST /api/groups/{jid}/participants       add, remove, promote or demote
+//	POST /api/groups/{jid}/subject            change the name
+//	POST /api/groups/{jid}/description        change the description
+//	POST /api/groups/{jid}/photo              change the picture
+//	POST /api/groups/{jid}/leave              leave the group
+//
+// The invite link endpoints are added by registerGroupInviteRoutes.
+func registerGroupRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	registerGroupInviteRoutes(client, messageStore)
+
+	http.HandleFunc("/api/groups", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			groups, err := client.GetJoinedGroups(context.Background())
+			if err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to list groups: %v", err))
+				return
+			}
+			access := requestAccess(r)
+			summaries := make([]GroupSummary, 0, len(groups))
+			for _, g := range groups {
+				if messageStore.chatVisible(access, g.JID.String()) {
+					summaries = append(summaries, groupSummary(g, false))
+				}
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"groups": summaries,
+				"count":  len(summaries),
+			})
+
+		case http.MethodPost:
+			var req CreateGroupRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if strings.TrimSpace(req.Name) == "" {
+				http.Error(w, "Group name is required", http.StatusBadRequest)
+				return
+			}
+			participants, err := parseUserJIDs(req.Participants)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			info, err := client.CreateGroup(context.Background(), whatsmeow.ReqCreateGroup{
+				Name:         req.Name,
+				Participants: participants,
+			})
+			if err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to create group: %v", err))
+				return
+			}
+			if err := messageStore.StoreChat(info.JID.String(), info.Name, time.Now()); err != nil {
+				fmt.Printf("Failed to store new group chat: %v\n", err)
+			}
+			if err := messageStore.SyncGroupParticipants(info.JID.String(), info.Participants); err != nil {
+				fmt.Printf("Failed to store group participants: %v\n", err)
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"group": groupSummary(info, true),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/groups/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/groups/"), "/", 2)
+		jid, err := parseGroupJID(parts[0])
+		if parts[0] == "" || err != nil {
+			http.Error(w, "Invalid group JID", http.StatusBadRequest)
+			return
+		}
+		if !messageStore.chatVisible(requestAccess(r), jid.String()) {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		action := ""
+		if len(parts) == 2 {
+			action = parts[1]
+		}
+
+		if action == "" {
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			info, err := client.GetGroupInfo(context.Background(), jid)
+			if err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to get group info: %v", err))
+				return
+			}
+			if err := messageStore.SyncGroupParticipants(jid.String(), info.Participants); err != nil {
+				fmt.Printf("Failed to store group participants: %v\n", err)
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"group": groupSummary(info, true),
+			})
+			return
+		}
+
+		// Invite links and membership requests also answer GET
+		if action == "invite" || action == "invite/reset" || action == "requests" {
+			handleGroupInviteAction(client, w, r, jid, action)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch action {
+		case "participants":
+			var req UpdateParticipantsRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			change := whatsmeow.ParticipantChange(strings.ToLower(req.Action))
+			switch change {
+			case whatsmeow.ParticipantChangeAdd, whatsmeow.ParticipantChangeRemove,
+				whatsmeow.ParticipantChangePromote, whatsmeow.ParticipantChangeDemote:
+			default:
+				http.Error(w, "Action must be add, remove, promote or demote", http.StatusBadRequest)
+				return
+			}
+			participants, err := parseUserJIDs(req.Participants)
+			if err != nil || len(participants) == 0 {
+				http.Error(w, "At least one valid participant is required", http.StatusBadRequest)
+				return
+			}
+
+			result, err := client.UpdateGroupParticipants(context.Background(), jid, participants, change)
+			if err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to %s participants: %v", change, err))
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success":      true,
+				"participants": participantInfos(result),
+			})
+
+		case "subject", "description", "photo":
+			var req UpdateGroupRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+
+			var err error
+			switch action {
+			case "subject":
+				if strings.TrimSpace(req.Name) == "" {
+					http.Error(w, "Name is required", http.StatusBadRequest)
+					return
+				}
+				if err = client.SetGroupName(context.Background(), jid, req.Name); err == nil {
+					if err := messageStore.UpdateChatName(jid.String(), req.Name); err != nil {
+						fmt.Printf("Failed to rename stored chat: %v\n", err)
+					}
+				}
+			case "description":
+				err = client.SetGroupDescription(context.Background(), jid, req.Description)
+			case "photo":
+				// An empty path removes the current photo
+				var photo []byte
+				if req.ImagePath != "" {
+					if photo, err = groupPhoto(req.ImagePath); err != nil {
+						respondError(w, http.StatusBadRequest, err.Error())
+						return
+					}
+				}
+				_, err = client.SetGroupPhoto(context.Background(), jid, photo)
+			}
+			if err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to update group %s: %v", action, err))
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": fmt.Sprintf("Group %s updated", action),
+			})
+
+		case "leave":
+			if err := client.LeaveGroup(context.Background(), jid); err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to leave group: %v", err))
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": "Left group " + jid.String(),
+			})
+
+		default:
+			http.Error(w, "Unknown group action", http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// A person can be addressed by their phone number JID (123@s.whatsapp.net) or by
+// a linked identity (456@lid), and newer traffic increasingly uses the latter.
+// The bridge keeps its own copy of the mapping between the two so every message
+// and contact can be filed under the phone number when it is known.
+
+// lidPair is one LID to phone number mapping
+type lidPair struct {
+	LID types.JID
+	PN  types.JID
+}
+
+// newLIDPair orders two alternative JIDs of the same user, if one is a LID and
+// the other a phone number
+func newLIDPair(a, b types.JID) (lidPair, bool) {
+	a, b = a.ToNonAD(), b.ToNonAD()
+	switch {
+	case a.Server == types.HiddenUserServer && b.Server == types.DefaultUserServer:
+		return lidPair{LID: a, PN: b}, true
+	case a.Server == types.DefaultUserServer && b.Server == types.HiddenUserServer:
+		return lidPair{LID: b, PN: a}, true
+	}
+	return lidPair{}, false
+}
+
+// PutLIDMappings stores LID to phone number mappings. When a mapping is new,
+// what was stored under the LID is moved to the phone number.
+func (store *MessageStore) PutLIDMappings(pairs []lidPair) error {
+	for _, pair := range pairs {
+		if pair.LID.IsEmpty() || pair.PN.IsEmpty() {
+			continue
+		}
+		lid, pn := pair.LID.ToNonAD().String(), pair.PN.ToNonAD().String()
+
+		var known string
+		err := store.db.QueryRow(rebind("SELECT pn FROM lid_mappings WHERE lid = ?"), lid).Scan(&known)
+		if err == nil && known == pn {
+			continue
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to read LID mapping: %v", err)
+		}
+
+		_, err = store.db.Exec(rebind(`
+			INSERT INTO lid_mappings (lid, pn, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (lid) DO UPDATE SET pn = EXCLUDED.pn, updated_at = EXCLUDED.updated_at`),
+			lid, pn, time.Now(),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store LID mapping: %v", err)
+		}
+		if err := store.mergeLIDIdentity(pair.LID.ToNonAD(), pair.PN.ToNonAD()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeLIDIdentity moves messages, contact details and group memberships
+// stored under a LID to the phone number it belongs to
+func (store *MessageStore) mergeLIDIdentity(lid, pn types.JID) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(rebind("UPDATE messages SET sender = ? WHERE sender = ?"),
+		senderKey(pn), lid.String())
+	if err != nil {
+		return fmt.Errorf("failed to update message senders: %v", err)
+	}
+
+	// Copy names only known under the LID, then drop the LID contact
+	_, err = tx.Exec(rebind(`
+		INSERT INTO contacts (jid, phone_number, full_name, first_name, push_name, business_name, updated_at)
+		SELECT ?, ?, full_name, first_name, push_name, business_name, updated_at
+		FROM contacts WHERE jid = ?
+		ON CONFLICT (jid) DO UPDATE SET
+			full_name = COALESCE(NULLIF(contacts.full_name, ''), EXCLUDED.full_name),
+			first_name = COALESCE(NULLIF(contacts.first_name, ''), EXCLUDED.first_name),
+			push_name = COALESCE(NULLIF(contacts.push_name, ''), EXCLUDED.push_name),
+			business_name = COALESCE(NULLIF(contacts.business_name, ''), EXCLUDED.business_name)`),
+		pn.String(), pn.User, lid.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to merge contact: %v", err)
+	}
+	if _, err := tx.Exec(rebind("DELETE FROM contacts WHERE jid = ?"), lid.String()); err != nil {
+		return fmt.Errorf("failed to merge contact: %v", err)
+	}
+
+	// A group may list the person under both forms; keep the phone number row
+	_, err = tx.Exec(rebind(`
+		DELETE FROM group_participants WHERE participant_jid = ?
+		AND group_jid IN (SELECT group_jid FROM group_participants WHERE participant_jid = ?)`),
+		lid.String(), pn.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to merge group participant: %v", err)
+	}
+	_, err = tx.Exec(rebind("UPDATE group_participants SET participant_jid = ?, lid = ? WHERE participant_jid = ?"),
+		pn.String(), lid.String(), lid.String())
+	if err != nil {
+		return fmt.Errorf("failed to merge group participant: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// pnForLID returns the phone number JID of a LID, if known
+func (store *MessageStore) pnForLID(lid types.JID) (types.JID, bool) {
+	var pn string
+	err := store.db.QueryRow(rebind("SELECT pn FROM lid_mappings WHERE lid = ?"), lid.ToNonAD().String()).Scan(&pn)
+	if err != nil {
+		return types.JID{}, false
+	}
+	jid, err := types.ParseJID(pn)
+	return jid, err == nil
+}
+
+// lidForPN returns the LID of a phone number JID, if known
+func (store *MessageStore) lidForPN(pn types.JID) (types.JID, bool) {
+	var lid string
+	err := store.db.QueryRow(rebind("SELECT lid FROM lid_mappings WHERE pn = ? LIMIT 1"), pn.ToNonAD().String()).Scan(&lid)
+	if err != nil {
+		return types.JID{}, false
+	}
+	jid, err := types.ParseJID(lid)
+	return jid, err == nil
+}
+
+// canonicalJID returns the JID a user is stored under: the phone number JID when
+// known, otherwise the JID as given, without the device part. Mappings the bridge
+// hasn't seen are looked up in whatsmeow's device store.
+func canonicalJID(client *whatsmeow.Client, messageStore *MessageStore, jid types.JID) types.JID {
+	jid = jid.ToNonAD()
+	if jid.Server != types.HiddenUserServer {
+		return jid
+	}
+	if pn, ok := messageStore.pnForLID(jid); ok {
+		return pn
+	}
+	if client == nil {
+		return jid
+	}
+	pn, err := client.Store.LIDs.GetPNForLID(context.Background(), jid)
+	if err != nil || pn.IsEmpty() {
+		return jid
+	}
+	if err := messageStore.PutLIDMappings([]lidPair{{LID: jid, PN: pn}}); err != nil {
+		fmt.Printf("Failed to store LID mapping: %v\n", err)
+	}
+	return pn.ToNonAD()
+}
+
+// senderKey is how a sender is written to messages.sender: the full JID without
+// the device part, so senders can be matched exactly
+func senderKey(jid types.JID) string {
+	return jid.ToNonAD().String()
+}
+
+// contactIdentities returns every JID a contact may appear as in the store,
+// covering both the phone number and the LID form: the sender values and the
+// JIDs of their direct chats
+func (store *MessageStore) contactIdentities(jidOrPhone string) (senders, chats []string) {
+	var pn, lid types.JID
+	jid, err := senderLookupJID(jidOrPhone)
+	if err != nil {
+		return []string{jidOrPhone}, []string{jidOrPhone}
+	}
+	if jid.Server == types.HiddenUserServer {
+		lid = jid
+		pn, _ = store.pnForLID(jid)
+	} else {
+		pn = jid
+	}
+	if !pn.IsEmpty() && lid.IsEmpty() && pn.Server == types.DefaultUserServer {
+		lid, _ = store.lidForPN(pn)
+	}
+
+	for _, jid := range []types.JID{pn, lid} {
+		if !jid.IsEmpty() {
+			senders = append(senders, senderKey(jid))
+			chats = append(chats, jid.String())
+		}
+	}
+	return senders, chats
+}
+
+// placeholders returns "?, ?, ..." for an IN clause with n values
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// messageLIDPairs collects the mappings a message reveals about its sender
+func messageLIDPairs(info types.MessageInfo) []lidPair {
+	var pairs []lidPair
+	if pair, ok := newLIDPair(info.Sender, info.SenderAlt); ok {
+		pairs = append(pairs, pair)
+	}
+	if !info.IsGroup {
+		if pair, ok := newLIDPair(info.Chat, info.RecipientAlt); ok {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// historySyncLIDPairs collects the mappings included in a history sync
+func historySyncLIDPairs(historySync *events.HistorySync) []lidPair {
+	var pairs []lidPair
+	for _, m := range historySync.Data.GetPhoneNumberToLidMappings() {
+		lid, err1 := types.ParseJID(m.GetLidJID())
+		pn, err2 := types.ParseJID(m.GetPnJID())
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if pair, ok := newLIDPair(lid, pn); ok {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
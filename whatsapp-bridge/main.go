@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -49,6 +50,7 @@ type MessageInteraction struct {
 	ID        string    `json:"id"`
 	ChatName  string    `json:"chat_name,omitempty"`
 	MediaType string    `json:"media_type,omitempty"`
+	IsSystem  bool      `json:"is_system,omitempty"`
 }
 
 type Chat struct {
@@ -58,6 +60,8 @@ type Chat struct {
 	LastMessage     string    `json:"last_message,omitempty"`
 	LastSender      string    `json:"last_sender,omitempty"`
 	LastIsFromMe    bool      `json:"last_is_from_me,omitempty"`
+
+	Presence *ChatPresenceInfo `json:"presence,omitempty"`
 }
 
 func (c *Chat) IsGroup() bool {
@@ -65,9 +69,12 @@ func (c *Chat) IsGroup() bool {
 }
 
 type Contact struct {
-	PhoneNumber string `json:"phone_number"`
-	Name        string `json:"name,omitempty"`
-	JID         string `json:"jid"`
+	PhoneNumber  string `json:"phone_number"`
+	Name         string `json:"name,omitempty"`
+	JID          string `json:"jid"`
+	FullName     string `json:"full_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
 }
 
 type MessageContext struct {
@@ -85,6 +92,8 @@ type ListMessagesParams struct {
 	IncludeContext    bool
 	ContextBefore     int
 	ContextAfter      int
+	// Access limits the messages to the chats the caller may read
+	Access chatAccess
 }
 
 type Message struct {
@@ -97,7 +106,8 @@ type Message struct {
 }
 
 type MessageStore struct {
-	db *sql.DB
+	db    *sql.DB
+	media *MediaCache
 }
 
 type dbConfig struct {
@@ -110,6 +120,57 @@ type dbConfig struct {
 
 var isPostgres = false
 
+var placeholderPattern = regexp.MustCompile(`\?`)
+
+// rebind converts the ? placeholders of a query to $1, $2, ... when running on postgres
+func rebind(query string) string {
+	if !isPostgres {
+		return query
+	}
+	n := 0
+	return placeholderPattern.ReplaceAllStringFunc(query, func(string) string {
+		n++
+		return "$" + strconv.Itoa(n)
+	})
+}
+
+// addColumnIfMissing adds a column to an existing table, for schema upgrades
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	if isPostgres {
+		_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", table, column, err)
+		}
+		return nil
+	}
+
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %v", table, column, err)
+	}
+	return nil
+}
+
 func getEnv() (*dbConfig, error) {
 	user, ok := os.LookupEnv("POSTGRES_USER")
 	if !ok {
@@ -201,16 +262,256 @@ func NewMessageStore() (*MessageStore, error) {
 			file_sha256 %s,
 			file_enc_sha256 %s,
 			file_length INTEGER,
+			direct_path TEXT,
+			mimetype TEXT,
+			width INTEGER,
+			height INTEGER,
+			duration_seconds INTEGER,
+			caption TEXT,
+			original_filename TEXT,
+			latitude DOUBLE PRECISION,
+			longitude DOUBLE PRECISION,
+			location_name TEXT,
+			location_address TEXT,
+			vcard TEXT,
 			PRIMARY KEY (id, chat_jid),
 			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
 		);
-	`, blobType, blobType, blobType))
+
+		CREATE TABLE IF NOT EXISTS media_cache (
+			path TEXT PRIMARY KEY,
+			message_id TEXT,
+			chat_jid TEXT,
+			size BIGINT,
+			sha256 %s,
+			created_at TIMESTAMP,
+			last_access TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS contacts (
+			jid TEXT PRIMARY KEY,
+			phone_number TEXT,
+			full_name TEXT,
+			first_name TEXT,
+			push_name TEXT,
+			business_name TEXT,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS lid_mappings (
+			lid TEXT PRIMARY KEY,
+			pn TEXT,
+			updated_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_lid_mappings_pn ON lid_mappings (pn);
+
+		CREATE TABLE IF NOT EXISTS group_participants (
+			group_jid TEXT,
+			participant_jid TEXT,
+			phone_number TEXT,
+			lid TEXT,
+			is_admin BOOLEAN,
+			is_super_admin BOOLEAN,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (group_jid, participant_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS group_membership_events (
+			group_jid TEXT,
+			participant_jid TEXT,
+			action TEXT,
+			actor_jid TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (group_jid, participant_jid, action, timestamp)
+		);
+
+		CREATE TABLE IF NOT EXISTS profiles (
+			jid TEXT PRIMARY KEY,
+			about TEXT,
+			business_name TEXT,
+			picture_id TEXT,
+			picture_path TEXT,
+			picture_status TEXT,
+			devices TEXT,
+			fetched_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS presence (
+			jid TEXT PRIMARY KEY,
+			online BOOLEAN,
+			last_seen TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS chat_states (
+			chat_jid TEXT,
+			sender_jid TEXT,
+			state TEXT,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (chat_jid, sender_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS polls (
+			message_id TEXT,
+			chat_jid TEXT,
+			question TEXT,
+			options TEXT,
+			selectable_count INTEGER,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id TEXT,
+			chat_jid TEXT,
+			voter_jid TEXT,
+			selected_hashes TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (poll_id, chat_jid, voter_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS message_mentions (
+			message_id TEXT,
+			chat_jid TEXT,
+			mentioned_jid TEXT,
+			PRIMARY KEY (message_id, chat_jid, mentioned_jid)
+		);
+		CREATE INDEX IF NOT EXISTS idx_message_mentions_jid ON message_mentions (mentioned_jid);
+
+		CREATE TABLE IF NOT EXISTS outbox (
+			id TEXT PRIMARY KEY,
+			kind TEXT,
+			recipient TEXT,
+			payload %s,
+			status TEXT,
+			attempts INTEGER,
+			last_error TEXT,
+			message_id TEXT,
+			chat_jid TEXT,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP,
+			next_attempt_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox (status, next_attempt_at);
+
+		CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id TEXT PRIMARY KEY,
+			request TEXT,
+			cron TEXT,
+			timezone TEXT,
+			next_run_at TIMESTAMP,
+			status TEXT,
+			runs INTEGER,
+			last_run_at TIMESTAMP,
+			last_outbox_id TEXT,
+			created_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages (status, next_run_at);
+
+		CREATE TABLE IF NOT EXISTS bulk_jobs (
+			id TEXT PRIMARY KEY,
+			status TEXT,
+			message TEXT,
+			media_path TEXT,
+			total INTEGER,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS bulk_recipients (
+			job_id TEXT,
+			position INTEGER,
+			recipient TEXT,
+			message TEXT,
+			status TEXT,
+			outbox_id TEXT,
+			error TEXT,
+			not_before TIMESTAMP,
+			PRIMARY KEY (job_id, position),
+			FOREIGN KEY (job_id) REFERENCES bulk_jobs(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_bulk_recipients_status ON bulk_recipients (status, job_id);
+
+		CREATE TABLE IF NOT EXISTS recipient_lists (
+			name TEXT PRIMARY KEY,
+			recipients TEXT,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS labels (
+			id TEXT PRIMARY KEY,
+			name TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS chat_labels (
+			chat_jid TEXT,
+			label_id TEXT,
+			PRIMARY KEY (chat_jid, label_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id TEXT PRIMARY KEY,
+			time TIMESTAMP,
+			kind TEXT,
+			caller TEXT,
+			remote TEXT,
+			method TEXT,
+			path TEXT,
+			status INTEGER,
+			outcome TEXT,
+			latency_ms INTEGER,
+			request TEXT,
+			error TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log (time);
+	`, blobType, blobType, blobType, blobType, blobType))
 	if err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to create tables: %v", err)
 	}
 
-	return &MessageStore{db: db}, nil
+	// Columns added after the first release, for databases created before them
+	for _, column := range []struct{ name, def string }{
+		{"direct_path", "TEXT"},
+		{"mimetype", "TEXT"},
+		{"width", "INTEGER"},
+		{"height", "INTEGER"},
+		{"duration_seconds", "INTEGER"},
+		{"caption", "TEXT"},
+		{"original_filename", "TEXT"},
+		{"latitude", "DOUBLE PRECISION"},
+		{"longitude", "DOUBLE PRECISION"},
+		{"location_name", "TEXT"},
+		{"location_address", "TEXT"},
+		{"vcard", "TEXT"},
+	} {
+		if err := addColumnIfMissing(db, "messages", column.name, column.def); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := addColumnIfMissing(db, "bulk_recipients", "not_before", "TIMESTAMP"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := runDataMigrations(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	media, err := NewMediaCache(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if result, err := media.Enforce(); err != nil {
+		fmt.Printf("Failed to apply media cache quota: %v\n", err)
+	} else if result.Removed > 0 {
+		fmt.Printf("Evicted %d cached media files (%d bytes)\n", result.Removed, result.FreedBytes)
+	}
+
+	return &MessageStore{db: db, media: media}, nil
 }
 
 // Close the database connection
@@ -238,42 +539,58 @@ func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time
 	return err
 }
 
-// StoreMessage Store a message in the database
-func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool,
-	mediaType, filename, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
-	if content == "" && mediaType == "" {
+// StoreMessage Store a message in the database. media is nil for plain text messages.
+func (store *MessageStore) StoreMessage(id, chatJID, sender, content string, timestamp time.Time, isFromMe bool, media *MediaInfo) error {
+	if content == "" && media == nil {
 		return nil
 	}
+	if media == nil {
+		media = &MediaInfo{}
+	}
 
-	if !isPostgres {
-		_, err := store.db.Exec(
-			`INSERT OR REPLACE INTO messages 
-		(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
-		)
-		return err
+	// 0, 0 is a real place, so coordinates are only stored for locations
+	var latitude, longitude interface{}
+	if media.Type == mediaTypeLocation || media.Type == mediaTypeLiveLocation {
+		latitude, longitude = media.Latitude, media.Longitude
 	}
-	_, err := store.db.Exec(
-		`INSERT INTO messages 
-    (id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length) 
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
-    ON CONFLICT(id, chat_jid) DO UPDATE SET 
-    chat_jid = EXCLUDED.chat_jid,
-    sender = EXCLUDED.sender,
-    content = EXCLUDED.content,
-    timestamp = EXCLUDED.timestamp,
-    is_from_me = EXCLUDED.is_from_me,
-    media_type = EXCLUDED.media_type,
-    filename = EXCLUDED.filename,
-    url = EXCLUDED.url,
-    media_key = EXCLUDED.media_key,
-    file_sha256 = EXCLUDED.file_sha256,
-    file_enc_sha256 = EXCLUDED.file_enc_sha256,
-    file_length = EXCLUDED.file_length`,
-		id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
-	)
 
+	_, err := store.db.Exec(rebind(`
+		INSERT INTO messages (
+			id, chat_jid, sender, content, timestamp, is_from_me,
+			media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length,
+			direct_path, mimetype, width, height, duration_seconds, caption, original_filename,
+			latitude, longitude, location_name, location_address, vcard
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id, chat_jid) DO UPDATE SET
+			sender = EXCLUDED.sender,
+			content = EXCLUDED.content,
+			timestamp = EXCLUDED.timestamp,
+			is_from_me = EXCLUDED.is_from_me,
+			media_type = EXCLUDED.media_type,
+			filename = EXCLUDED.filename,
+			url = EXCLUDED.url,
+			media_key = EXCLUDED.media_key,
+			file_sha256 = EXCLUDED.file_sha256,
+			file_enc_sha256 = EXCLUDED.file_enc_sha256,
+			file_length = EXCLUDED.file_length,
+			direct_path = EXCLUDED.direct_path,
+			mimetype = EXCLUDED.mimetype,
+			width = EXCLUDED.width,
+			height = EXCLUDED.height,
+			duration_seconds = EXCLUDED.duration_seconds,
+			caption = EXCLUDED.caption,
+			original_filename = EXCLUDED.original_filename,
+			latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude,
+			location_name = EXCLUDED.location_name,
+			location_address = EXCLUDED.location_address,
+			vcard = EXCLUDED.vcard`),
+		id, chatJID, sender, content, timestamp, isFromMe,
+		media.Type, media.Filename, media.URL, media.MediaKey, media.FileSHA256, media.FileEncSHA256, media.FileLength,
+		media.DirectPath, media.Mimetype, media.Width, media.Height, media.Seconds, media.Caption, media.OriginalFilename,
+		latitude, longitude, media.LocationName, media.LocationAddress, media.VCard,
+	)
 	return err
 }
 
@@ -360,13 +677,20 @@ func extractTextContent(msg *waE2E.Message) string {
 type SendMessageResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+
+	// Set for sends that went through the outbox
+	ID        string `json:"id,omitempty"`
+	Status    string `json:"status,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	ChatJID   string `json:"chat_jid,omitempty"`
 }
 
 // SendMessageRequest represents the request body for the send message API
 type SendMessageRequest struct {
-	Recipient string `json:"recipient"`
-	Message   string `json:"message"`
-	MediaPath string `json:"media_path,omitempty"`
+	Recipient string   `json:"recipient"`
+	Message   string   `json:"message"`
+	MediaPath string   `json:"media_path,omitempty"`
+	Mentions  []string `json:"mentions,omitempty"` // phone numbers or JIDs to @mention
 }
 
 var clientVersionRegex = regexp.MustCompile(`"client_revision":(\d+),`)
@@ -405,77 +729,94 @@ func CustomGetLatestVersion(ctx context.Context, httpClient *http.Client) (*stor
 	}
 }
 
-// Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string) (bool, string) {
-	if !client.IsConnected() {
-		return false, "Not connected to WhatsApp"
-	}
-
-	var recipientJID types.JID
-	var err error
+// mediaTypeOf picks how a file is sent from its extension
+func mediaTypeOf(mediaPath string) (whatsmeow.MediaType, string) {
+	fileExt := strings.ToLower(mediaPath[strings.LastIndex(mediaPath, ".")+1:])
+	switch fileExt {
+	case "jpg", "jpeg":
+		return whatsmeow.MediaImage, "image/jpeg"
+	case "png":
+		return whatsmeow.MediaImage, "image/png"
+	case "gif":
+		return whatsmeow.MediaImage, "image/gif"
+	case "webp":
+		return whatsmeow.MediaImage, "image/webp"
+
+	case "ogg":
+		return whatsmeow.MediaAudio, "audio/ogg; codecs=opus"
+
+	case "mp4":
+		return whatsmeow.MediaVideo, "video/mp4"
+	case "avi":
+		return whatsmeow.MediaVideo, "video/avi"
+	case "mov":
+		return whatsmeow.MediaVideo, "video/quicktime"
+
+	case "pdf":
+		return whatsmeow.MediaDocument, "application/pdf"
 
-	isJID := strings.Contains(recipient, "@")
+	default:
+		return whatsmeow.MediaDocument, "application/octet-stream"
+	}
+}
 
-	if isJID {
-		recipientJID, err = types.ParseJID(recipient)
-		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err)
+// checkSendRequest refuses a send that would fail on every attempt, so it is
+// answered with 400 instead of being queued
+func checkSendRequest(client *whatsmeow.Client, req SendMessageRequest) error {
+	for _, mention := range req.Mentions {
+		if _, err := mentionJID(client, mention); err != nil {
+			return err
 		}
-	} else {
-		recipientJID = types.JID{
-			User:   recipient,
-			Server: "s.whatsapp.net", // For personal chats
+	}
+	if req.MediaPath == "" {
+		return nil
+	}
+	mediaData, err := os.ReadFile(req.MediaPath)
+	if err != nil {
+		return fmt.Errorf("Error reading media file: %v", err)
+	}
+	if mediaType, _ := mediaTypeOf(req.MediaPath); mediaType == whatsmeow.MediaAudio {
+		if _, _, err := analyzeOggOpus(mediaData); err != nil {
+			return fmt.Errorf("Failed to analyze Ogg Opus file: %v", err)
 		}
 	}
+	return nil
+}
+
+// buildWhatsAppMessage builds a text or media message for a recipient, uploading
+// the media. Errors that retrying can't fix are marked permanent.
+func buildWhatsAppMessage(client *whatsmeow.Client, messageStore *MessageStore, recipientJID types.JID, message string, mediaPath string, mentions []string) (*waE2E.Message, error) {
+	mentioned, err := resolveMentions(client, messageStore, recipientJID, mentions)
+	if err != nil {
+		return nil, permanentError(err)
+	}
+	if len(mentioned) > 0 {
+		message = withMentionTokens(message, mentioned)
+	}
 
 	msg := &waE2E.Message{}
 
 	if mediaPath != "" {
 		mediaData, err := os.ReadFile(mediaPath)
 		if err != nil {
-			return false, fmt.Sprintf("Error reading media file: %v", err)
-		}
-
-		fileExt := strings.ToLower(mediaPath[strings.LastIndex(mediaPath, ".")+1:])
-		var mediaType whatsmeow.MediaType
-		var mimeType string
-
-		switch fileExt {
-		case "jpg", "jpeg":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/jpeg"
-		case "png":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/png"
-		case "gif":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/gif"
-		case "webp":
-			mediaType = whatsmeow.MediaImage
-			mimeType = "image/webp"
-
-		case "ogg":
-			mediaType = whatsmeow.MediaAudio
-			mimeType = "audio/ogg; codecs=opus"
-
-		case "mp4":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/mp4"
-		case "avi":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/avi"
-		case "mov":
-			mediaType = whatsmeow.MediaVideo
-			mimeType = "video/quicktime"
+			return nil, permanentError(fmt.Errorf("Error reading media file: %v", err))
+		}
 
-		default:
-			mediaType = whatsmeow.MediaDocument
-			mimeType = "application/octet-stream"
+		mediaType, mimeType := mediaTypeOf(mediaPath)
+
+		// Voice notes need their length and waveform, so a bad file fails before uploading
+		var seconds uint32 = 30
+		var waveform []byte = nil
+		if mediaType == whatsmeow.MediaAudio {
+			seconds, waveform, err = analyzeOggOpus(mediaData)
+			if err != nil {
+				return nil, permanentError(fmt.Errorf("Failed to analyze Ogg Opus file: %v", err))
+			}
 		}
 
 		resp, err := client.Upload(context.Background(), mediaData, mediaType)
 		if err != nil {
-			return false, fmt.Sprintf("Error uploading media: %v", err)
+			return nil, fmt.Errorf("Error uploading media: %v", err)
 		}
 
 		fmt.Println("Media uploaded", resp)
@@ -492,22 +833,15 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
 			}
-		case whatsmeow.MediaAudio:
-			var seconds uint32 = 30
-			var waveform []byte = nil
-
-			if strings.Contains(mimeType, "ogg") {
-				analyzedSeconds, analyzedWaveform, err := analyzeOggOpus(mediaData)
-				if err == nil {
-					seconds = analyzedSeconds
-					waveform = analyzedWaveform
-				} else {
-					return false, fmt.Sprintf("Failed to analyze Ogg Opus file: %v", err)
-				}
+			// Without dimensions and a thumbnail the recipient only sees a grey box
+			if width, height, thumbnail, err := imageMetadata(mediaData); err == nil {
+				msg.ImageMessage.Width = proto.Uint32(width)
+				msg.ImageMessage.Height = proto.Uint32(height)
+				msg.ImageMessage.JPEGThumbnail = thumbnail
 			} else {
-				fmt.Printf("Not an Ogg Opus file: %s\n", mimeType)
+				fmt.Printf("Warning: could not read image metadata: %v\n", err)
 			}
-
+		case whatsmeow.MediaAudio:
 			msg.AudioMessage = &waE2E.AudioMessage{
 				Mimetype:      proto.String(mimeType),
 				URL:           &resp.URL,
@@ -531,6 +865,14 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
 			}
+			if info, err := mp4Metadata(mediaData); err == nil {
+				msg.VideoMessage.Seconds = proto.Uint32(info.Seconds)
+				msg.VideoMessage.Width = proto.Uint32(info.Width)
+				msg.VideoMessage.Height = proto.Uint32(info.Height)
+				msg.VideoMessage.JPEGThumbnail = info.Thumbnail
+			} else {
+				fmt.Printf("Warning: could not read video metadata: %v\n", err)
+			}
 		case whatsmeow.MediaDocument:
 			msg.DocumentMessage = &waE2E.DocumentMessage{
 				Title:         proto.String(mediaPath[strings.LastIndex(mediaPath, "/")+1:]),
@@ -543,70 +885,68 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 				FileSHA256:    resp.FileSHA256,
 				FileLength:    &resp.FileLength,
 			}
+			if pages := pdfPageCount(mediaData); pages > 0 {
+				msg.DocumentMessage.PageCount = proto.Uint32(pages)
+			}
 		}
+	} else if len(mentioned) > 0 {
+		// Plain conversation messages can't carry mentions
+		msg.ExtendedTextMessage = &waE2E.ExtendedTextMessage{Text: proto.String(message)}
 	} else {
 		msg.Conversation = proto.String(message)
 	}
 
-	_, err = client.SendMessage(context.Background(), recipientJID, msg)
-
-	if err != nil {
-		return false, fmt.Sprintf("Error sending message: %v", err)
-	}
-
-	return true, fmt.Sprintf("Message sent to %s", recipient)
-}
-
-// Extract media info from a message
-func extractMediaInfo(msg *waE2E.Message) (mediaType string, filename string, url string, mediaKey []byte, fileSHA256 []byte, fileEncSHA256 []byte, fileLength uint64) {
-	if msg == nil {
-		return "", "", "", nil, nil, nil, 0
-	}
-
-	if img := msg.GetImageMessage(); img != nil {
-		return "image", "image_" + time.Now().Format("20060102_150405") + ".jpg",
-			img.GetURL(), img.GetMediaKey(), img.GetFileSHA256(), img.GetFileEncSHA256(), img.GetFileLength()
-	}
-
-	if vid := msg.GetVideoMessage(); vid != nil {
-		return "video", "video_" + time.Now().Format("20060102_150405") + ".mp4",
-			vid.GetURL(), vid.GetMediaKey(), vid.GetFileSHA256(), vid.GetFileEncSHA256(), vid.GetFileLength()
-	}
-
-	if aud := msg.GetAudioMessage(); aud != nil {
-		return "audio", "audio_" + time.Now().Format("20060102_150405") + ".ogg",
-			aud.GetURL(), aud.GetMediaKey(), aud.GetFileSHA256(), aud.GetFileEncSHA256(), aud.GetFileLength()
-	}
-
-	if doc := msg.GetDocumentMessage(); doc != nil {
-		filename := doc.GetFileName()
-		if filename == "" {
-			filename = "document_" + time.Now().Format("20060102_150405")
+	if len(mentioned) > 0 {
+		contextInfo := mentionContextInfo(mentioned)
+		switch {
+		case msg.ExtendedTextMessage != nil:
+			msg.ExtendedTextMessage.ContextInfo = contextInfo
+		case msg.ImageMessage != nil:
+			msg.ImageMessage.ContextInfo = contextInfo
+		case msg.VideoMessage != nil:
+			msg.VideoMessage.ContextInfo = contextInfo
+		case msg.AudioMessage != nil:
+			msg.AudioMessage.ContextInfo = contextInfo
+		case msg.DocumentMessage != nil:
+			msg.DocumentMessage.ContextInfo = contextInfo
 		}
-		return "document", filename,
-			doc.GetURL(), doc.GetMediaKey(), doc.GetFileSHA256(), doc.GetFileEncSHA256(), doc.GetFileLength()
 	}
 
-	return "", "", "", nil, nil, nil, 0
+	return msg, nil
 }
 
 // Handle regular incoming messages with media support
 func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message, logger waLog.Logger) {
 	chatJID := msg.Info.Chat.String()
-	sender := msg.Info.Sender.User
 
-	name := GetChatName(client, messageStore, msg.Info.Chat, chatJID, nil, sender, logger)
+	// File the sender under their phone number even when the message uses a LID
+	if err := messageStore.PutLIDMappings(messageLIDPairs(msg.Info)); err != nil {
+		logger.Warnf("Failed to store LID mapping: %v", err)
+	}
+	senderJID := canonicalJID(client, messageStore, msg.Info.Sender)
+	sender := senderKey(senderJID)
+
+	name := GetChatName(client, messageStore, msg.Info.Chat, chatJID, nil, senderJID.User, logger)
+	loadGroupParticipants(client, messageStore, msg.Info.Chat)
 
 	err := messageStore.StoreChat(chatJID, name, msg.Info.Timestamp)
 	if err != nil {
 		logger.Warnf("Failed to store chat: %v", err)
 	}
 
+	if msg.Message.GetPollUpdateMessage() != nil {
+		handlePollVote(client, messageStore, msg)
+		return
+	}
+
 	content := extractTextContent(msg.Message)
 
-	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg.Message)
+	media := extractMediaInfo(msg.Message, msg.Info.ID)
+	if content == "" && media != nil {
+		content = media.Caption
+	}
 
-	if content == "" && mediaType == "" {
+	if content == "" && media == nil {
 		return
 	}
 
@@ -617,26 +957,23 @@ func handleMessage(client *whatsmeow.Client, messageStore *MessageStore, msg *ev
 		content,
 		msg.Info.Timestamp,
 		msg.Info.IsFromMe,
-		mediaType,
-		filename,
-		url,
-		mediaKey,
-		fileSHA256,
-		fileEncSHA256,
-		fileLength,
+		media,
 	)
 
 	if err != nil {
 		logger.Warnf("Failed to store message: %v", err)
 	} else {
+		storePollCreation(messageStore, msg.Info.ID, chatJID, msg.Message)
+		storeMessageMentions(client, messageStore, msg.Info.ID, chatJID, msg.Message)
+
 		timestamp := msg.Info.Timestamp.Format("2006-01-02 15:04:05")
 		direction := "←"
 		if msg.Info.IsFromMe {
 			direction = "→"
 		}
 
-		if mediaType != "" {
-			fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, sender, mediaType, filename, content)
+		if media != nil {
+			fmt.Printf("[%s] %s %s: [%s: %s] %s\n", timestamp, direction, sender, media.Type, media.Filename, content)
 		} else if content != "" {
 			fmt.Printf("[%s] %s %s: %s\n", timestamp, direction, sender, content)
 		}
@@ -655,53 +992,45 @@ type DownloadMediaResponse struct {
 	Message  string `json:"message"`
 	Filename string `json:"filename,omitempty"`
 	Path     string `json:"path,omitempty"`
+	Pending  bool   `json:"pending,omitempty"`
 }
 
-// StoreMediaInfo Store additional media info in the database
-func (store *MessageStore) StoreMediaInfo(id, chatJID, url string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) error {
-	if isPostgres {
-		_, err := store.db.Exec(
-			`UPDATE messages 
-					 SET url = $1, 
-						 media_key = $2, 
-						 file_sha256 = $3, 
-						 file_enc_sha256 = $4, 
-						 file_length = $5 
-					 WHERE id = $6 AND chat_jid = $7`,
-			url, mediaKey, fileSHA256, fileEncSHA256, fileLength, id, chatJID,
-		)
-		return err
-	}
-
-	_, err := store.db.Exec(
-		"UPDATE messages SET url = ?, media_key = ?, file_sha256 = ?, file_enc_sha256 = ?, file_length = ? WHERE id = ? AND chat_jid = ?",
-		url, mediaKey, fileSHA256, fileEncSHA256, fileLength, id, chatJID,
+// StoreMediaInfo Update where the media of a message can be downloaded from
+func (store *MessageStore) StoreMediaInfo(id, chatJID string, media *MediaInfo) error {
+	_, err := store.db.Exec(rebind(`
+		UPDATE messages
+		SET url = ?, direct_path = ?, media_key = ?, file_sha256 = ?, file_enc_sha256 = ?, file_length = ?
+		WHERE id = ? AND chat_jid = ?`),
+		media.URL, media.DirectPath, media.MediaKey, media.FileSHA256, media.FileEncSHA256, media.FileLength, id, chatJID,
 	)
 	return err
 }
 
 // GetMediaInfo Get media info from the database
-func (store *MessageStore) GetMediaInfo(id, chatJID string) (string, string, string, []byte, []byte, []byte, uint64, error) {
-	var mediaType, filename, url string
-	var mediaKey, fileSHA256, fileEncSHA256 []byte
-	var fileLength uint64
-	var err error
-
-	if isPostgres {
-		err = store.db.QueryRow(
-			`SELECT media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length 
-					 FROM messages 
-					 WHERE id = $1 AND chat_jid = $2`,
-			id, chatJID,
-		).Scan(&mediaType, &filename, &url, &mediaKey, &fileSHA256, &fileEncSHA256, &fileLength)
-	} else {
-		err = store.db.QueryRow(
-			"SELECT media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length FROM messages WHERE id = ? AND chat_jid = ?",
-			id, chatJID,
-		).Scan(&mediaType, &filename, &url, &mediaKey, &fileSHA256, &fileEncSHA256, &fileLength)
+func (store *MessageStore) GetMediaInfo(id, chatJID string) (*MediaInfo, error) {
+	var media MediaInfo
+	var fileLength sql.NullInt64
+	err := store.db.QueryRow(rebind(`
+		SELECT COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(url, ''), media_key, file_sha256,
+		       file_enc_sha256, file_length, COALESCE(direct_path, ''), COALESCE(mimetype, ''),
+		       COALESCE(width, 0), COALESCE(height, 0), COALESCE(duration_seconds, 0),
+		       COALESCE(caption, ''), COALESCE(original_filename, ''),
+		       COALESCE(latitude, 0), COALESCE(longitude, 0), COALESCE(location_name, ''),
+		       COALESCE(location_address, ''), COALESCE(vcard, '')
+		FROM messages
+		WHERE id = ? AND chat_jid = ?`),
+		id, chatJID,
+	).Scan(&media.Type, &media.Filename, &media.URL, &media.MediaKey, &media.FileSHA256,
+		&media.FileEncSHA256, &fileLength, &media.DirectPath, &media.Mimetype,
+		&media.Width, &media.Height, &media.Seconds,
+		&media.Caption, &media.OriginalFilename,
+		&media.Latitude, &media.Longitude, &media.LocationName,
+		&media.LocationAddress, &media.VCard)
+	if err != nil {
+		return nil, err
 	}
-
-	return mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, err
+	media.FileLength = uint64(fileLength.Int64)
+	return &media, nil
 }
 
 // MediaDownloader implements the whatsmeow.DownloadableMessage interface
@@ -752,35 +1081,14 @@ func (d *MediaDownloader) GetMediaType() whatsmeow.MediaType {
 
 // Function to download media from a message
 func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, messageID, chatJID string) (bool, string, string, string, error) {
-	var mediaType, filename, url string
-	var mediaKey, fileSHA256, fileEncSHA256 []byte
-	var fileLength uint64
-	var err error
-
 	chatDir := fmt.Sprintf("store/%s", strings.ReplaceAll(chatJID, ":", "_"))
-	localPath := ""
-
-	mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, err = messageStore.GetMediaInfo(messageID, chatJID)
 
+	media, err := messageStore.GetMediaInfo(messageID, chatJID)
 	if err != nil {
-		if isPostgres {
-			err = messageStore.db.QueryRow(
-				`SELECT media_type, filename FROM messages WHERE id = $1 AND chat_jid = $2`,
-				messageID, chatJID,
-			).Scan(&mediaType, &filename)
-		} else {
-			err = messageStore.db.QueryRow(
-				"SELECT media_type, filename FROM messages WHERE id = ? AND chat_jid = ?",
-				messageID, chatJID,
-			).Scan(&mediaType, &filename)
-		}
-
-		if err != nil {
-			return false, "", "", "", fmt.Errorf("failed to find message: %v", err)
-		}
+		return false, "", "", "", fmt.Errorf("failed to find message: %v", err)
 	}
 
-	if mediaType == "" {
+	if _, ok := whatsmeowMediaType(media.Type); !ok {
 		return false, "", "", "", fmt.Errorf("not a media message")
 	}
 
@@ -788,50 +1096,55 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		return false, "", "", "", fmt.Errorf("failed to create chat directory: %v", err)
 	}
 
-	localPath = fmt.Sprintf("%s/%s", chatDir, filename)
+	localPath := fmt.Sprintf("%s/%s", chatDir, media.Filename)
 
 	absPath, err := filepath.Abs(localPath)
 	if err != nil {
 		return false, "", "", "", fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
-	if _, err := os.Stat(localPath); err == nil {
-		return true, mediaType, filename, absPath, nil
+	if messageStore.media.Lookup(messageID, chatJID, absPath, media.FileSHA256) {
+		return true, media.Type, media.Filename, absPath, nil
 	}
 
-	if url == "" || len(mediaKey) == 0 || len(fileSHA256) == 0 || len(fileEncSHA256) == 0 || fileLength == 0 {
+	if (media.URL == "" && media.DirectPath == "") || len(media.MediaKey) == 0 ||
+		len(media.FileSHA256) == 0 || len(media.FileEncSHA256) == 0 || media.FileLength == 0 {
 		return false, "", "", "", fmt.Errorf("incomplete media information for download")
 	}
 
 	fmt.Printf("Attempting to download media for message %s in chat %s...\n", messageID, chatJID)
 
-	directPath := extractDirectPathFromURL(url)
-
-	var waMediaType whatsmeow.MediaType
-	switch mediaType {
-	case "image":
-		waMediaType = whatsmeow.MediaImage
-	case "video":
-		waMediaType = whatsmeow.MediaVideo
-	case "audio":
-		waMediaType = whatsmeow.MediaAudio
-	case "document":
-		waMediaType = whatsmeow.MediaDocument
-	default:
-		return false, "", "", "", fmt.Errorf("unsupported media type: %s", mediaType)
+	waMediaType, ok := whatsmeowMediaType(media.Type)
+	if !ok {
+		return false, "", "", "", fmt.Errorf("unsupported media type: %s", media.Type)
 	}
 
 	downloader := &MediaDownloader{
-		URL:           url,
-		DirectPath:    directPath,
-		MediaKey:      mediaKey,
-		FileLength:    fileLength,
-		FileSHA256:    fileSHA256,
-		FileEncSHA256: fileEncSHA256,
+		URL:           media.URL,
+		DirectPath:    media.DirectPath,
+		MediaKey:      media.MediaKey,
+		FileLength:    media.FileLength,
+		FileSHA256:    media.FileSHA256,
+		FileEncSHA256: media.FileEncSHA256,
 		MediaType:     waMediaType,
 	}
 
 	mediaData, err := client.Download(context.Background(), downloader)
+	if isExpiredMediaError(err) {
+		// Old media (mostly from history sync) has to be re-uploaded by the phone first
+		retry, retryErr := requestMediaRetry(client, messageStore, messageID, chatJID, media.MediaKey)
+		if retryErr != nil {
+			return false, "", "", "", fmt.Errorf("failed to download media: %v (%v)", err, retryErr)
+		}
+		directPath, retryErr := retry.wait(mediaRetryWait)
+		if retryErr != nil {
+			return false, "", "", "", retryErr
+		}
+		// The old URL is dead, so make whatsmeow use the new direct path
+		downloader.URL = ""
+		downloader.DirectPath = directPath
+		mediaData, err = client.Download(context.Background(), downloader)
+	}
 	if err != nil {
 		return false, "", "", "", fmt.Errorf("failed to download media: %v", err)
 	}
@@ -840,24 +1153,12 @@ func downloadMedia(client *whatsmeow.Client, messageStore *MessageStore, message
 		return false, "", "", "", fmt.Errorf("failed to save media file: %v", err)
 	}
 
-	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", mediaType, absPath, len(mediaData))
-	return true, mediaType, filename, absPath, nil
-}
-
-func extractDirectPathFromURL(url string) string {
-	// The direct path is typically in the URL, we need to extract it
-	// Example URL: https://mmg.whatsapp.net/v/t62.7118-24/13812002_698058036224062_3424455886509161511_n.enc?ccb=11-4&oh=...
-
-	parts := strings.SplitN(url, ".net/", 2)
-	if len(parts) < 2 {
-		return url // Return original URL if parsing fails
+	if err := messageStore.media.Add(messageID, chatJID, absPath, mediaData); err != nil {
+		fmt.Printf("Failed to update media cache: %v\n", err)
 	}
 
-	pathPart := parts[1]
-
-	pathPart = strings.SplitN(pathPart, "?", 2)[0]
-
-	return "/" + pathPart
+	fmt.Printf("Successfully downloaded %s media to %s (%d bytes)\n", media.Type, absPath, len(mediaData))
+	return true, media.Type, media.Filename, absPath, nil
 }
 
 // Start a REST API server to expose the WhatsApp client functionality
@@ -886,18 +1187,27 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 
 		fmt.Println("Received request to send message", req.Message, req.MediaPath)
 
-		success, message := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath)
-		fmt.Println("Message sent", success, message)
-		w.Header().Set("Content-Type", "application/json")
-
-		if !success {
-			w.WriteHeader(http.StatusInternalServerError)
+		if err := checkSendRequest(client, req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
 		}
 
-		json.NewEncoder(w).Encode(SendMessageResponse{
-			Success: success,
-			Message: message,
-		})
+		id := string(client.GenerateMessageID())
+		// The caller's file may be gone by the time the message is delivered
+		if req.MediaPath != "" {
+			path, err := copyOutboxMedia(id, req.MediaPath)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			req.MediaPath = path
+		}
+		payload, err := json.Marshal(req)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		enqueueSend(w, r, client, messageStore, id, outboxKindMessage, req.Recipient, payload)
 	})
 
 	// Handler for downloading media
@@ -917,11 +1227,25 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			http.Error(w, "Message ID and Chat JID are required", http.StatusBadRequest)
 			return
 		}
+		if !messageStore.chatVisible(requestAccess(r), req.ChatJID) {
+			http.Error(w, "Chat not found", http.StatusNotFound)
+			return
+		}
 
 		success, mediaType, filename, path, err := downloadMedia(client, messageStore, req.MessageID, req.ChatJID)
 
 		w.Header().Set("Content-Type", "application/json")
 
+		if errors.Is(err, errMediaRetryPending) {
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(DownloadMediaResponse{
+				Success: false,
+				Pending: true,
+				Message: err.Error(),
+			})
+			return
+		}
+
 		if !success || err != nil {
 			errMsg := "Unknown error"
 			if err != nil {
@@ -944,6 +1268,49 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 		})
 	})
 
+	// GET /api/media/cache reports cache usage
+	// DELETE /api/media/cache purges files (?all=true, ?older_than=72h, ?chat=<jid>),
+	// or applies the configured quota when no filter is given
+	http.HandleFunc("/api/media/cache", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			stats, err := messageStore.media.Stats()
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, stats)
+
+		case http.MethodDelete:
+			q := r.URL.Query()
+			var olderThan time.Duration
+			if val := q.Get("older_than"); val != "" {
+				d, err := time.ParseDuration(val)
+				if err != nil || d < 0 {
+					http.Error(w, "Invalid older_than duration", http.StatusBadRequest)
+					return
+				}
+				olderThan = d
+			}
+
+			var result MediaCachePurgeResult
+			var err error
+			if q.Get("all") == "true" || olderThan > 0 || q.Get("chat") != "" {
+				result, err = messageStore.media.Purge(olderThan, q.Get("chat"))
+			} else {
+				result, err = messageStore.media.Enforce()
+			}
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, result)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
 	// List recent chats
 	http.HandleFunc("/api/chats", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -968,7 +1335,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			queryPtr = &q
 		}
 
-		chats, err := messageStore.ListChats(queryPtr, limit, page, true, sortBy)
+		chats, err := messageStore.ListChats(queryPtr, limit, page, true, sortBy, requestAccess(r))
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -998,10 +1365,13 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if chat == nil {
+		if chat == nil || !messageStore.chatVisible(requestAccess(r), chat.JID) {
 			http.Error(w, "Chat not found", http.StatusNotFound)
 			return
 		}
+		if chat.Presence, err = messageStore.GetChatPresence(chat.JID); err != nil {
+			fmt.Printf("Failed to read presence of %s: %v\n", chat.JID, err)
+		}
 
 		respondJSON(w, http.StatusOK, map[string]interface{}{
 			"chat": chat,
@@ -1021,6 +1391,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			IncludeContext: false,
 			ContextBefore:  5,
 			ContextAfter:   5,
+			Access:         requestAccess(r),
 		}
 
 		q := r.URL.Query()
@@ -1086,7 +1457,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			after = 6
 		}
 
-		ctx, err := messageStore.GetMessageContext(messageID, before, after)
+		ctx, err := messageStore.GetMessageContext(messageID, before, after, requestAccess(r))
 		if err != nil {
 			respondError(w, http.StatusNotFound, err.Error())
 			return
@@ -1113,6 +1484,16 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		// Callers only find the people whose direct chat they may read
+		if access := requestAccess(r); access.restricted() {
+			visible := []Contact{}
+			for _, c := range contacts {
+				if messageStore.contactVisible(access, c.JID) {
+					visible = append(visible, c)
+				}
+			}
+			contacts = visible
+		}
 
 		respondJSON(w, http.StatusOK, map[string]interface{}{
 			"contacts": contacts,
@@ -1139,13 +1520,21 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			http.Error(w, "Phone number is required", http.StatusBadRequest)
 			return
 		}
+		if !strings.Contains(phone, "@") {
+			normalized, err := normalizePhoneNumber(phone)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			phone = normalized
+		}
 
 		chat, err := messageStore.GetDirectChatByContact(phone)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if chat == nil {
+		if chat == nil || !messageStore.chatVisible(requestAccess(r), chat.JID) {
 			respondJSON(w, http.StatusNotFound, map[string]string{
 				"error": "No direct chat found for this phone number",
 			})
@@ -1187,7 +1576,7 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 			page = 0
 		}
 
-		chats, err := messageStore.GetContactChats(jid, limit, page)
+		chats, err := messageStore.GetContactChats(jid, limit, page, requestAccess(r))
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -1199,11 +1588,29 @@ func startRESTServer(client *whatsmeow.Client, messageStore *MessageStore, port
 		})
 	})
 
+	// Group management
+	registerGroupRoutes(client, messageStore)
+	registerProfileRoutes(client, messageStore)
+	registerPresenceRoutes(client, messageStore)
+	registerSharedMessageRoutes(client, messageStore)
+	registerPollRoutes(client, messageStore)
+	registerMentionRoutes(client, messageStore)
+	registerForwardRoutes(client, messageStore)
+	registerOutboxRoutes(messageStore)
+	registerScheduleRoutes(client, messageStore)
+	registerMetricsRoutes(messageStore)
+	registerBulkRoutes(messageStore)
+	registerLabelRoutes(messageStore)
+	registerAuditRoutes(messageStore)
+
+	// Phone number registration checks
+	registerNumberCheckRoutes(client)
+
 	serverAddr := fmt.Sprintf(":%d", port)
 	fmt.Printf("Starting REST API server on %s...\n", serverAddr)
 
 	go func() {
-		if err := http.ListenAndServe(serverAddr, nil); err != nil {
+		if err := http.ListenAndServe(serverAddr, withAudit(messageStore, withChatAccess(messageStore, http.DefaultServeMux))); err != nil {
 			fmt.Printf("REST API server error: %v\n", err)
 		}
 	}()
@@ -1291,6 +1698,13 @@ func GetChatName(client *whatsmeow.Client, messageStore *MessageStore, jid types
 func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, historySync *events.HistorySync, logger waLog.Logger) {
 	fmt.Printf("Received history sync event with %d conversations\n", len(historySync.Data.Conversations))
 
+	if err := messageStore.PutLIDMappings(historySyncLIDPairs(historySync)); err != nil {
+		logger.Warnf("Failed to store history sync LID mappings: %v", err)
+	}
+	if err := messageStore.UpsertContacts(historySyncContacts(historySync)); err != nil {
+		logger.Warnf("Failed to store history sync push names: %v", err)
+	}
+
 	syncedCount := 0
 	for _, conversation := range historySync.Data.Conversations {
 		if conversation.ID == nil {
@@ -1337,41 +1751,35 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					}
 				}
 
-				var mediaType, filename, url string
-				var mediaKey, fileSHA256, fileEncSHA256 []byte
-				var fileLength uint64
-
-				if msg.Message.Message != nil {
-					mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength = extractMediaInfo(msg.Message.Message)
+				msgID := ""
+				if msg.Message.Key != nil && msg.Message.Key.ID != nil {
+					msgID = *msg.Message.Key.ID
 				}
 
-				logger.Infof("Message content: %v, Media Type: %v", content, mediaType)
+				media := extractMediaInfo(msg.Message.Message, msgID)
+				if content == "" && media != nil {
+					content = media.Caption
+				}
 
-				if content == "" && mediaType == "" {
+				if content == "" && media == nil {
 					continue
 				}
 
-				var sender string
+				senderJID := jid
 				isFromMe := false
 				if msg.Message.Key != nil {
 					if msg.Message.Key.FromMe != nil {
 						isFromMe = *msg.Message.Key.FromMe
 					}
 					if !isFromMe && msg.Message.Key.Participant != nil && *msg.Message.Key.Participant != "" {
-						sender = *msg.Message.Key.Participant
+						if participant, err := types.ParseJID(*msg.Message.Key.Participant); err == nil {
+							senderJID = participant
+						}
 					} else if isFromMe {
-						sender = client.Store.ID.User
-					} else {
-						sender = jid.User
+						senderJID = *client.Store.ID
 					}
-				} else {
-					sender = jid.User
-				}
-
-				msgID := ""
-				if msg.Message.Key != nil && msg.Message.Key.ID != nil {
-					msgID = *msg.Message.Key.ID
 				}
+				sender := senderKey(canonicalJID(client, messageStore, senderJID))
 
 				timestamp := time.Time{}
 				if ts := msg.Message.GetMessageTimestamp(); ts != 0 {
@@ -1387,21 +1795,18 @@ func handleHistorySync(client *whatsmeow.Client, messageStore *MessageStore, his
 					content,
 					timestamp,
 					isFromMe,
-					mediaType,
-					filename,
-					url,
-					mediaKey,
-					fileSHA256,
-					fileEncSHA256,
-					fileLength,
+					media,
 				)
 				if err != nil {
 					logger.Warnf("Failed to store history message: %v", err)
 				} else {
+					storePollCreation(messageStore, msgID, chatJID, msg.Message.Message)
+					storeMessageMentions(client, messageStore, msgID, chatJID, msg.Message.Message)
+					storeHistoryPollVotes(client, messageStore, jid, msgID, msg.Message)
 					syncedCount++
-					if mediaType != "" {
+					if media != nil {
 						logger.Infof("Stored message: [%s] %s -> %s: [%s: %s] %s",
-							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, mediaType, filename, content)
+							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, media.Type, media.Filename, content)
 					} else {
 						logger.Infof("Stored message: [%s] %s -> %s: %s",
 							timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, content)
@@ -1572,63 +1977,6 @@ func placeholderWaveform(duration uint32) []byte {
 	return waveform
 }
 
-func (store *MessageStore) GetSenderName(senderJID string) string {
-	var name string
-	var err error
-
-	if isPostgres {
-		err = store.db.QueryRow(
-			"SELECT name FROM chats WHERE jid = $1 LIMIT 1",
-			senderJID,
-		).Scan(&name)
-
-		if err == nil && name != "" {
-			return name
-		}
-
-		phonePart := senderJID
-		if idx := strings.Index(senderJID, "@"); idx > 0 {
-			phonePart = senderJID[:idx]
-		}
-
-		err = store.db.QueryRow(
-			"SELECT name FROM chats WHERE jid LIKE $1 LIMIT 1",
-			"%"+phonePart+"%",
-		).Scan(&name)
-
-		if err == nil && name != "" {
-			return name
-		}
-
-		return senderJID
-	}
-
-	err = store.db.QueryRow(
-		"SELECT name FROM chats WHERE jid = ? LIMIT 1",
-		senderJID,
-	).Scan(&name)
-
-	if err == nil && name != "" {
-		return name
-	}
-
-	phonePart := senderJID
-	if idx := strings.Index(senderJID, "@"); idx > 0 {
-		phonePart = senderJID[:idx]
-	}
-
-	err = store.db.QueryRow(
-		"SELECT name FROM chats WHERE jid LIKE ? LIMIT 1",
-		"%"+phonePart+"%",
-	).Scan(&name)
-
-	if err == nil && name != "" {
-		return name
-	}
-
-	return senderJID
-}
-
 func (store *MessageStore) FormatMessage(msg MessageInteraction, showChatInfo bool) string {
 	var sb strings.Builder
 
@@ -1640,8 +1988,23 @@ func (store *MessageStore) FormatMessage(msg MessageInteraction, showChatInfo bo
 		sb.WriteString(fmt.Sprintf("[%s] ", ts))
 	}
 
+	if msg.IsSystem {
+		sb.WriteString(fmt.Sprintf("* %s\n", msg.Content))
+		return sb.String()
+	}
+
 	prefix := ""
-	if msg.MediaType != "" {
+	switch msg.MediaType {
+	case "":
+	case mediaTypeLocation:
+		prefix = "[Location] "
+	case mediaTypeLiveLocation:
+		prefix = "[Live location] "
+	case mediaTypeContact:
+		prefix = "[Contact card] "
+	case mediaTypePoll:
+		prefix = fmt.Sprintf("[Poll - Message ID: %s - Chat JID: %s] ", msg.ID, msg.ChatJID)
+	default:
 		prefix = fmt.Sprintf("[%s - Message ID: %s - Chat JID: %s] ", msg.MediaType, msg.ID, msg.ChatJID)
 	}
 
@@ -1684,6 +2047,10 @@ func (store *MessageStore) ListMessages(s ListMessagesParams) (string, error) {
         JOIN chats c ON m.chat_jid = c.jid
     `
 
+	// Time range shown, for the group membership changes merged into the output
+	var from time.Time
+	to := time.Now()
+
 	if s.After != "" {
 		t, err := time.Parse(time.RFC3339, s.After)
 		if err != nil {
@@ -1691,6 +2058,7 @@ func (store *MessageStore) ListMessages(s ListMessagesParams) (string, error) {
 		}
 		where = append(where, "m.timestamp > "+placeholder(len(args)+1))
 		args = append(args, t)
+		from = t
 	}
 
 	if s.Before != "" {
@@ -1700,11 +2068,18 @@ func (store *MessageStore) ListMessages(s ListMessagesParams) (string, error) {
 		}
 		where = append(where, "m.timestamp < "+placeholder(len(args)+1))
 		args = append(args, t)
+		to = t
 	}
 
 	if s.SenderPhoneNumber != nil && *s.SenderPhoneNumber != "" {
-		where = append(where, "m.sender = "+placeholder(len(args)+1))
-		args = append(args, *s.SenderPhoneNumber)
+		// The sender may be stored by phone number or by LID
+		senders, _ := store.contactIdentities(*s.SenderPhoneNumber)
+		var in []string
+		for _, sender := range senders {
+			in = append(in, placeholder(len(args)+1))
+			args = append(args, sender)
+		}
+		where = append(where, "m.sender IN ("+strings.Join(in, ", ")+")")
 	}
 
 	if s.ChatJid != nil && *s.ChatJid != "" {
@@ -1717,6 +2092,13 @@ func (store *MessageStore) ListMessages(s ListMessagesParams) (string, error) {
 		args = append(args, "%"+*s.Query+"%")
 	}
 
+	if s.Access.restricted() {
+		where = append(where, s.Access.condition("m.chat_jid", func(v any) string {
+			args = append(args, v)
+			return placeholder(len(args))
+		}))
+	}
+
 	if len(where) > 0 {
 		q += " WHERE " + strings.Join(where, " AND ")
 	}
@@ -1774,22 +2156,54 @@ func (store *MessageStore) ListMessages(s ListMessagesParams) (string, error) {
 	if s.IncludeContext && len(msgs) > 0 {
 		var all []MessageInteraction
 		for _, m := range msgs {
-			ctx, err := store.GetMessageContext(m.ID, s.ContextBefore, s.ContextAfter)
+			ctx, err := store.GetMessageContext(m.ID, s.ContextBefore, s.ContextAfter, s.Access)
 			if err != nil {
 				log.Printf("context error for %s: %v", m.ID, err)
 				continue
 			}
-			all = append(all, ctx.Before...)
-			all = append(all, ctx.Message)
-			all = append(all, ctx.After...)
+			// Before is newest first; show the snippet in chronological order
+			var snippet []MessageInteraction
+			for i := len(ctx.Before) - 1; i >= 0; i-- {
+				snippet = append(snippet, ctx.Before[i])
+			}
+			snippet = append(snippet, ctx.Message)
+			snippet = append(snippet, ctx.After...)
+			snippet = store.withMembershipEvents(snippet, ctx.Message.ChatJID,
+				snippet[0].Timestamp, snippet[len(snippet)-1].Timestamp, false)
+			all = append(all, snippet...)
 		}
 		return store.FormatMessagesList(all, true), nil
 	}
 
+	// Membership changes only make sense next to the full conversation
+	if s.SenderPhoneNumber == nil && s.Query == nil {
+		chatJID := ""
+		if s.ChatJid != nil {
+			chatJID = *s.ChatJid
+		}
+		// Without a chat they come from every group, visible or not
+		if s.Access.restricted() && (chatJID == "" || !store.chatVisible(s.Access, chatJID)) {
+			return store.FormatMessagesList(msgs, true), nil
+		}
+		if len(msgs) > 0 {
+			if s.Page > 0 {
+				to = msgs[0].Timestamp
+			}
+			if len(msgs) == s.Limit {
+				from = msgs[len(msgs)-1].Timestamp
+			}
+		}
+		if len(msgs) > 0 || s.Page == 0 {
+			msgs = store.withMembershipEvents(msgs, chatJID, from, to, true)
+		}
+	}
+
 	return store.FormatMessagesList(msgs, true), nil
 }
 
-func (store *MessageStore) GetMessageContext(messageID string, before, after int) (MessageContext, error) {
+// GetMessageContext returns a message with the messages around it. Messages in
+// chats that access hides are not found.
+func (store *MessageStore) GetMessageContext(messageID string, before, after int, access chatAccess) (MessageContext, error) {
 	placeholder := func(n int) string {
 		if isPostgres {
 			return fmt.Sprintf("$%d", n)
@@ -1805,6 +2219,14 @@ func (store *MessageStore) GetMessageContext(messageID string, before, after int
         JOIN chats c ON m.chat_jid = c.jid
         WHERE m.id = ` + placeholder(1)
 
+	args := []any{messageID}
+	if access.restricted() {
+		q += " AND " + access.condition("m.chat_jid", func(v any) string {
+			args = append(args, v)
+			return placeholder(len(args))
+		})
+	}
+
 	var (
 		ts       time.Time
 		sender   string
@@ -1817,7 +2239,7 @@ func (store *MessageStore) GetMessageContext(messageID string, before, after int
 		chatJid2 string
 	)
 
-	row := store.db.QueryRow(q, messageID)
+	row := store.db.QueryRow(q, args...)
 	if err := row.Scan(&ts, &sender, &chatName, &content, &isFromMe, &chatJid, &id, &media, &chatJid2); err != nil {
 		if err == sql.ErrNoRows {
 			return MessageContext{}, fmt.Errorf("message not found: %s", messageID)
@@ -1952,6 +2374,7 @@ func (store *MessageStore) ListChats(
 	limit, page int,
 	includeLastMessage bool,
 	sortBy string,
+	access chatAccess,
 ) ([]Chat, error) {
 
 	placeholder := func(n int) string {
@@ -1990,6 +2413,13 @@ func (store *MessageStore) ListChats(
 		args = append(args, "%"+*query+"%", "%"+*query+"%")
 	}
 
+	if access.restricted() {
+		where = append(where, access.condition("c.jid", func(v any) string {
+			args = append(args, v)
+			return placeholder(len(args))
+		}))
+	}
+
 	if len(where) > 0 {
 		q += " WHERE " + strings.Join(where, " AND ")
 	}
@@ -2003,7 +2433,7 @@ func (store *MessageStore) ListChats(
 	q += " LIMIT " + placeholder(len(args)+1)
 	args = append(args, limit)
 
-	q += " OFFSET " + placeholder(len(args)+2)
+	q += " OFFSET " + placeholder(len(args)+1)
 	args = append(args, page*limit)
 
 	rows, err := store.db.Query(q, args...)
@@ -2052,67 +2482,25 @@ func (store *MessageStore) ListChats(
 	return chats, nil
 }
 
-func (store *MessageStore) SearchContacts(query string) ([]Contact, error) {
-	placeholder := func(n int) string {
-		if isPostgres {
-			return fmt.Sprintf("$%d", n)
-		}
-		return "?"
-	}
-
-	q := `
-        SELECT DISTINCT their_jid, first_name
-        FROM whatsmeow_contacts
-        WHERE (LOWER(first_name) LIKE LOWER(` + placeholder(1) + `)
-           OR LOWER(their_jid) LIKE LOWER(` + placeholder(2) + `))
-          AND their_jid NOT LIKE '%@g.us'
-        ORDER BY first_name, their_jid
-        LIMIT 50
-    `
-
-	args := []any{"%" + query + "%", "%" + query + "%"}
+func (store *MessageStore) GetContactChats(jid string, limit, page int, access chatAccess) ([]Chat, error) {
+	// Match the contact by phone number and by LID
+	senders, chatJIDs := store.contactIdentities(jid)
 
-	rows, err := store.db.Query(q, args...)
-	if err != nil {
-		return nil, err
+	var args []any
+	for _, v := range senders {
+		args = append(args, v)
 	}
-	defer rows.Close()
-
-	var contacts []Contact
-
-	for rows.Next() {
-		var jid, name sql.NullString
-
-		if err := rows.Scan(&jid, &name); err != nil {
-			continue
-		}
-		if !jid.Valid {
-			continue
-		}
-
-		phone := strings.Split(jid.String, "@")[0]
-
-		c := Contact{
-			PhoneNumber: phone,
-			JID:         jid.String,
-		}
-		if name.Valid {
-			c.Name = name.String
-		}
-
-		contacts = append(contacts, c)
+	for _, v := range chatJIDs {
+		args = append(args, v)
 	}
-
-	return contacts, nil
-}
-
-func (store *MessageStore) GetContactChats(jid string, limit, page int) ([]Chat, error) {
-	placeholder := func(n int) string {
-		if isPostgres {
-			return fmt.Sprintf("$%d", n)
-		}
-		return "?"
+	visible := ""
+	if access.restricted() {
+		visible = " AND " + access.condition("c.jid", func(v any) string {
+			args = append(args, v)
+			return "?"
+		})
 	}
+	args = append(args, limit, page*limit)
 
 	q := `
         SELECT DISTINCT
@@ -2122,15 +2510,13 @@ func (store *MessageStore) GetContactChats(jid string, limit, page int) ([]Chat,
             m.is_from_me AS last_is_from_me
         FROM chats c
         JOIN messages m ON c.jid = m.chat_jid
-        WHERE m.sender = ` + placeholder(1) + ` 
-           OR c.jid = ` + placeholder(2) + `
+        WHERE (m.sender IN (` + placeholders(len(senders)) + `) 
+           OR c.jid IN (` + placeholders(len(chatJIDs)) + `))` + visible + `
         ORDER BY c.last_message_time DESC
-        LIMIT ` + placeholder(3) + `
-        OFFSET ` + placeholder(4)
+        LIMIT ?
+        OFFSET ?`
 
-	args := []any{jid, jid, limit, page * limit}
-
-	rows, err := store.db.Query(q, args...)
+	rows, err := store.db.Query(rebind(q), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -2177,12 +2563,8 @@ func (store *MessageStore) GetContactChats(jid string, limit, page int) ([]Chat,
 }
 
 func (store *MessageStore) GetLastInteraction(jid string) (string, error) {
-	placeholder := func(n int) string {
-		if isPostgres {
-			return fmt.Sprintf("$%d", n)
-		}
-		return "?"
-	}
+	// Match the contact by phone number and by LID
+	senders, chatJIDs := store.contactIdentities(jid)
 
 	q := `
         SELECT 
@@ -2190,13 +2572,21 @@ func (store *MessageStore) GetLastInteraction(jid string) (string, error) {
             c.jid, m.id, m.media_type
         FROM messages m
         JOIN chats c ON m.chat_jid = c.jid
-        WHERE m.sender = ` + placeholder(1) + `
-           OR c.jid = ` + placeholder(2) + `
+        WHERE m.sender IN (` + placeholders(len(senders)) + `)
+           OR c.jid IN (` + placeholders(len(chatJIDs)) + `)
         ORDER BY m.timestamp DESC
         LIMIT 1
     `
 
-	row := store.db.QueryRow(q, jid, jid)
+	var args []any
+	for _, v := range senders {
+		args = append(args, v)
+	}
+	for _, v := range chatJIDs {
+		args = append(args, v)
+	}
+
+	row := store.db.QueryRow(rebind(q), args...)
 
 	var (
 		ts        time.Time
@@ -2302,12 +2692,9 @@ func (store *MessageStore) GetChat(chatJID string, includeLastMessage bool) (*Ch
 }
 
 func (store *MessageStore) GetDirectChatByContact(phone string) (*Chat, error) {
-	placeholder := func(n int) string {
-		if isPostgres {
-			return fmt.Sprintf("$%d", n)
-		}
-		return "?"
-	}
+	// Match the exact chat JIDs of the contact, by phone number and by LID, so
+	// one number can't match another that contains it
+	_, chatJIDs := store.contactIdentities(phone)
 
 	q := `
        SELECT 
@@ -2319,14 +2706,18 @@ func (store *MessageStore) GetDirectChatByContact(phone string) (*Chat, error) {
        LEFT JOIN messages m 
            ON c.jid = m.chat_jid 
           AND c.last_message_time = m.timestamp
-       WHERE c.jid LIKE ` + placeholder(1) + `
+       WHERE c.jid IN (` + placeholders(len(chatJIDs)) + `)
          AND c.jid NOT LIKE '%@g.us'
+       ORDER BY c.last_message_time DESC
        LIMIT 1
     `
 
-	arg := "%" + phone + "%"
+	var args []any
+	for _, v := range chatJIDs {
+		args = append(args, v)
+	}
 
-	row := store.db.QueryRow(q, arg)
+	row := store.db.QueryRow(rebind(q), args...)
 
 	var (
 		jid     string
@@ -2338,6 +2729,9 @@ func (store *MessageStore) GetDirectChatByContact(phone string) (*Chat, error) {
 	)
 
 	if err := row.Scan(&jid, &name, &lmt, &lmsg, &lsender, &lfromme); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 
@@ -2416,6 +2810,20 @@ func main() {
 	}
 	defer messageStore.Close()
 
+	if sendLimits, err = loadSendLimiter(); err != nil {
+		logger.Errorf("Failed to read send limits: %v", err)
+		return
+	}
+	if apiKeys, err = loadAPIKeys(); err != nil {
+		logger.Errorf("Failed to read API keys: %v", err)
+		return
+	}
+	auditMaxAge, err := loadAuditMaxAge()
+	if err != nil {
+		logger.Errorf("Failed to read audit settings: %v", err)
+		return
+	}
+
 	client.AddEventHandler(func(evt interface{}) {
 		switch v := evt.(type) {
 		case *events.Message:
@@ -2424,8 +2832,39 @@ func main() {
 		case *events.HistorySync:
 			handleHistorySync(client, messageStore, v, logger)
 
+		case *events.MediaRetry:
+			handleMediaRetry(messageStore, v)
+
+		case *events.PushName, *events.BusinessName, *events.Contact:
+			handleContactEvent(messageStore, v)
+
+		case *events.GroupInfo:
+			handleGroupInfo(messageStore, v)
+
+		case *events.JoinedGroup:
+			handleJoinedGroup(client, messageStore, v)
+
+		case *events.Picture:
+			go handlePictureEvent(client, messageStore, v)
+
+		case *events.UserAbout:
+			go handleUserAbout(client, messageStore, v)
+
+		case *events.Presence:
+			handlePresence(client, messageStore, v)
+
+		case *events.ChatPresence:
+			handleChatPresence(client, messageStore, v)
+
+		case *events.LabelEdit, *events.LabelAssociationChat:
+			handleLabelEvent(client, messageStore, v)
+
 		case *events.Connected:
 			logger.Infof("Connected to WhatsApp")
+			go importDeviceContacts(client, messageStore)
+			go resubscribePresence(client)
+			outbox.notify()
+			bulk.notify()
 
 		case *events.LoggedOut:
 			logger.Warnf("Device logged out, please scan QR code to log in again")
@@ -2477,6 +2916,10 @@ func main() {
 
 	fmt.Println("\n✓ Connected to WhatsApp! Type 'help' for commands.")
 
+	go outbox.run(client, messageStore)
+	go runScheduler(client, messageStore)
+	go bulk.run(client, messageStore)
+	go runAuditRetention(messageStore, auditMaxAge)
 	startRESTServer(client, messageStore, 8080)
 
 	exitChan := make(chan os.Signal, 1)
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMediaCacheMaxBytes = 1 << 30 // 1 GiB
+
+// MediaCache tracks downloaded media files so the store directory can be kept
+// within a size and age quota. Files are evicted least recently used first.
+type MediaCache struct {
+	db       *sql.DB
+	maxBytes int64
+	maxAge   time.Duration
+	mu       sync.Mutex
+}
+
+// MediaCacheStats summarises what the cache currently holds
+type MediaCacheStats struct {
+	Files        int        `json:"files"`
+	TotalBytes   int64      `json:"total_bytes"`
+	MaxBytes     int64      `json:"max_bytes"`
+	MaxAge       string     `json:"max_age,omitempty"`
+	OldestAccess *time.Time `json:"oldest_access,omitempty"`
+	NewestAccess *time.Time `json:"newest_access,omitempty"`
+}
+
+// MediaCachePurgeResult reports what a purge or quota run removed
+type MediaCachePurgeResult struct {
+	Removed    int   `json:"removed"`
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+type mediaCacheEntry struct {
+	path string
+	size int64
+}
+
+// NewMediaCache reads the quota from MEDIA_CACHE_MAX_BYTES (0 disables the size
+// limit) and MEDIA_CACHE_MAX_AGE (a Go duration such as 720h, empty for none).
+func NewMediaCache(db *sql.DB) (*MediaCache, error) {
+	cache := &MediaCache{db: db, maxBytes: defaultMediaCacheMaxBytes}
+
+	if val, ok := os.LookupEnv("MEDIA_CACHE_MAX_BYTES"); ok && val != "" {
+		maxBytes, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || maxBytes < 0 {
+			return nil, fmt.Errorf("invalid MEDIA_CACHE_MAX_BYTES: %q", val)
+		}
+		cache.maxBytes = maxBytes
+	}
+	if val, ok := os.LookupEnv("MEDIA_CACHE_MAX_AGE"); ok && val != "" {
+		maxAge, err := time.ParseDuration(val)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("invalid MEDIA_CACHE_MAX_AGE: %q", val)
+		}
+		cache.maxAge = maxAge
+	}
+
+	return cache, nil
+}
+
+// Lookup returns true when path holds a valid cached copy of the media. A file
+// whose SHA256 doesn't match expectedSHA256 is deleted so it gets downloaded again.
+func (c *MediaCache) Lookup(messageID, chatJID, path string, expectedSHA256 []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		c.forget(path)
+		return false
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		fmt.Printf("Failed to hash cached media %s: %v\n", path, err)
+		return false
+	}
+	if len(expectedSHA256) > 0 && !bytes.Equal(sum, expectedSHA256) {
+		fmt.Printf("Cached media %s failed SHA256 verification, downloading again\n", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove corrupt media %s: %v\n", path, err)
+		}
+		c.forget(path)
+		return false
+	}
+
+	// Files downloaded before the cache existed are adopted on first use
+	if err := c.record(messageID, chatJID, path, info.Size(), sum); err != nil {
+		fmt.Printf("Failed to update media cache entry: %v\n", err)
+	}
+	return true
+}
+
+// Add records a freshly downloaded file and evicts old files if over quota
+func (c *MediaCache) Add(messageID, chatJID, path string, data []byte) error {
+	sum := sha256.Sum256(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.record(messageID, chatJID, path, int64(len(data)), sum[:]); err != nil {
+		return err
+	}
+	_, err := c.enforce(path)
+	return err
+}
+
+// Enforce applies the size and age quota
+func (c *MediaCache) Enforce() (MediaCachePurgeResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enforce("")
+}
+
+// Purge removes cached files not accessed within olderThan, optionally limited
+// to one chat. A zero olderThan removes everything that matches.
+func (c *MediaCache) Purge(olderThan time.Duration, chatJID string) (MediaCachePurgeResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	query := "SELECT path, size FROM media_cache WHERE last_access <= ?"
+	args := []interface{}{time.Now().Add(-olderThan)}
+	if chatJID != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, chatJID)
+	}
+
+	entries, err := c.entries(query, args...)
+	if err != nil {
+		return MediaCachePurgeResult{}, err
+	}
+	return c.remove(entries)
+}
+
+// Stats reports the number and size of cached files
+func (c *MediaCache) Stats() (MediaCacheStats, error) {
+	stats := MediaCacheStats{MaxBytes: c.maxBytes}
+	if c.maxAge > 0 {
+		stats.MaxAge = c.maxAge.String()
+	}
+
+	var total sql.NullInt64
+	err := c.db.QueryRow("SELECT COUNT(*), SUM(size) FROM media_cache").Scan(&stats.Files, &total)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read media cache stats: %v", err)
+	}
+	stats.TotalBytes = total.Int64
+
+	// Aggregates lose the column type on sqlite, so read the bounds as rows
+	for _, order := range []string{"ASC", "DESC"} {
+		var access time.Time
+		err := c.db.QueryRow(
+			"SELECT last_access FROM media_cache ORDER BY last_access " + order + " LIMIT 1",
+		).Scan(&access)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("failed to read media cache stats: %v", err)
+		}
+		if order == "ASC" {
+			stats.OldestAccess = &access
+		} else {
+			stats.NewestAccess = &access
+		}
+	}
+	return stats, nil
+}
+
+func (c *MediaCache) enforce(keep string) (MediaCachePurgeResult, error) {
+	var result MediaCachePurgeResult
+
+	if c.maxAge > 0 {
+		expired, err := c.entries(
+			"SELECT path, size FROM media_cache WHERE last_access < ?",
+			time.Now().Add(-c.maxAge),
+		)
+		if err != nil {
+			return result, err
+		}
+		if result, err = c.remove(expired); err != nil {
+			return result, err
+		}
+	}
+
+	if c.maxBytes == 0 {
+		return result, nil
+	}
+
+	var total sql.NullInt64
+	if err := c.db.QueryRow("SELECT SUM(size) FROM media_cache").Scan(&total); err != nil {
+		return result, fmt.Errorf("failed to read media cache size: %v", err)
+	}
+	if total.Int64 <= c.maxBytes {
+		return result, nil
+	}
+
+	lru, err := c.entries("SELECT path, size FROM media_cache ORDER BY last_access ASC")
+	if err != nil {
+		return result, err
+	}
+
+	var victims []mediaCacheEntry
+	excess := total.Int64 - c.maxBytes
+	for _, entry := range lru {
+		if excess <= 0 {
+			break
+		}
+		// Never evict the file that is about to be served
+		if entry.path == keep {
+			continue
+		}
+		victims = append(victims, entry)
+		excess -= entry.size
+	}
+
+	evicted, err := c.remove(victims)
+	result.Removed += evicted.Removed
+	result.FreedBytes += evicted.FreedBytes
+	return result, err
+}
+
+func (c *MediaCache) entries(query string, args ...interface{}) ([]mediaCacheEntry, error) {
+	rows, err := c.db.Query(rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query media cache: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []mediaCacheEntry
+	for rows.Next() {
+		var entry mediaCacheEntry
+		if err := rows.Scan(&entry.path, &entry.size); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (c *MediaCache) remove(entries []mediaCacheEntry) (MediaCachePurgeResult, error) {
+	var result MediaCachePurgeResult
+	for _, entry := range entries {
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("failed to remove %s: %v", entry.path, err)
+		}
+		if err := c.forget(entry.path); err != nil {
+			return result, err
+		}
+		result.Removed++
+		result.FreedBytes += entry.size
+	}
+	return result, nil
+}
+
+func (c *MediaCache) record(messageID, chatJID, path string, size int64, sum []byte) error {
+	now := time.Now()
+	_, err := c.db.Exec(rebind(`
+		INSERT INTO media_cache (path, message_id, chat_jid, size, sha256, created_at, last_access)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET
+			size = EXCLUDED.size,
+			sha256 = EXCLUDED.sha256,
+			last_access = EXCLUDED.last_access`),
+		path, messageID, chatJID, size, sum, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record media cache entry: %v", err)
+	}
+	return nil
+}
+
+func (c *MediaCache) forget(path string) error {
+	if _, err := c.db.Exec(rebind("DELETE FROM media_cache WHERE path = ?"), path); err != nil {
+		return fmt.Errorf("failed to delete media cache entry: %v", err)
+	}
+	return nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// MediaInfo is the media attached to a message, as persisted alongside it
+type MediaInfo struct {
+	Type             string // image, video, ptv, audio, document, sticker, location, live_location, contact or poll
+	Filename         string // local file name, derived from the message ID and mimetype
+	OriginalFilename string // file name given by the sender (documents only)
+	Mimetype         string
+	Caption          string
+	URL              string
+	DirectPath       string
+	MediaKey         []byte
+	FileSHA256       []byte
+	FileEncSHA256    []byte
+	FileLength       uint64
+	Width            uint32
+	Height           uint32
+	Seconds          uint32
+
+	// Locations and contact cards
+	Latitude        float64
+	Longitude       float64
+	LocationName    string
+	LocationAddress string
+	VCard           string
+}
+
+// mediaExtensions maps the mimetypes WhatsApp commonly sends to file extensions.
+// mime.ExtensionsByType is only a fallback as its first answer is often odd (.jfif).
+var mediaExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"video/mp4":       ".mp4",
+	"video/3gpp":      ".3gp",
+	"video/quicktime": ".mov",
+	"audio/ogg":       ".ogg",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"audio/aac":       ".aac",
+	"audio/amr":       ".amr",
+	"application/pdf": ".pdf",
+}
+
+// defaultExtensions is used when the mimetype is missing or unknown
+var defaultExtensions = map[string]string{
+	"image":    ".jpg",
+	"video":    ".mp4",
+	"ptv":      ".mp4",
+	"audio":    ".ogg",
+	"sticker":  ".webp",
+	"document": "",
+}
+
+// Extract media info from a message
+func extractMediaInfo(msg *waE2E.Message, messageID string) *MediaInfo {
+	if msg == nil {
+		return nil
+	}
+
+	var info *MediaInfo
+	if img := msg.GetImageMessage(); img != nil {
+		info = &MediaInfo{Type: "image", Caption: img.GetCaption(), Width: img.GetWidth(), Height: img.GetHeight()}
+		fillMediaInfo(info, img, img.GetMimetype(), img.GetFileLength())
+	} else if vid := msg.GetVideoMessage(); vid != nil {
+		info = &MediaInfo{Type: "video", Caption: vid.GetCaption(), Width: vid.GetWidth(), Height: vid.GetHeight(), Seconds: vid.GetSeconds()}
+		fillMediaInfo(info, vid, vid.GetMimetype(), vid.GetFileLength())
+	} else if ptv := msg.GetPtvMessage(); ptv != nil {
+		info = &MediaInfo{Type: "ptv", Width: ptv.GetWidth(), Height: ptv.GetHeight(), Seconds: ptv.GetSeconds()}
+		fillMediaInfo(info, ptv, ptv.GetMimetype(), ptv.GetFileLength())
+	} else if aud := msg.GetAudioMessage(); aud != nil {
+		info = &MediaInfo{Type: "audio", Seconds: aud.GetSeconds()}
+		fillMediaInfo(info, aud, aud.GetMimetype(), aud.GetFileLength())
+	} else if sticker := msg.GetStickerMessage(); sticker != nil {
+		info = &MediaInfo{Type: "sticker", Width: sticker.GetWidth(), Height: sticker.GetHeight()}
+		fillMediaInfo(info, sticker, sticker.GetMimetype(), sticker.GetFileLength())
+	} else if doc := documentMessage(msg); doc != nil {
+		info = &MediaInfo{Type: "document", Caption: doc.GetCaption(), OriginalFilename: doc.GetFileName()}
+		fillMediaInfo(info, doc, doc.GetMimetype(), doc.GetFileLength())
+	} else {
+		return extractSharedInfo(msg)
+	}
+
+	info.Filename = mediaFilename(messageID, info.Type, info.Mimetype, info.OriginalFilename)
+	return info
+}
+
+func fillMediaInfo(info *MediaInfo, media whatsmeow.DownloadableMessage, mimetype string, fileLength uint64) {
+	if urlable, ok := media.(interface{ GetURL() string }); ok {
+		info.URL = urlable.GetURL()
+	}
+	info.DirectPath = media.GetDirectPath()
+	info.MediaKey = media.GetMediaKey()
+	info.FileSHA256 = media.GetFileSHA256()
+	info.FileEncSHA256 = media.GetFileEncSHA256()
+	info.FileLength = fileLength
+	info.Mimetype = mimetype
+}
+
+// documentMessage also finds documents sent with a caption, which are wrapped
+func documentMessage(msg *waE2E.Message) *waE2E.DocumentMessage {
+	if doc := msg.GetDocumentMessage(); doc != nil {
+		return doc
+	}
+	return msg.GetDocumentWithCaptionMessage().GetMessage().GetDocumentMessage()
+}
+
+// mediaFilename names the local copy after the message ID, which is unique per
+// chat and stable across history syncs, with an extension from the mimetype
+func mediaFilename(messageID, mediaType, mimetype, originalFilename string) string {
+	base := strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(messageID)
+	if base == "" {
+		base = mediaType
+	}
+
+	ext := ""
+	// Generic binary documents keep the extension the sender gave them
+	if parsed, _, err := mime.ParseMediaType(mimetype); err == nil && parsed != "application/octet-stream" {
+		ext = mediaExtensions[parsed]
+		if ext == "" {
+			if exts, _ := mime.ExtensionsByType(parsed); len(exts) > 0 {
+				ext = exts[0]
+			}
+		}
+	}
+	if ext == "" && originalFilename != "" {
+		ext = strings.ToLower(filepath.Ext(originalFilename))
+	}
+	if ext == "" {
+		ext = defaultExtensions[mediaType]
+	}
+	return base + ext
+}
+
+// whatsmeowMediaType maps a stored media type to the key used to decrypt it
+func whatsmeowMediaType(mediaType string) (whatsmeow.MediaType, bool) {
+	switch mediaType {
+	case "image", "sticker":
+		return whatsmeow.MediaImage, true
+	case "video", "ptv":
+		return whatsmeow.MediaVideo, true
+	case "audio":
+		return whatsmeow.MediaAudio, true
+	case "document":
+		return whatsmeow.MediaDocument, true
+	}
+	return "", false
+}
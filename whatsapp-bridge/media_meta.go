@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"regexp"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// thumbnailMaxSide bounds the inline preview shown before the media is downloaded
+	thumbnailMaxSide = 100
+	thumbnailQuality = 60
+)
+
+// imageMetadata decodes an image to get its dimensions and a small JPEG thumbnail
+func imageMetadata(data []byte) (width, height uint32, thumbnail []byte, err error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	bounds := img.Bounds()
+	thumbnail, err = jpegThumbnail(img)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return uint32(bounds.Dx()), uint32(bounds.Dy()), thumbnail, nil
+}
+
+// jpegThumbnail scales img to fit within thumbnailMaxSide and encodes it as JPEG
+func jpegThumbnail(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("image has no pixels")
+	}
+	scale := math.Min(1, float64(thumbnailMaxSide)/float64(max(w, h)))
+	tw := max(1, int(math.Round(float64(w)*scale)))
+	th := max(1, int(math.Round(float64(h)*scale)))
+
+	// Draw onto white so transparent PNG/GIF/WebP areas don't turn black
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// videoInfo is the metadata read from an MP4/QuickTime container
+type videoInfo struct {
+	Seconds   uint32
+	Width     uint32
+	Height    uint32
+	Thumbnail []byte
+}
+
+// mp4Metadata walks the ISO base media boxes to find the movie duration and the
+// display size of the first video track. It does not decode any frames.
+func mp4Metadata(data []byte) (videoInfo, error) {
+	var info videoInfo
+	moov := findBox(data, "moov")
+	if moov == nil {
+		return info, fmt.Errorf("no moov box found")
+	}
+
+	if mvhd := findBox(moov, "mvhd"); len(mvhd) >= 20 {
+		var timescale uint32
+		var duration uint64
+		if mvhd[0] == 1 {
+			if len(mvhd) >= 32 {
+				timescale = binary.BigEndian.Uint32(mvhd[20:24])
+				duration = binary.BigEndian.Uint64(mvhd[24:32])
+			}
+		} else {
+			timescale = binary.BigEndian.Uint32(mvhd[12:16])
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		}
+		if timescale > 0 {
+			info.Seconds = uint32(math.Round(float64(duration) / float64(timescale)))
+		}
+	}
+
+	for _, trak := range findBoxes(moov, "trak") {
+		hdlr := findBox(findBox(trak, "mdia"), "hdlr")
+		if len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+			continue
+		}
+		tkhd := findBox(trak, "tkhd")
+		// Width and height are 16.16 fixed point at the end of the box
+		if len(tkhd) >= 84 {
+			info.Width = binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16
+			info.Height = binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16
+		}
+		break
+	}
+
+	// Frames aren't decoded, so the only preview available is embedded cover art
+	if cover := mp4CoverArt(moov); cover != nil {
+		if img, _, err := image.Decode(bytes.NewReader(cover)); err == nil {
+			info.Thumbnail, _ = jpegThumbnail(img)
+		}
+	}
+
+	if info.Seconds == 0 && info.Width == 0 {
+		return info, fmt.Errorf("no duration or video track found")
+	}
+	return info, nil
+}
+
+// mp4CoverArt returns the image in the iTunes cover art atom
+// (moov/udta/meta/ilst/covr/data), or nil if there is none
+func mp4CoverArt(moov []byte) []byte {
+	meta := findBox(findBox(moov, "udta"), "meta")
+	// In MP4 files meta is a full box with 4 bytes of version and flags before
+	// its children, in QuickTime files it is not
+	ilst := findBox(meta, "ilst")
+	if ilst == nil && len(meta) >= 4 {
+		ilst = findBox(meta[4:], "ilst")
+	}
+	data := findBox(findBox(ilst, "covr"), "data")
+	// The image follows 4 bytes of type and 4 bytes of locale
+	if len(data) <= 8 {
+		return nil
+	}
+	return data[8:]
+}
+
+// findBox returns the payload of the first direct child box with the given type
+func findBox(data []byte, boxType string) []byte {
+	boxes := findBoxes(data, boxType)
+	if len(boxes) == 0 {
+		return nil
+	}
+	return boxes[0]
+}
+
+// findBoxes returns the payloads of all direct child boxes with the given type
+func findBoxes(data []byte, boxType string) [][]byte {
+	var found [][]byte
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		name := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0: // box extends to the end of the data
+			size = uint64(len(data))
+		case 1: // 64-bit size follows the type
+			if len(data) < 16 {
+				return found
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return found
+		}
+		if name == boxType {
+			found = append(found, data[header:size])
+		}
+		data = data[size:]
+	}
+	return found
+}
+
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// pdfPageCount estimates the number of pages in a PDF by counting page objects
+func pdfPageCount(data []byte) uint32 {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return 0
+	}
+	return uint32(len(pdfPagePattern.FindAllIndex(data, -1)))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	// mediaRetryWait is how long a download request waits for the phone to re-upload
+	mediaRetryWait = 20 * time.Second
+	// mediaRetryResend is how long to wait before asking the phone again
+	mediaRetryResend = 5 * time.Minute
+)
+
+// errMediaRetryPending is returned when expired media has been requested from the
+// phone again but the re-upload hasn't arrived yet. The caller should try later.
+var errMediaRetryPending = errors.New("media expired on the server; a re-upload was requested from the phone, try again shortly")
+
+// pendingMediaRetry is one outstanding re-upload request
+type pendingMediaRetry struct {
+	requested time.Time
+	mediaKey  []byte
+	done      chan struct{}
+
+	// Set before done is closed
+	directPath string
+	err        error
+}
+
+// mediaRetryTracker matches events.MediaRetry responses to the downloads waiting on them
+type mediaRetryTracker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingMediaRetry
+}
+
+var mediaRetries = &mediaRetryTracker{pending: make(map[string]*pendingMediaRetry)}
+
+func mediaRetryKey(chatJID, messageID string) string {
+	return chatJID + "/" + messageID
+}
+
+// isExpiredMediaError reports whether a download failed because the CDN copy is gone
+func isExpiredMediaError(err error) bool {
+	return errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410)
+}
+
+// requestMediaRetry asks the phone to re-upload the media of a message, unless a
+// recent request for it is still outstanding, and returns the pending request.
+func requestMediaRetry(client *whatsmeow.Client, messageStore *MessageStore, messageID, chatJID string, mediaKey []byte) (*pendingMediaRetry, error) {
+	key := mediaRetryKey(chatJID, messageID)
+
+	mediaRetries.mu.Lock()
+	defer mediaRetries.mu.Unlock()
+
+	if retry, ok := mediaRetries.pending[key]; ok && time.Since(retry.requested) < mediaRetryResend {
+		return retry, nil
+	}
+
+	info, err := messageStore.retryMessageInfo(messageID, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SendMediaRetryReceipt(context.Background(), info, mediaKey); err != nil {
+		return nil, fmt.Errorf("failed to request media re-upload: %v", err)
+	}
+	fmt.Printf("Requested media re-upload for message %s in chat %s\n", messageID, chatJID)
+
+	retry := &pendingMediaRetry{
+		requested: time.Now(),
+		mediaKey:  mediaKey,
+		done:      make(chan struct{}),
+	}
+	mediaRetries.prune(retry.requested)
+	mediaRetries.pending[key] = retry
+	return retry, nil
+}
+
+// prune forgets requests the phone hasn't answered by the time they would be
+// sent again. Nobody waits on them any more, and a late answer still finds the
+// media key in the store. The caller holds mu.
+func (t *mediaRetryTracker) prune(now time.Time) {
+	for key, retry := range t.pending {
+		if now.Sub(retry.requested) >= mediaRetryResend {
+			delete(t.pending, key)
+		}
+	}
+}
+
+// wait blocks until the phone answers or the timeout passes. It returns the new
+// direct path, or errMediaRetryPending if there is no answer yet.
+func (retry *pendingMediaRetry) wait(timeout time.Duration) (string, error) {
+	select {
+	case <-retry.done:
+		return retry.directPath, retry.err
+	case <-time.After(timeout):
+		return "", errMediaRetryPending
+	}
+}
+
+// handleMediaRetry processes the phone's answer to a re-upload request and
+// stores the new location of the media so the download can complete.
+func handleMediaRetry(messageStore *MessageStore, evt *events.MediaRetry) {
+	chatJID := evt.ChatID.String()
+	key := mediaRetryKey(chatJID, evt.MessageID)
+
+	mediaRetries.mu.Lock()
+	retry, ok := mediaRetries.pending[key]
+	delete(mediaRetries.pending, key)
+	mediaRetries.mu.Unlock()
+
+	var mediaKey []byte
+	if ok {
+		mediaKey = retry.mediaKey
+	} else {
+		// The request outlived the bridge process; the media key is still stored
+		if media, err := messageStore.GetMediaInfo(evt.MessageID, chatJID); err == nil {
+			mediaKey = media.MediaKey
+		}
+	}
+
+	directPath, err := decryptMediaRetry(evt, mediaKey)
+	if err == nil {
+		err = messageStore.UpdateMediaLocation(evt.MessageID, chatJID, directPath)
+	}
+	if err != nil {
+		fmt.Printf("Media re-upload for message %s failed: %v\n", evt.MessageID, err)
+	} else {
+		fmt.Printf("Media for message %s was re-uploaded\n", evt.MessageID)
+	}
+
+	if ok {
+		retry.directPath, retry.err = directPath, err
+		close(retry.done)
+	}
+}
+
+func decryptMediaRetry(evt *events.MediaRetry, mediaKey []byte) (string, error) {
+	if len(mediaKey) == 0 {
+		return "", fmt.Errorf("no media key stored for message")
+	}
+	notif, err := whatsmeow.DecryptMediaRetryNotification(evt, mediaKey)
+	if err != nil {
+		return "", err
+	}
+	if notif.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
+		return "", fmt.Errorf("phone could not re-upload media: %s", notif.GetResult())
+	}
+	if notif.GetDirectPath() == "" {
+		return "", fmt.Errorf("re-upload response has no direct path")
+	}
+	return notif.GetDirectPath(), nil
+}
+
+// retryMessageInfo rebuilds the message details a re-upload request needs
+func (store *MessageStore) retryMessageInfo(messageID, chatJID string) (*types.MessageInfo, error) {
+	var sender string
+	var isFromMe bool
+	err := store.db.QueryRow(
+		rebind("SELECT sender, is_from_me FROM messages WHERE id = ? AND chat_jid = ?"),
+		messageID, chatJID,
+	).Scan(&sender, &isFromMe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message: %v", err)
+	}
+
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat JID: %v", err)
+	}
+	senderJID := types.NewJID(sender, types.DefaultUserServer)
+	if strings.Contains(sender, "@") {
+		if parsed, err := types.ParseJID(sender); err == nil {
+			senderJID = parsed
+		}
+	}
+
+	return &types.MessageInfo{
+		ID: messageID,
+		MessageSource: types.MessageSource{
+			Chat:     chat,
+			Sender:   senderJID,
+			IsFromMe: isFromMe,
+			IsGroup:  chat.Server == types.GroupServer,
+		},
+	}, nil
+}
+
+// UpdateMediaLocation stores the new direct path of re-uploaded media. The old
+// URL is cleared because whatsmeow prefers it over the direct path.
+func (store *MessageStore) UpdateMediaLocation(messageID, chatJID, directPath string) error {
+	media, err := store.GetMediaInfo(messageID, chatJID)
+	if err != nil {
+		return fmt.Errorf("failed to load media info: %v", err)
+	}
+	media.URL = ""
+	media.DirectPath = directPath
+	return store.StoreMediaInfo(messageID, chatJID, media)
+}
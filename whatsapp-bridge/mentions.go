@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Mentions are stored one row per mentioned user in message_mentions, under the
+// same canonical JID senders use, so "where was I mentioned" is a simple lookup.
+
+// messageContextInfo returns the context info of the message types that can
+// mention someone
+func messageContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case documentMessage(msg) != nil:
+		return documentMessage(msg).GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	}
+	return nil
+}
+
+// storeMessageMentions records who a stored message mentions, if anyone
+func storeMessageMentions(client *whatsmeow.Client, messageStore *MessageStore, messageID, chatJID string, msg *waE2E.Message) {
+	var mentioned []string
+	for _, raw := range messageContextInfo(msg).GetMentionedJID() {
+		jid, err := types.ParseJID(raw)
+		if err != nil || jid.User == "" {
+			continue
+		}
+		mentioned = append(mentioned, senderKey(canonicalJID(client, messageStore, jid)))
+	}
+	if len(mentioned) == 0 {
+		return
+	}
+	if err := messageStore.StoreMentions(messageID, chatJID, mentioned); err != nil {
+		fmt.Printf("Failed to store mentions of %s: %v\n", messageID, err)
+	}
+}
+
+// StoreMentions records the users a message mentions
+func (store *MessageStore) StoreMentions(messageID, chatJID string, mentioned []string) error {
+	for _, jid := range mentioned {
+		_, err := store.db.Exec(rebind(`
+			INSERT INTO message_mentions (message_id, chat_jid, mentioned_jid)
+			VALUES (?, ?, ?)
+			ON CONFLICT (message_id, chat_jid, mentioned_jid) DO NOTHING`),
+			messageID, chatJID, jid,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store mention: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetMentions returns the messages since a time that mention any of the given
+// JIDs, newest first
+func (store *MessageStore) GetMentions(mentioned []string, since time.Time, limit int, access chatAccess) ([]MessageInteraction, error) {
+	args := []interface{}{}
+	for _, jid := range mentioned {
+		args = append(args, jid)
+	}
+	args = append(args, since)
+	visible := ""
+	if access.restricted() {
+		visible = " AND " + access.condition("m.chat_jid", func(v any) string {
+			args = append(args, v)
+			return "?"
+		})
+	}
+	args = append(args, limit)
+
+	rows, err := store.db.Query(rebind(`
+		SELECT DISTINCT m.timestamp, m.sender, COALESCE(c.name, ''), m.content, m.is_from_me,
+			m.chat_jid, m.id, COALESCE(m.media_type, '')
+		FROM message_mentions mm
+		JOIN messages m ON m.id = mm.message_id AND m.chat_jid = mm.chat_jid
+		LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE mm.mentioned_jid IN (`+placeholders(len(mentioned))+`) AND m.timestamp >= ?`+visible+`
+		ORDER BY m.timestamp DESC
+		LIMIT ?`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mentions: %v", err)
+	}
+	defer rows.Close()
+
+	var msgs []MessageInteraction
+	for rows.Next() {
+		var m MessageInteraction
+		if err := rows.Scan(&m.Timestamp, &m.Sender, &m.ChatName, &m.Content, &m.IsFromMe, &m.ChatJID, &m.ID, &m.MediaType); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// resolveMentions turns phone numbers and JIDs into the JIDs to mention in a
+// chat. Groups that address members by LID get LIDs where they are known.
+func resolveMentions(client *whatsmeow.Client, messageStore *MessageStore, chat types.JID, mentions []string) ([]types.JID, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	useLID := false
+	if chat.Server == types.GroupServer {
+		if info, err := client.GetGroupInfo(context.Background(), chat); err == nil {
+			useLID = info.AddressingMode == types.AddressingModeLID
+		}
+	}
+
+	var jids []types.JID
+	seen := make(map[types.JID]bool)
+	for _, mention := range mentions {
+		jid, err := mentionJID(client, mention)
+		if err != nil {
+			return nil, err
+		}
+
+		jid = canonicalJID(client, messageStore, jid)
+		if useLID && jid.Server == types.DefaultUserServer {
+			if lid, ok := messageStore.lidForPN(jid); ok {
+				jid = lid
+			} else if lid, err := client.Store.LIDs.GetLIDForPN(context.Background(), jid); err == nil && !lid.IsEmpty() {
+				jid = lid
+			}
+		}
+		if !seen[jid] {
+			seen[jid] = true
+			jids = append(jids, jid)
+		}
+	}
+	return jids, nil
+}
+
+// mentionJID resolves a mention to the user it names
+func mentionJID(client *whatsmeow.Client, mention string) (types.JID, error) {
+	jid, err := resolveRecipient(client, mention)
+	if err != nil {
+		return jid, fmt.Errorf("cannot mention %s: %v", mention, err)
+	}
+	if jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer {
+		return jid, fmt.Errorf("cannot mention %s: not a user", mention)
+	}
+	return jid, nil
+}
+
+// withMentionTokens puts an @number token in front of the text for every
+// mentioned user it doesn't already mention, as WhatsApp only highlights
+// mentions that appear in the text
+func withMentionTokens(text string, mentioned []types.JID) string {
+	var tokens []string
+	for _, jid := range mentioned {
+		token := "@" + jid.User
+		if !strings.Contains(text, token) {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return text
+	}
+	if text == "" {
+		return strings.Join(tokens, " ")
+	}
+	return strings.Join(tokens, " ") + " " + text
+}
+
+// mentionContextInfo is the context info that marks the mentioned users
+func mentionContextInfo(mentioned []types.JID) *waE2E.ContextInfo {
+	jids := make([]string, len(mentioned))
+	for i, jid := range mentioned {
+		jids[i] = jid.String()
+	}
+	return &waE2E.ContextInfo{MentionedJID: jids}
+}
+
+// registerMentionRoutes serves GET /api/mentions?days=&limit=&jid=
+func registerMentionRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/mentions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		days, limit := 7, 50
+		if v, err := strconv.Atoi(q.Get("days")); err == nil && v > 0 {
+			days = v
+		}
+		if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+			limit = v
+		}
+
+		// Without a JID, look for mentions of this account under both its forms
+		var mentioned []string
+		if jid := q.Get("jid"); jid != "" {
+			mentioned, _ = messageStore.contactIdentities(jid)
+		} else {
+			if client.Store.ID == nil {
+				respondError(w, http.StatusServiceUnavailable, "Not logged in to WhatsApp")
+				return
+			}
+			mentioned = append(mentioned, senderKey(*client.Store.ID))
+			if !client.Store.LID.IsEmpty() {
+				mentioned = append(mentioned, senderKey(client.Store.LID))
+			}
+		}
+
+		msgs, err := messageStore.GetMentions(mentioned, time.Now().AddDate(0, 0, -days), limit, requestAccess(r))
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"result": messageStore.FormatMessagesList(msgs, true),
+		})
+	})
+}
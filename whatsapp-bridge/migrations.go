@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// dataMigration rewrites existing rows once, after the schema is up to date
+type dataMigration struct {
+	name string
+	run  func(tx *sql.Tx) error
+}
+
+// dataMigrations run in order; each is recorded in schema_migrations so it
+// only ever runs once per database
+var dataMigrations = []dataMigration{
+	{"full_sender_jids", migrateFullSenderJIDs},
+}
+
+// runDataMigrations applies the data migrations this database hasn't seen yet
+func runDataMigrations(db *sql.DB) error {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP
+		)`); err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+
+	for _, m := range dataMigrations {
+		var applied string
+		err := db.QueryRow(rebind("SELECT name FROM schema_migrations WHERE name = ?"), m.name).Scan(&applied)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("failed to read migrations: %v", err)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.run(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %v", m.name, err)
+		}
+		if _, err := tx.Exec(rebind("INSERT INTO schema_migrations (name, applied_at) VALUES (?, ?)"), m.name, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %v", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		fmt.Printf("Applied migration %s\n", m.name)
+	}
+	return nil
+}
+
+// migrateFullSenderJIDs rewrites messages.sender from the old mix of bare user
+// parts and full JIDs (sometimes with a device) to canonical full JIDs
+func migrateFullSenderJIDs(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT DISTINCT sender FROM messages WHERE sender IS NOT NULL AND sender <> ''")
+	if err != nil {
+		return err
+	}
+	var senders []string
+	for rows.Next() {
+		var sender string
+		if err := rows.Scan(&sender); err != nil {
+			rows.Close()
+			return err
+		}
+		senders = append(senders, sender)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, old := range senders {
+		jid, err := legacySenderJID(tx, old)
+		if err != nil {
+			fmt.Printf("Leaving unparseable sender %q as is: %v\n", old, err)
+			continue
+		}
+		if jid.String() == old {
+			continue
+		}
+		if _, err := tx.Exec(rebind("UPDATE messages SET sender = ? WHERE sender = ?"), jid.String(), old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacySenderJID works out the canonical JID of an old sender value. Bare user
+// parts were phone numbers, unless they are known as a LID from a group.
+func legacySenderJID(tx *sql.Tx, sender string) (types.JID, error) {
+	var jid types.JID
+	if strings.Contains(sender, "@") {
+		parsed, err := types.ParseJID(sender)
+		if err != nil {
+			return jid, err
+		}
+		switch parsed.Server {
+		case types.DefaultUserServer, types.HiddenUserServer, types.GroupServer:
+		default:
+			return jid, fmt.Errorf("unexpected server %q", parsed.Server)
+		}
+		jid = parsed.ToNonAD()
+	} else {
+		user := strings.TrimPrefix(sender, "+")
+		if i := strings.Index(user, ":"); i >= 0 {
+			user = user[:i]
+		}
+		jid = types.NewJID(user, types.DefaultUserServer)
+
+		lid := types.NewJID(user, types.HiddenUserServer).String()
+		var found int
+		err := tx.QueryRow(rebind(`
+			SELECT 1 FROM lid_mappings WHERE lid = ?
+			UNION SELECT 1 FROM group_participants WHERE participant_jid = ? OR lid = ?`),
+			lid, lid, lid,
+		).Scan(&found)
+		if err == nil {
+			jid = types.NewJID(user, types.HiddenUserServer)
+		}
+	}
+
+	if jid.Server == types.HiddenUserServer {
+		var pn string
+		err := tx.QueryRow(rebind("SELECT pn FROM lid_mappings WHERE lid = ?"), jid.String()).Scan(&pn)
+		if err == nil {
+			if parsed, err := types.ParseJID(pn); err == nil {
+				return parsed.ToNonAD(), nil
+			}
+		}
+	}
+	return jid, nil
+}
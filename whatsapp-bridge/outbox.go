@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sends go through a durable outbox: the request is stored and answered right
+// away, and a worker delivers it, retrying with backoff while disconnected or on
+// errors. The outbox ID is also used as the WhatsApp message ID, so a retry after
+// an unconfirmed send doesn't show up twice.
+
+const (
+	outboxQueued  = "queued"
+	outboxSending = "sending"
+	outboxSent    = "sent"
+	outboxFailed  = "failed"
+
+	// outboxKindMessage is a SendMessageRequest, built and uploaded on delivery
+	outboxKindMessage = "message"
+	// outboxKindPrepared is a fully built waE2E.Message
+	outboxKindPrepared = "prepared"
+
+	outboxMaxAttempts  = 10
+	outboxBaseBackoff  = 2 * time.Second
+	outboxMaxBackoff   = 5 * time.Minute
+	outboxPollInterval = 5 * time.Second
+
+	// maxSendWait keeps a waiting send under the MCP server's request timeout
+	maxSendWait = 20 * time.Second
+)
+
+// outboxMediaDir holds copies of files queued for sending
+const outboxMediaDir = "store/outbox"
+
+// OutboxItem is a queued send and how far it got
+type OutboxItem struct {
+	ID            string    `json:"id"`
+	Kind          string    `json:"kind"`
+	Recipient     string    `json:"recipient"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	MessageID     string    `json:"message_id,omitempty"`
+	ChatJID       string    `json:"chat_jid,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+
+	payload []byte
+}
+
+// permanentSendError is a send error retrying won't fix
+type permanentSendError struct{ error }
+
+func (e permanentSendError) Unwrap() error { return e.error }
+
+func permanentError(err error) error {
+	return permanentSendError{err}
+}
+
+// EnqueueOutbox stores a send for the worker
+func (store *MessageStore) EnqueueOutbox(id, kind, recipient string, payload []byte) (*OutboxItem, error) {
+	now := time.Now()
+	item := &OutboxItem{
+		ID:            id,
+		Kind:          kind,
+		Recipient:     recipient,
+		Status:        outboxQueued,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		NextAttemptAt: now,
+		payload:       payload,
+	}
+	_, err := store.db.Exec(rebind(`
+		INSERT INTO outbox (id, kind, recipient, payload, status, attempts, created_at, updated_at, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?)`),
+		item.ID, item.Kind, item.Recipient, item.payload, item.Status, now, now, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue message: %v", err)
+	}
+	return item, nil
+}
+
+const outboxColumns = `id, kind, recipient, payload, status, attempts, COALESCE(last_error, ''),
+	COALESCE(message_id, ''), COALESCE(chat_jid, ''), created_at, updated_at, next_attempt_at`
+
+func scanOutboxItem(row interface{ Scan(...any) error }) (*OutboxItem, error) {
+	var item OutboxItem
+	err := row.Scan(&item.ID, &item.Kind, &item.Recipient, &item.payload, &item.Status, &item.Attempts,
+		&item.LastError, &item.MessageID, &item.ChatJID, &item.CreatedAt, &item.UpdatedAt, &item.NextAttemptAt)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// GetOutboxItem returns a queued send by ID
+func (store *MessageStore) GetOutboxItem(id string) (*OutboxItem, error) {
+	return scanOutboxItem(store.db.QueryRow(rebind("SELECT "+outboxColumns+" FROM outbox WHERE id = ?"), id))
+}
+
+// dueOutboxItems returns queued sends whose next attempt is due, oldest first
+func (store *MessageStore) dueOutboxItems(now time.Time, limit int) ([]*OutboxItem, error) {
+	rows, err := store.db.Query(rebind(`
+		SELECT `+outboxColumns+` FROM outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY created_at
+		LIMIT ?`),
+		outboxQueued, now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*OutboxItem
+	for rows.Next() {
+		item, err := scanOutboxItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// updateOutboxItem writes the delivery state of a queued send
+func (store *MessageStore) updateOutboxItem(item *OutboxItem) error {
+	item.UpdatedAt = time.Now()
+	_, err := store.db.Exec(rebind(`
+		UPDATE outbox SET status = ?, attempts = ?, last_error = ?, message_id = ?, chat_jid = ?,
+			updated_at = ?, next_attempt_at = ?
+		WHERE id = ?`),
+		item.Status, item.Attempts, item.LastError, item.MessageID, item.ChatJID,
+		item.UpdatedAt, item.NextAttemptAt, item.ID,
+	)
+	return err
+}
+
+// cancelOutboxItem fails a send the worker hasn't taken yet. It returns false
+// when the send was already underway or finished.
+func (store *MessageStore) cancelOutboxItem(id, reason string) (bool, error) {
+	res, err := store.db.Exec(rebind(`
+		UPDATE outbox SET status = ?, last_error = ?, updated_at = ?
+		WHERE id = ? AND status = ?`),
+		outboxFailed, reason, time.Now(), id, outboxQueued,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	removeOutboxMedia(id)
+	outbox.finish(id)
+	return true, nil
+}
+
+// outboxCounts returns the number of queued sends by status
+func (store *MessageStore) outboxCounts() (map[string]int, error) {
+	rows, err := store.db.Query("SELECT status, COUNT(*) FROM outbox GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{outboxQueued: 0, outboxSending: 0, outboxSent: 0, outboxFailed: 0}
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// requeueInterruptedOutbox puts sends the bridge stopped in the middle of back
+// in the queue. They may have gone out already, but the reused message ID makes
+// WhatsApp drop the duplicate.
+func (store *MessageStore) requeueInterruptedOutbox() error {
+	_, err := store.db.Exec(rebind("UPDATE outbox SET status = ? WHERE status = ?"), outboxQueued, outboxSending)
+	return err
+}
+
+// outboxBackoff is the wait before the next attempt: 2s, 4s, 8s, ... up to 5 minutes
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
+
+// outboxWorker delivers queued sends and tells waiting requests when they finish
+type outboxWorker struct {
+	wake chan struct{}
+
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+var outbox = &outboxWorker{
+	wake:    make(chan struct{}, 1),
+	waiters: make(map[string][]chan struct{}),
+}
+
+// notify makes the worker look for due sends now
+func (o *outboxWorker) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers due sends until the process exits
+func (o *outboxWorker) run(client *whatsmeow.Client, messageStore *MessageStore) {
+	if err := messageStore.requeueInterruptedOutbox(); err != nil {
+		fmt.Printf("Failed to requeue interrupted sends: %v\n", err)
+	}
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		o.deliverDue(client, messageStore)
+		select {
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *outboxWorker) deliverDue(client *whatsmeow.Client, messageStore *MessageStore) {
+	// Connecting again wakes the worker, so nothing is lost by waiting
+	if !client.IsConnected() {
+		return
+	}
+	items, err := messageStore.dueOutboxItems(time.Now(), 20)
+	if err != nil {
+		fmt.Printf("Failed to read outbox: %v\n", err)
+		return
+	}
+	for _, item := range items {
+		if !client.IsConnected() {
+			return
+		}
+		o.deliver(client, messageStore, item)
+	}
+}
+
+// deliver makes one attempt at a queued send
+func (o *outboxWorker) deliver(client *whatsmeow.Client, messageStore *MessageStore, item *OutboxItem) {
+	item.Status = outboxSending
+	item.Attempts++
+	if err := messageStore.updateOutboxItem(item); err != nil {
+		fmt.Printf("Failed to update outbox item %s: %v\n", item.ID, err)
+		return
+	}
+
+	sendLimits.pace()
+	started := time.Now()
+	jid, resp, msg, err := sendOutboxItem(client, messageStore, item)
+	if err == nil {
+		item.Status = outboxSent
+		item.LastError = ""
+		item.MessageID = resp.ID
+		item.ChatJID = jid.String()
+		storeSentMessage(client, messageStore, jid, resp, msg)
+	} else {
+		item.LastError = err.Error()
+		var permanent permanentSendError
+		if errors.As(err, &permanent) || item.Attempts >= outboxMaxAttempts {
+			item.Status = outboxFailed
+		} else {
+			item.Status = outboxQueued
+			item.NextAttemptAt = time.Now().Add(outboxBackoff(item.Attempts))
+		}
+		fmt.Printf("Send %s to %s failed (attempt %d, %s): %v\n", item.ID, item.Recipient, item.Attempts, item.Status, err)
+	}
+
+	if err := messageStore.updateOutboxItem(item); err != nil {
+		fmt.Printf("Failed to update outbox item %s: %v\n", item.ID, err)
+	}
+	auditDelivery(messageStore, item, started)
+	if item.Status == outboxSent || item.Status == outboxFailed {
+		removeOutboxMedia(item.ID)
+		o.finish(item.ID)
+	}
+}
+
+// sendOutboxItem builds and sends a queued message
+func sendOutboxItem(client *whatsmeow.Client, messageStore *MessageStore, item *OutboxItem) (types.JID, whatsmeow.SendResponse, *waE2E.Message, error) {
+	var none whatsmeow.SendResponse
+
+	recipientJID, err := resolveRecipient(client, item.Recipient)
+	if err != nil {
+		return types.JID{}, none, nil, permanentError(err)
+	}
+
+	var msg *waE2E.Message
+	switch item.Kind {
+	case outboxKindMessage:
+		var req SendMessageRequest
+		if err := json.Unmarshal(item.payload, &req); err != nil {
+			return types.JID{}, none, nil, permanentError(fmt.Errorf("invalid queued message: %v", err))
+		}
+		msg, err = buildWhatsAppMessage(client, messageStore, recipientJID, req.Message, req.MediaPath, req.Mentions)
+		if err != nil {
+			return types.JID{}, none, nil, err
+		}
+	case outboxKindPrepared:
+		msg = &waE2E.Message{}
+		if err := proto.Unmarshal(item.payload, msg); err != nil {
+			return types.JID{}, none, nil, permanentError(fmt.Errorf("invalid queued message: %v", err))
+		}
+	default:
+		return types.JID{}, none, nil, permanentError(fmt.Errorf("unknown outbox kind %q", item.Kind))
+	}
+
+	resp, err := client.SendMessage(context.Background(), recipientJID, msg, whatsmeow.SendRequestExtra{ID: types.MessageID(item.ID)})
+	if err != nil {
+		return types.JID{}, none, nil, fmt.Errorf("Error sending message: %v", err)
+	}
+	return recipientJID, resp, msg, nil
+}
+
+// finish wakes the requests waiting for a send
+func (o *outboxWorker) finish(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ch := range o.waiters[id] {
+		close(ch)
+	}
+	delete(o.waiters, id)
+}
+
+// wait returns a send once it was delivered or failed, or as it is after timeout
+func (o *outboxWorker) wait(messageStore *MessageStore, id string, timeout time.Duration) (*OutboxItem, error) {
+	ch := make(chan struct{})
+	o.mu.Lock()
+	o.waiters[id] = append(o.waiters[id], ch)
+	o.mu.Unlock()
+	defer o.stopWaiting(id, ch)
+
+	// The send may have finished before the waiter was registered
+	item, err := messageStore.GetOutboxItem(id)
+	if err != nil || item.Status == outboxSent || item.Status == outboxFailed {
+		return item, err
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+	}
+	return messageStore.GetOutboxItem(id)
+}
+
+// stopWaiting removes a waiter that finish didn't already remove
+func (o *outboxWorker) stopWaiting(id string, ch chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	waiters := o.waiters[id]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(o.waiters, id)
+	} else {
+		o.waiters[id] = waiters
+	}
+}
+
+// copyOutboxMedia copies a file to send into the outbox, keeping its name
+func copyOutboxMedia(id, mediaPath string) (string, error) {
+	return copyMediaFile(filepath.Join(outboxMediaDir, id), mediaPath)
+}
+
+// copyMediaFile copies a file to send into dir, keeping its name
+func copyMediaFile(dir, mediaPath string) (string, error) {
+	src, err := os.Open(mediaPath)
+	if err != nil {
+		return "", fmt.Errorf("Error reading media file: %v", err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory for media: %v", err)
+	}
+	path := filepath.Join(dir, filepath.Base(mediaPath))
+	dst, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy media file: %v", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", fmt.Errorf("failed to copy media file: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		return "", fmt.Errorf("failed to copy media file: %v", err)
+	}
+	return path, nil
+}
+
+func removeOutboxMedia(id string) {
+	if err := os.RemoveAll(filepath.Join(outboxMediaDir, id)); err != nil {
+		fmt.Printf("Failed to remove outbox media of %s: %v\n", id, err)
+	}
+}
+
+// sendWait reads ?wait=true (up to 20s) or ?wait=<seconds> from a send request
+func sendWait(r *http.Request) time.Duration {
+	value := r.URL.Query().Get("wait")
+	if value == "" || value == "false" {
+		return 0
+	}
+	wait := maxSendWait
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxSendWait {
+		return maxSendWait
+	}
+	return wait
+}
+
+// recipientError is a send refused for its recipient before it was queued
+type recipientError struct{ error }
+
+func (e recipientError) Unwrap() error { return e.error }
+
+// queueSend checks a send's recipient and the rate limits and stores it for the
+// worker. Refused sends return a recipientError or a *rateLimitError, so the
+// worker only retries what may still go out.
+func queueSend(client *whatsmeow.Client, messageStore *MessageStore, id, kind, recipient string, payload []byte) (*OutboxItem, error) {
+	// Queue the JID, so a phone number is checked once and not on every attempt
+	recipientJID, err := resolveRecipient(client, recipient)
+	if err != nil {
+		removeOutboxMedia(id)
+		return nil, recipientError{err}
+	}
+	recipient = recipientJID.String()
+
+	if err := sendLimits.allow(messageStore, recipient, time.Now()); err != nil {
+		removeOutboxMedia(id)
+		return nil, err
+	}
+
+	item, err := messageStore.EnqueueOutbox(id, kind, recipient, payload)
+	if err != nil {
+		removeOutboxMedia(id)
+		return nil, err
+	}
+	outbox.notify()
+	return item, nil
+}
+
+// enqueueSend queues a send, optionally waits for it, and writes the response.
+// Invalid and unregistered recipients are refused with 400 and sends over the
+// rate limits with 429.
+func enqueueSend(w http.ResponseWriter, r *http.Request, client *whatsmeow.Client, messageStore *MessageStore, id, kind, recipient string, payload []byte) {
+	item, err := queueSend(client, messageStore, id, kind, recipient, payload)
+	var (
+		invalid recipientError
+		limited *rateLimitError
+	)
+	switch {
+	case errors.As(err, &invalid):
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.As(err, &limited):
+		respondRateLimited(w, limited)
+		return
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if wait := sendWait(r); wait > 0 {
+		if waited, err := outbox.wait(messageStore, id, wait); err == nil {
+			item = waited
+		}
+	}
+	respondOutbox(w, item)
+}
+
+// enqueuePrepared queues a built message
+func enqueuePrepared(w http.ResponseWriter, r *http.Request, client *whatsmeow.Client, messageStore *MessageStore, recipient string, msg *waE2E.Message) {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	enqueueSend(w, r, client, messageStore, string(client.GenerateMessageID()), outboxKindPrepared, recipient, payload)
+}
+
+// respondOutbox answers a send with the state of its outbox item
+func respondOutbox(w http.ResponseWriter, item *OutboxItem) {
+	resp := SendMessageResponse{
+		Success:   item.Status != outboxFailed,
+		ID:        item.ID,
+		Status:    item.Status,
+		MessageID: item.MessageID,
+		ChatJID:   item.ChatJID,
+	}
+	status := http.StatusOK
+	switch item.Status {
+	case outboxSent:
+		resp.Message = fmt.Sprintf("Message sent to %s", item.Recipient)
+	case outboxFailed:
+		resp.Message = fmt.Sprintf("Failed to send message to %s: %s", item.Recipient, item.LastError)
+		status = http.StatusInternalServerError
+	default:
+		resp.Message = fmt.Sprintf("Message to %s queued as %s", item.Recipient, item.ID)
+		if item.LastError != "" {
+			resp.Message += fmt.Sprintf(" (retrying after: %s)", item.LastError)
+		}
+	}
+	respondJSON(w, status, resp)
+}
+
+// registerOutboxRoutes serves GET /api/outbox/{id}
+func registerOutboxRoutes(messageStore *MessageStore) {
+	http.HandleFunc("/api/outbox/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/outbox/")
+		if id == "" {
+			http.Error(w, "Missing outbox ID", http.StatusBadRequest)
+			return
+		}
+
+		item, err := messageStore.GetOutboxItem(id)
+		if err == sql.ErrNoRows || (err == nil && !messageStore.contactVisible(requestAccess(r), item.Recipient)) {
+			http.Error(w, "Outbox item not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"outbox": item})
+	})
+}
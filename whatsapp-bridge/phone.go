@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	// maxNumberChecks caps how many numbers one check request may contain
+	maxNumberChecks = 100
+	// numberCheckTTL is how long a registration check result is reused
+	numberCheckTTL = 24 * time.Hour
+	// numberCheckMissTTL is how long a number found unregistered stays so, as
+	// it may be registered any moment
+	numberCheckMissTTL = 10 * time.Minute
+)
+
+// NumberCheckResult is the registration status of one phone number
+type NumberCheckResult struct {
+	Input        string `json:"input"`
+	PhoneNumber  string `json:"phone_number,omitempty"` // E.164, e.g. +4915112345678
+	JID          string `json:"jid,omitempty"`
+	IsOnWhatsApp bool   `json:"is_on_whatsapp"`
+	BusinessName string `json:"business_name,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// CheckNumbersRequest is the body of POST /api/contacts/check
+type CheckNumbersRequest struct {
+	PhoneNumbers []string `json:"phone_numbers"`
+}
+
+type numberCheck struct {
+	result  NumberCheckResult
+	checked time.Time
+}
+
+// fresh reports whether the check can still be reused at now
+func (c numberCheck) fresh(now time.Time) bool {
+	ttl := numberCheckTTL
+	if !c.result.IsOnWhatsApp {
+		ttl = numberCheckMissTTL
+	}
+	return now.Sub(c.checked) < ttl
+}
+
+// numberChecks caches registration checks so sending to the same number
+// doesn't query WhatsApp every time
+var numberChecks = struct {
+	sync.Mutex
+	byPhone map[string]numberCheck
+}{byPhone: make(map[string]numberCheck)}
+
+// normalizePhoneNumber turns a phone number as people write it, e.g.
+// "+49 (151) 123-456 78" or "0049151...", into E.164 digits without the +.
+// Numbers must include the country code.
+func normalizePhoneNumber(input string) (string, error) {
+	s := strings.TrimSpace(input)
+	international := false
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s, international = s[1:], true
+	case strings.HasPrefix(s, "00"):
+		s, international = s[2:], true
+	}
+
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/' || r == '\u00a0':
+			// formatting
+		default:
+			return "", fmt.Errorf("invalid character %q in phone number %q", r, input)
+		}
+	}
+
+	phone := digits.String()
+	if strings.HasPrefix(phone, "0") {
+		if international {
+			return "", fmt.Errorf("invalid phone number %q: country codes don't start with 0", input)
+		}
+		return "", fmt.Errorf("phone number %q has no country code; use the international format, e.g. +49151...", input)
+	}
+	if len(phone) < 7 || len(phone) > 15 {
+		return "", fmt.Errorf("invalid phone number %q: expected 7 to 15 digits including the country code", input)
+	}
+	return phone, nil
+}
+
+// checkPhoneNumbers looks up whether numbers are registered on WhatsApp. Invalid
+// numbers get an error in their result instead of failing the whole batch. With
+// refresh, cached results are checked again.
+func checkPhoneNumbers(client *whatsmeow.Client, inputs []string, refresh bool) ([]NumberCheckResult, error) {
+	results := make([]NumberCheckResult, len(inputs))
+	pending := make(map[string][]int)
+	var query []string
+
+	numberChecks.Lock()
+	for i, input := range inputs {
+		results[i].Input = input
+		phone, err := normalizePhoneNumber(input)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if cached, ok := numberChecks.byPhone[phone]; ok && !refresh && cached.fresh(time.Now()) {
+			results[i] = cached.result
+			results[i].Input = input
+			continue
+		}
+		if _, ok := pending[phone]; !ok {
+			query = append(query, "+"+phone)
+		}
+		pending[phone] = append(pending[phone], i)
+	}
+	numberChecks.Unlock()
+
+	if len(query) == 0 {
+		return results, nil
+	}
+
+	responses, err := client.IsOnWhatsApp(context.Background(), query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check numbers: %v", err)
+	}
+
+	numberChecks.Lock()
+	defer numberChecks.Unlock()
+	for _, resp := range responses {
+		phone := strings.TrimPrefix(strings.TrimSuffix(resp.Query, "@"+types.LegacyUserServer), "+")
+		result := NumberCheckResult{
+			PhoneNumber:  "+" + phone,
+			IsOnWhatsApp: resp.IsIn,
+		}
+		if resp.IsIn {
+			result.JID = resp.JID.String()
+		}
+		if resp.VerifiedName != nil && resp.VerifiedName.Details != nil {
+			result.BusinessName = resp.VerifiedName.Details.GetVerifiedName()
+		}
+		numberChecks.byPhone[phone] = numberCheck{result: result, checked: time.Now()}
+
+		for _, i := range pending[phone] {
+			input := results[i].Input
+			results[i] = result
+			results[i].Input = input
+		}
+		delete(pending, phone)
+	}
+	// Numbers WhatsApp didn't answer for
+	for phone, indexes := range pending {
+		for _, i := range indexes {
+			results[i].PhoneNumber = "+" + phone
+			results[i].Error = "no answer from WhatsApp for this number"
+		}
+	}
+	return results, nil
+}
+
+// resolveRecipient turns a recipient given as a JID or phone number into the JID
+// to send to. Phone numbers are normalized and must be registered on WhatsApp.
+func resolveRecipient(client *whatsmeow.Client, recipient string) (types.JID, error) {
+	if strings.Contains(recipient, "@") {
+		jid, err := types.ParseJID(recipient)
+		if err != nil {
+			return jid, fmt.Errorf("invalid JID %q: %v", recipient, err)
+		}
+		return jid, nil
+	}
+
+	phone, err := normalizePhoneNumber(recipient)
+	if err != nil {
+		return types.JID{}, err
+	}
+
+	results, err := checkPhoneNumbers(client, []string{phone}, false)
+	if err != nil {
+		// Don't block sending when only the check failed
+		fmt.Printf("Could not verify %s is on WhatsApp: %v\n", phone, err)
+		return types.NewJID(phone, types.DefaultUserServer), nil
+	}
+	result := results[0]
+	if result.Error != "" {
+		return types.JID{}, fmt.Errorf("%s", result.Error)
+	}
+	if !result.IsOnWhatsApp {
+		return types.JID{}, fmt.Errorf("+%s is not registered on WhatsApp", phone)
+	}
+	return types.ParseJID(result.JID)
+}
+
+// registerNumberCheckRoutes adds POST /api/contacts/check, which takes
+// ?refresh=true to skip the cache
+func registerNumberCheckRoutes(client *whatsmeow.Client) {
+	http.HandleFunc("/api/contacts/check", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req CheckNumbersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if len(req.PhoneNumbers) == 0 {
+			http.Error(w, "phone_numbers is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.PhoneNumbers) > maxNumberChecks {
+			http.Error(w, fmt.Sprintf("At most %d numbers can be checked at once", maxNumberChecks), http.StatusBadRequest)
+			return
+		}
+		if !client.IsConnected() {
+			respondError(w, http.StatusServiceUnavailable, "Not connected to WhatsApp")
+			return
+		}
+
+		results, err := checkPhoneNumbers(client, req.PhoneNumbers, r.URL.Query().Get("refresh") == "true")
+		if err != nil {
+			respondError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"results": results,
+		})
+	})
+}
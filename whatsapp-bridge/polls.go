@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Polls are stored as messages of type poll with their options in the polls
+// table. Votes are encrypted with a secret from the poll message; whatsmeow keeps
+// the secrets, so votes can be decrypted as they arrive. Each vote replaces the
+// voter's previous one, and selected options are stored as hashes so votes
+// arriving before their poll can still be counted later.
+
+const mediaTypePoll = "poll"
+
+// maxPollOptions is the most options WhatsApp allows in a poll
+const maxPollOptions = 12
+
+// SendPollRequest is the body of POST /api/send/poll
+type SendPollRequest struct {
+	Recipient       string   `json:"recipient"`
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	MultipleAnswers bool     `json:"multiple_answers,omitempty"`
+}
+
+// PollResults is a poll with the current votes per option
+type PollResults struct {
+	ID              string            `json:"id"`
+	ChatJID         string            `json:"chat_jid"`
+	Question        string            `json:"question"`
+	Creator         string            `json:"creator,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	MultipleAnswers bool              `json:"multiple_answers"`
+	Options         []PollOptionTally `json:"options"`
+	TotalVoters     int               `json:"total_voters"`
+}
+
+// PollOptionTally is one option of a poll and who chose it
+type PollOptionTally struct {
+	Name   string      `json:"name"`
+	Votes  int         `json:"votes"`
+	Voters []PollVoter `json:"voters"`
+}
+
+// PollVoter is someone who chose an option
+type PollVoter struct {
+	JID     string    `json:"jid"`
+	Name    string    `json:"name"`
+	VotedAt time.Time `json:"voted_at"`
+}
+
+// extractPoll returns the poll a message creates, in any of its versions
+func extractPoll(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	for _, poll := range []*waE2E.PollCreationMessage{
+		msg.GetPollCreationMessage(),
+		msg.GetPollCreationMessageV2(),
+		msg.GetPollCreationMessageV3(),
+		msg.GetPollCreationMessageV5(),
+	} {
+		if poll != nil {
+			return poll
+		}
+	}
+	return nil
+}
+
+// pollMediaInfo stores a poll like media, with the question and options as text
+func pollMediaInfo(poll *waE2E.PollCreationMessage) *MediaInfo {
+	var options []string
+	for _, option := range poll.GetOptions() {
+		options = append(options, option.GetOptionName())
+	}
+	return &MediaInfo{
+		Type:    mediaTypePoll,
+		Caption: fmt.Sprintf("%s (options: %s)", poll.GetName(), strings.Join(options, " / ")),
+	}
+}
+
+// storePollCreation stores the poll a stored message creates, if it is one
+func storePollCreation(messageStore *MessageStore, messageID, chatJID string, msg *waE2E.Message) {
+	if poll := extractPoll(msg); poll != nil {
+		if err := messageStore.StorePoll(messageID, chatJID, poll); err != nil {
+			fmt.Printf("Failed to store poll %s: %v\n", messageID, err)
+		}
+	}
+}
+
+// StorePoll records the question and options of a poll message
+func (store *MessageStore) StorePoll(messageID, chatJID string, poll *waE2E.PollCreationMessage) error {
+	var options []string
+	for _, option := range poll.GetOptions() {
+		options = append(options, option.GetOptionName())
+	}
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(rebind(`
+		INSERT INTO polls (message_id, chat_jid, question, options, selectable_count)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET
+			question = EXCLUDED.question,
+			options = EXCLUDED.options,
+			selectable_count = EXCLUDED.selectable_count`),
+		messageID, chatJID, poll.GetName(), string(encoded), poll.GetSelectableOptionsCount(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store poll: %v", err)
+	}
+	return nil
+}
+
+// StorePollVote records a voter's current choice. An older vote than the one
+// stored, as history syncs may deliver, is ignored.
+func (store *MessageStore) StorePollVote(pollID, chatJID, voterJID string, selected [][]byte, timestamp time.Time) error {
+	hashes := make([]string, 0, len(selected))
+	for _, hash := range selected {
+		hashes = append(hashes, hex.EncodeToString(hash))
+	}
+	encoded, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(rebind(`
+		INSERT INTO poll_votes (poll_id, chat_jid, voter_jid, selected_hashes, timestamp)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (poll_id, chat_jid, voter_jid) DO UPDATE SET
+			selected_hashes = EXCLUDED.selected_hashes,
+			timestamp = EXCLUDED.timestamp
+		WHERE EXCLUDED.timestamp >= poll_votes.timestamp`),
+		pollID, chatJID, voterJID, string(encoded), timestamp,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store poll vote: %v", err)
+	}
+	return nil
+}
+
+// GetPollResults tallies the votes of a poll. chatJID may be empty when the
+// message ID is unique.
+func (store *MessageStore) GetPollResults(messageID, chatJID string) (*PollResults, error) {
+	query := `
+		SELECT p.message_id, p.chat_jid, p.question, p.options, p.selectable_count,
+			COALESCE(m.sender, ''), m.timestamp, COALESCE(m.is_from_me, false)
+		FROM polls p
+		LEFT JOIN messages m ON m.id = p.message_id AND m.chat_jid = p.chat_jid
+		WHERE p.message_id = ?`
+	args := []interface{}{messageID}
+	if chatJID != "" {
+		query += " AND p.chat_jid = ?"
+		args = append(args, chatJID)
+	}
+
+	var (
+		r          PollResults
+		options    string
+		selectable int
+		createdAt  sql.NullTime
+		isFromMe   bool
+	)
+	err := store.db.QueryRow(rebind(query+" LIMIT 1"), args...).Scan(
+		&r.ID, &r.ChatJID, &r.Question, &options, &selectable, &r.Creator, &createdAt, &isFromMe,
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.CreatedAt = createdAt.Time
+	r.MultipleAnswers = selectable != 1
+	if isFromMe {
+		r.Creator = "me"
+	}
+
+	var names []string
+	if err := json.Unmarshal([]byte(options), &names); err != nil {
+		return nil, fmt.Errorf("failed to parse poll options: %v", err)
+	}
+	byHash := make(map[string]int, len(names))
+	r.Options = make([]PollOptionTally, len(names))
+	for i, name := range names {
+		sum := sha256.Sum256([]byte(name))
+		byHash[hex.EncodeToString(sum[:])] = i
+		r.Options[i] = PollOptionTally{Name: name, Voters: []PollVoter{}}
+	}
+
+	rows, err := store.db.Query(rebind(`
+		SELECT voter_jid, selected_hashes, timestamp FROM poll_votes
+		WHERE poll_id = ? AND chat_jid = ?
+		ORDER BY timestamp`),
+		r.ID, r.ChatJID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read poll votes: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			voter, selected string
+			votedAt         time.Time
+		)
+		if err := rows.Scan(&voter, &selected, &votedAt); err != nil {
+			return nil, err
+		}
+		var hashes []string
+		if err := json.Unmarshal([]byte(selected), &hashes); err != nil {
+			continue
+		}
+		// Taking back a vote leaves an empty selection
+		if len(hashes) == 0 {
+			continue
+		}
+		r.TotalVoters++
+		for _, hash := range hashes {
+			i, ok := byHash[hash]
+			if !ok {
+				continue
+			}
+			r.Options[i].Votes++
+			r.Options[i].Voters = append(r.Options[i].Voters, PollVoter{
+				JID:     voter,
+				Name:    store.GetSenderName(voter),
+				VotedAt: votedAt,
+			})
+		}
+	}
+	return &r, rows.Err()
+}
+
+// handlePollVote decrypts and stores a vote from a live poll update message
+func handlePollVote(client *whatsmeow.Client, messageStore *MessageStore, msg *events.Message) {
+	update := msg.Message.GetPollUpdateMessage()
+	vote, err := client.DecryptPollVote(context.Background(), msg)
+	if err != nil {
+		fmt.Printf("Failed to decrypt poll vote %s: %v\n", msg.Info.ID, err)
+		return
+	}
+
+	voter := senderKey(canonicalJID(client, messageStore, msg.Info.Sender))
+	err = messageStore.StorePollVote(
+		update.GetPollCreationMessageKey().GetID(),
+		msg.Info.Chat.String(),
+		voter,
+		vote.GetSelectedOptions(),
+		msg.Info.Timestamp,
+	)
+	if err != nil {
+		fmt.Printf("Failed to store poll vote: %v\n", err)
+	}
+}
+
+// storeHistoryPollVotes stores the already decrypted votes a history sync
+// attaches to a poll message
+func storeHistoryPollVotes(client *whatsmeow.Client, messageStore *MessageStore, chat types.JID, pollID string, msg *waWeb.WebMessageInfo) {
+	for _, update := range msg.GetPollUpdates() {
+		key := update.GetPollUpdateMessageKey()
+		voterJID := chat
+		if key.GetFromMe() {
+			voterJID = *client.Store.ID
+		} else if participant, err := types.ParseJID(key.GetParticipant()); err == nil && key.GetParticipant() != "" {
+			voterJID = participant
+		}
+		voter := senderKey(canonicalJID(client, messageStore, voterJID))
+
+		timestamp := time.UnixMilli(update.GetSenderTimestampMS())
+		err := messageStore.StorePollVote(pollID, chat.String(), voter, update.GetVote().GetSelectedOptions(), timestamp)
+		if err != nil {
+			fmt.Printf("Failed to store history poll vote: %v\n", err)
+		}
+	}
+}
+
+// registerPollRoutes serves POST /api/send/poll and GET /api/polls/{message_id}?chat_jid=
+func registerPollRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/send/poll", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SendPollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		req.Question = strings.TrimSpace(req.Question)
+		if req.Recipient == "" || req.Question == "" {
+			http.Error(w, "Recipient and question are required", http.StatusBadRequest)
+			return
+		}
+
+		seen := make(map[string]bool)
+		var options []string
+		for _, option := range req.Options {
+			option = strings.TrimSpace(option)
+			if option == "" || seen[option] {
+				http.Error(w, "Options must be non-empty and unique", http.StatusBadRequest)
+				return
+			}
+			seen[option] = true
+			options = append(options, option)
+		}
+		if len(options) < 2 || len(options) > maxPollOptions {
+			http.Error(w, fmt.Sprintf("A poll needs 2 to %d options", maxPollOptions), http.StatusBadRequest)
+			return
+		}
+
+		// 0 lets voters pick any number of options
+		selectable := 1
+		if req.MultipleAnswers {
+			selectable = 0
+		}
+		msg := client.BuildPollCreation(req.Question, options, selectable)
+		enqueuePrepared(w, r, client, messageStore, req.Recipient, msg)
+	})
+
+	http.HandleFunc("/api/polls/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/polls/")
+		if id == "" {
+			http.Error(w, "Missing poll message ID", http.StatusBadRequest)
+			return
+		}
+
+		results, err := messageStore.GetPollResults(id, r.URL.Query().Get("chat_jid"))
+		if err == sql.ErrNoRows || (err == nil && !messageStore.chatVisible(requestAccess(r), results.ChatJID)) {
+			http.Error(w, "Poll not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"poll": results})
+	})
+}
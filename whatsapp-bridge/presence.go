@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// chatStateTTL is how long a typing or recording state counts after it was
+// received. WhatsApp clients repeat it while the user keeps typing.
+const chatStateTTL = 30 * time.Second
+
+// maxChatPresenceDuration caps how long a chat presence request may block, below
+// the timeout MCP tools use for bridge calls
+const maxChatPresenceDuration = 20 * time.Second
+
+// chatPresenceRepeat is how often composing is resent while a duration runs,
+// as recipients drop it after about 25 seconds
+const chatPresenceRepeat = 10 * time.Second
+
+// Chat states as stored and reported
+const (
+	chatStateComposing = "composing"
+	chatStateRecording = "recording"
+	chatStatePaused    = "paused"
+)
+
+// presenceSubscriptions holds the JIDs subscribed to, as subscriptions end
+// when the connection drops
+var presenceSubscriptions sync.Map
+
+// ChatPresenceInfo is the last known presence of the other side of a chat
+type ChatPresenceInfo struct {
+	Online    *bool           `json:"online,omitempty"`
+	LastSeen  *time.Time      `json:"last_seen,omitempty"`
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"`
+	Typing    []ChatStateInfo `json:"typing,omitempty"`
+}
+
+// ChatStateInfo is someone currently typing or recording in a chat
+type ChatStateInfo struct {
+	JID   string    `json:"jid"`
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+}
+
+// SetPresenceRequest is the body of POST /api/presence
+type SetPresenceRequest struct {
+	State string `json:"state"`
+}
+
+// ChatPresenceRequest is the body of POST /api/presence/chat
+type ChatPresenceRequest struct {
+	JID             string `json:"jid"`
+	State           string `json:"state"`
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+}
+
+// SubscribePresenceRequest is the body of POST /api/presence/subscribe
+type SubscribePresenceRequest struct {
+	JID string `json:"jid"`
+}
+
+// StorePresence records whether a user is online and when they were last seen.
+// A hidden last seen time keeps the one stored before.
+func (store *MessageStore) StorePresence(jid string, online bool, lastSeen time.Time) error {
+	var seen interface{}
+	if !lastSeen.IsZero() {
+		seen = lastSeen
+	}
+	_, err := store.db.Exec(rebind(`
+		INSERT INTO presence (jid, online, last_seen, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET
+			online = EXCLUDED.online,
+			last_seen = COALESCE(EXCLUDED.last_seen, presence.last_seen),
+			updated_at = EXCLUDED.updated_at`),
+		jid, online, seen, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store presence: %v", err)
+	}
+	return nil
+}
+
+// StoreChatState records that someone started or stopped typing in a chat
+func (store *MessageStore) StoreChatState(chatJID, senderJID, state string) error {
+	_, err := store.db.Exec(rebind(`
+		INSERT INTO chat_states (chat_jid, sender_jid, state, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_jid, sender_jid) DO UPDATE SET
+			state = EXCLUDED.state,
+			updated_at = EXCLUDED.updated_at`),
+		chatJID, senderJID, state, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store chat state: %v", err)
+	}
+	return nil
+}
+
+// GetChatPresence returns what is known about the presence of a chat: whether
+// the other side of a direct chat is online, and who is typing or recording.
+// Presence is stored under phone number JIDs, so a LID chat is looked up by its
+// phone number when known.
+func (store *MessageStore) GetChatPresence(chatJID string) (*ChatPresenceInfo, error) {
+	info := &ChatPresenceInfo{}
+	if jid, err := types.ParseJID(chatJID); err == nil && jid.Server == types.HiddenUserServer {
+		if pn, ok := store.pnForLID(jid); ok {
+			chatJID = pn.String()
+		}
+	}
+
+	if !strings.HasSuffix(chatJID, "@"+types.GroupServer) {
+		var (
+			online    bool
+			lastSeen  sql.NullTime
+			updatedAt time.Time
+		)
+		err := store.db.QueryRow(
+			rebind("SELECT online, last_seen, updated_at FROM presence WHERE jid = ?"), chatJID,
+		).Scan(&online, &lastSeen, &updatedAt)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to read presence: %v", err)
+		}
+		if err == nil {
+			info.Online = &online
+			info.UpdatedAt = &updatedAt
+			if lastSeen.Valid {
+				info.LastSeen = &lastSeen.Time
+			}
+		}
+	}
+
+	rows, err := store.db.Query(rebind(`
+		SELECT sender_jid, state, updated_at FROM chat_states
+		WHERE chat_jid = ? AND state <> ? AND updated_at > ?
+		ORDER BY updated_at`),
+		chatJID, chatStatePaused, time.Now().Add(-chatStateTTL),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat states: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s ChatStateInfo
+		if err := rows.Scan(&s.JID, &s.State, &s.Since); err != nil {
+			return nil, err
+		}
+		info.Typing = append(info.Typing, s)
+	}
+	return info, rows.Err()
+}
+
+// handlePresence stores online and last seen updates of subscribed users
+func handlePresence(client *whatsmeow.Client, messageStore *MessageStore, evt *events.Presence) {
+	jid := canonicalJID(client, messageStore, evt.From)
+	if err := messageStore.StorePresence(jid.String(), !evt.Unavailable, evt.LastSeen); err != nil {
+		fmt.Printf("Failed to store presence of %s: %v\n", jid, err)
+	}
+}
+
+// handleChatPresence stores typing and recording notifications
+func handleChatPresence(client *whatsmeow.Client, messageStore *MessageStore, evt *events.ChatPresence) {
+	chat := canonicalJID(client, messageStore, evt.Chat)
+	sender := canonicalJID(client, messageStore, evt.Sender)
+
+	state := chatStatePaused
+	if evt.State == types.ChatPresenceComposing {
+		state = chatStateComposing
+		if evt.Media == types.ChatPresenceMediaAudio {
+			state = chatStateRecording
+		}
+	}
+	if err := messageStore.StoreChatState(chat.String(), sender.String(), state); err != nil {
+		fmt.Printf("Failed to store chat state in %s: %v\n", chat, err)
+	}
+}
+
+// resubscribePresence renews presence subscriptions after a reconnect
+func resubscribePresence(client *whatsmeow.Client) {
+	presenceSubscriptions.Range(func(key, _ interface{}) bool {
+		if err := client.SubscribePresence(context.Background(), key.(types.JID)); err != nil {
+			fmt.Printf("Failed to resubscribe to presence of %s: %v\n", key, err)
+		}
+		return true
+	})
+}
+
+// sendChatPresence shows composing or recording in a chat, keeping it up for
+// duration if given. The state is not cleared afterwards so the message sent
+// next replaces it; send paused to clear it explicitly.
+func sendChatPresence(client *whatsmeow.Client, jid types.JID, state string, duration time.Duration) error {
+	presence, media := types.ChatPresenceComposing, types.ChatPresenceMediaText
+	switch state {
+	case chatStateComposing:
+	case chatStateRecording:
+		media = types.ChatPresenceMediaAudio
+	case chatStatePaused:
+		presence = types.ChatPresencePaused
+	default:
+		return fmt.Errorf("invalid state %q", state)
+	}
+
+	send := func() error {
+		return client.SendChatPresence(context.Background(), jid, presence, media)
+	}
+	if err := send(); err != nil {
+		return err
+	}
+	if presence == types.ChatPresencePaused {
+		return nil
+	}
+
+	deadline := time.Now().Add(duration)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		time.Sleep(min(remaining, chatPresenceRepeat))
+		if time.Until(deadline) > 0 {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// registerPresenceRoutes serves the presence endpoints:
+// POST /api/presence, POST /api/presence/chat, POST /api/presence/subscribe
+// and GET /api/presence/{jid}
+func registerPresenceRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/presence", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SetPresenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+
+		var state types.Presence
+		switch req.State {
+		case string(types.PresenceAvailable):
+			state = types.PresenceAvailable
+		case string(types.PresenceUnavailable):
+			state = types.PresenceUnavailable
+		default:
+			http.Error(w, "State must be available or unavailable", http.StatusBadRequest)
+			return
+		}
+		if err := client.SendPresence(context.Background(), state); err != nil {
+			respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to set presence: %v", err))
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"state": state})
+	})
+
+	http.HandleFunc("/api/presence/", func(w http.ResponseWriter, r *http.Request) {
+		action := strings.TrimPrefix(r.URL.Path, "/api/presence/")
+
+		switch action {
+		case "chat":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var req ChatPresenceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			jid, err := parseUserJID(req.JID)
+			if req.JID == "" || err != nil {
+				http.Error(w, "Invalid JID", http.StatusBadRequest)
+				return
+			}
+			switch req.State {
+			case chatStateComposing, chatStateRecording, chatStatePaused:
+			default:
+				http.Error(w, "State must be composing, recording or paused", http.StatusBadRequest)
+				return
+			}
+			duration := time.Duration(req.DurationSeconds) * time.Second
+			if duration < 0 || duration > maxChatPresenceDuration {
+				http.Error(w, fmt.Sprintf("Duration must be between 0 and %d seconds", int(maxChatPresenceDuration.Seconds())), http.StatusBadRequest)
+				return
+			}
+			if err := sendChatPresence(client, jid, req.State, duration); err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to send chat presence: %v", err))
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"jid":   jid.String(),
+				"state": req.State,
+			})
+
+		case "subscribe":
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var req SubscribePresenceRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			jid, err := parseUserJID(req.JID)
+			if req.JID == "" || err != nil || jid.Server == types.GroupServer {
+				http.Error(w, "Invalid user JID", http.StatusBadRequest)
+				return
+			}
+			if !messageStore.contactVisible(requestAccess(r), jid.String()) {
+				http.Error(w, "Contact not found", http.StatusNotFound)
+				return
+			}
+			if err := client.SubscribePresence(context.Background(), jid); err != nil {
+				respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to subscribe to presence: %v", err))
+				return
+			}
+			presenceSubscriptions.Store(jid, true)
+
+			presence, err := messageStore.GetChatPresence(canonicalJID(client, messageStore, jid).String())
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"jid":      jid.String(),
+				"presence": presence,
+			})
+
+		default:
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			jid, err := parseUserJID(action)
+			if action == "" || err != nil {
+				http.Error(w, "Invalid JID", http.StatusBadRequest)
+				return
+			}
+			jid = canonicalJID(client, messageStore, jid)
+			if !messageStore.contactVisible(requestAccess(r), jid.String()) {
+				http.Error(w, "Contact not found", http.StatusNotFound)
+				return
+			}
+			presence, err := messageStore.GetChatPresence(jid.String())
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"jid":      jid.String(),
+				"presence": presence,
+			})
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// profileMaxAge is how long a cached profile is served before it is fetched again
+const profileMaxAge = 24 * time.Hour
+
+// profilePictureDir holds downloaded profile pictures and group photos
+const profilePictureDir = "store/profiles"
+
+// Picture states when there is no picture to show
+const (
+	pictureNotSet = "not_set"
+	pictureHidden = "hidden"
+)
+
+// Profile is what the bridge knows about a user or group beyond its name
+type Profile struct {
+	JID           string    `json:"jid"`
+	Name          string    `json:"name,omitempty"`
+	About         string    `json:"about,omitempty"`
+	BusinessName  string    `json:"business_name,omitempty"`
+	PictureID     string    `json:"picture_id,omitempty"`
+	PicturePath   string    `json:"picture_path,omitempty"`
+	PictureStatus string    `json:"picture_status,omitempty"`
+	Devices       []string  `json:"devices,omitempty"`
+	FetchedAt     time.Time `json:"fetched_at"`
+}
+
+// GetProfile returns the cached profile of a JID
+func (store *MessageStore) GetProfile(jid string) (*Profile, error) {
+	var (
+		p                                               Profile
+		about, business, pictureID, picturePath, status sql.NullString
+		devices                                         sql.NullString
+	)
+	err := store.db.QueryRow(rebind(`
+		SELECT jid, about, business_name, picture_id, picture_path, picture_status, devices, fetched_at
+		FROM profiles WHERE jid = ?`), jid,
+	).Scan(&p.JID, &about, &business, &pictureID, &picturePath, &status, &devices, &p.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.About = about.String
+	p.BusinessName = business.String
+	p.PictureID = pictureID.String
+	p.PicturePath = picturePath.String
+	p.PictureStatus = status.String
+	if devices.String != "" {
+		if err := json.Unmarshal([]byte(devices.String), &p.Devices); err != nil {
+			fmt.Printf("Failed to parse cached devices of %s: %v\n", jid, err)
+		}
+	}
+	return &p, nil
+}
+
+// SaveProfile stores a fetched profile
+func (store *MessageStore) SaveProfile(p *Profile) error {
+	devices, err := json.Marshal(p.Devices)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(rebind(`
+		INSERT INTO profiles (jid, about, business_name, picture_id, picture_path, picture_status, devices, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET
+			about = EXCLUDED.about,
+			business_name = EXCLUDED.business_name,
+			picture_id = EXCLUDED.picture_id,
+			picture_path = EXCLUDED.picture_path,
+			picture_status = EXCLUDED.picture_status,
+			devices = EXCLUDED.devices,
+			fetched_at = EXCLUDED.fetched_at`),
+		p.JID, p.About, p.BusinessName, p.PictureID, p.PicturePath, p.PictureStatus, string(devices), p.FetchedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store profile: %v", err)
+	}
+	return nil
+}
+
+// parseProfileJID accepts a user JID, a LID, a group JID or a bare phone number
+// and returns the JID the profile is stored under
+func parseProfileJID(client *whatsmeow.Client, messageStore *MessageStore, s string) (types.JID, error) {
+	jid, err := parseUserJID(s)
+	if err != nil {
+		return jid, err
+	}
+	switch jid.Server {
+	case types.DefaultUserServer, types.HiddenUserServer, types.GroupServer:
+	default:
+		return jid, fmt.Errorf("%s is not a user or group JID", s)
+	}
+	return canonicalJID(client, messageStore, jid), nil
+}
+
+// fetchProfile returns a user's or group's profile, from the cache while it is
+// fresh and from WhatsApp otherwise
+func fetchProfile(client *whatsmeow.Client, messageStore *MessageStore, jid types.JID, refresh bool) (*Profile, error) {
+	cached, err := messageStore.GetProfile(jid.String())
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read cached profile: %v", err)
+	}
+	if cached != nil && !refresh && time.Since(cached.FetchedAt) < profileMaxAge &&
+		profilePictureCached(messageStore, cached) {
+		cached.Name = profileName(messageStore, jid)
+		return cached, nil
+	}
+
+	p := &Profile{JID: jid.String(), FetchedAt: time.Now()}
+	if cached != nil {
+		p.PictureID, p.PicturePath, p.PictureStatus = cached.PictureID, cached.PicturePath, cached.PictureStatus
+	}
+
+	if jid.Server == types.GroupServer {
+		info, err := client.GetGroupInfo(context.Background(), jid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group info: %v", err)
+		}
+		p.About = info.Topic
+	} else {
+		infos, err := client.GetUserInfo(context.Background(), []types.JID{jid})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user info: %v", err)
+		}
+		info, ok := infos[jid]
+		if !ok {
+			return nil, fmt.Errorf("%s is not on WhatsApp", jid)
+		}
+		p.About = info.Status
+		if info.VerifiedName != nil && info.VerifiedName.Details != nil {
+			p.BusinessName = info.VerifiedName.Details.GetVerifiedName()
+		}
+		for _, device := range info.Devices {
+			p.Devices = append(p.Devices, device.String())
+		}
+		if p.BusinessName != "" {
+			c := contactFromJID(jid)
+			c.BusinessName = p.BusinessName
+			if err := messageStore.UpsertContacts([]ContactRecord{c}); err != nil {
+				fmt.Printf("Failed to store business name: %v\n", err)
+			}
+		}
+	}
+
+	if err := updateProfilePicture(client, messageStore, p, refresh); err != nil {
+		return nil, err
+	}
+	if err := messageStore.SaveProfile(p); err != nil {
+		return nil, err
+	}
+	p.Name = profileName(messageStore, jid)
+	return p, nil
+}
+
+// profileName is the stored name of a user or group, if there is one
+func profileName(messageStore *MessageStore, jid types.JID) string {
+	name := messageStore.GetSenderName(jid.String())
+	if name == jid.User || name == jid.String() {
+		return ""
+	}
+	return name
+}
+
+// profilePictureCached reports whether the picture a profile points to is still on disk
+func profilePictureCached(messageStore *MessageStore, p *Profile) bool {
+	if p.PicturePath == "" {
+		return true
+	}
+	return messageStore.media.Lookup("", p.JID, p.PicturePath, nil)
+}
+
+// updateProfilePicture downloads the current picture of a profile when it has
+// changed or the cached file is gone. WhatsApp answers with no picture info when
+// the ID we pass is still current.
+func updateProfilePicture(client *whatsmeow.Client, messageStore *MessageStore, p *Profile, force bool) error {
+	jid, err := types.ParseJID(p.JID)
+	if err != nil {
+		return err
+	}
+
+	existingID := p.PictureID
+	if force || !profilePictureCached(messageStore, p) {
+		existingID = ""
+	}
+	info, err := client.GetProfilePictureInfo(context.Background(), jid, &whatsmeow.GetProfilePictureParams{
+		ExistingID: existingID,
+	})
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureNotSet):
+		removeProfilePicture(messageStore, p)
+		p.PictureStatus = pictureNotSet
+		return nil
+	case errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized):
+		removeProfilePicture(messageStore, p)
+		p.PictureStatus = pictureHidden
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to get profile picture: %v", err)
+	case info == nil:
+		return nil
+	}
+
+	data, err := downloadProfilePicture(info.URL)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(profilePictureDir, 0755); err != nil {
+		return fmt.Errorf("failed to create profile picture directory: %v", err)
+	}
+	name := fmt.Sprintf("%s_%s.jpg", strings.ReplaceAll(jid.String(), ":", "_"), info.ID)
+	path, err := filepath.Abs(filepath.Join(profilePictureDir, name))
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save profile picture: %v", err)
+	}
+	if p.PicturePath != path {
+		removeProfilePicture(messageStore, p)
+	}
+	if err := messageStore.media.Add("", p.JID, path, data); err != nil {
+		fmt.Printf("Failed to update media cache: %v\n", err)
+	}
+
+	p.PictureID, p.PicturePath, p.PictureStatus = info.ID, path, ""
+	return nil
+}
+
+// downloadProfilePicture fetches a picture from the URL WhatsApp hands out
+func downloadProfilePicture(url string) ([]byte, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download profile picture: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download profile picture: HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download profile picture: %v", err)
+	}
+	return data, nil
+}
+
+// removeProfilePicture deletes the cached picture file of a profile
+func removeProfilePicture(messageStore *MessageStore, p *Profile) {
+	if p.PicturePath != "" {
+		if err := os.Remove(p.PicturePath); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove profile picture %s: %v\n", p.PicturePath, err)
+		}
+		if err := messageStore.media.forget(p.PicturePath); err != nil {
+			fmt.Printf("Failed to update media cache: %v\n", err)
+		}
+	}
+	p.PictureID, p.PicturePath = "", ""
+}
+
+// handlePictureEvent keeps cached profiles in step when someone changes or
+// removes their picture. Profiles nobody asked for yet are left alone.
+func handlePictureEvent(client *whatsmeow.Client, messageStore *MessageStore, evt *events.Picture) {
+	jid := canonicalJID(client, messageStore, evt.JID)
+	p, err := messageStore.GetProfile(jid.String())
+	if err != nil {
+		return
+	}
+
+	if evt.Remove {
+		removeProfilePicture(messageStore, p)
+		p.PictureStatus = pictureNotSet
+	} else if evt.PictureID != p.PictureID {
+		if err := updateProfilePicture(client, messageStore, p, true); err != nil {
+			fmt.Printf("Failed to refresh profile picture of %s: %v\n", jid, err)
+			return
+		}
+	}
+	if err := messageStore.SaveProfile(p); err != nil {
+		fmt.Printf("Failed to update profile of %s: %v\n", jid, err)
+	}
+}
+
+// handleUserAbout updates the about text of a cached profile
+func handleUserAbout(client *whatsmeow.Client, messageStore *MessageStore, evt *events.UserAbout) {
+	jid := canonicalJID(client, messageStore, evt.JID)
+	p, err := messageStore.GetProfile(jid.String())
+	if err != nil {
+		return
+	}
+	p.About = evt.Status
+	if err := messageStore.SaveProfile(p); err != nil {
+		fmt.Printf("Failed to update profile of %s: %v\n", jid, err)
+	}
+}
+
+// registerProfileRoutes serves /api/profiles/{jid} and /api/profiles/{jid}/picture.
+// Both take ?refresh=true to skip the cache.
+func registerProfileRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/profiles/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/profiles/"), "/", 2)
+		jid, err := parseProfileJID(client, messageStore, parts[0])
+		if parts[0] == "" || err != nil {
+			http.Error(w, "Invalid JID", http.StatusBadRequest)
+			return
+		}
+		if !messageStore.contactVisible(requestAccess(r), jid.String()) {
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+		refresh := r.URL.Query().Get("refresh") == "true"
+
+		switch {
+		case len(parts) == 1:
+			p, err := fetchProfile(client, messageStore, jid, refresh)
+			if err != nil {
+				respondError(w, http.StatusBadGateway, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{"profile": p})
+
+		case parts[1] == "picture":
+			p, err := messageStore.GetProfile(jid.String())
+			if err == sql.ErrNoRows {
+				p, err = &Profile{JID: jid.String()}, nil
+			}
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read cached profile: %v", err))
+				return
+			}
+			if err := updateProfilePicture(client, messageStore, p, refresh); err != nil {
+				respondError(w, http.StatusBadGateway, err.Error())
+				return
+			}
+			// A new row only holds the picture; its zero fetched_at keeps it stale
+			if err := messageStore.SaveProfile(p); err != nil {
+				fmt.Printf("Failed to update profile of %s: %v\n", jid, err)
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"jid":            p.JID,
+				"picture_id":     p.PictureID,
+				"picture_path":   p.PicturePath,
+				"picture_status": p.PictureStatus,
+			})
+
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Sends are limited to keep a runaway caller from getting the number banned.
+// Token buckets cap sends overall, per recipient and to contacts the account has
+// never exchanged messages with; a send over a limit is refused with 429 and
+// Retry-After. The outbox worker also waits a random delay between consecutive
+// sends, so bursts don't go out at machine speed.
+
+// rateLimit allows Count sends per Per, in bursts of up to Count. A zero Count
+// means no limit.
+type rateLimit struct {
+	Count int
+	Per   time.Duration
+}
+
+func (l rateLimit) enabled() bool {
+	return l.Count > 0 && l.Per > 0
+}
+
+func (l rateLimit) String() string {
+	if !l.enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Count, shortDuration(l.Per))
+}
+
+// shortDuration writes 1m instead of 1m0s and 24h instead of 24h0m0s
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// parseRateLimit reads a limit like "20/1m", "100/h" or "off"
+func parseRateLimit(value string) (rateLimit, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return rateLimit{}, nil
+	}
+	count, per, ok := strings.Cut(value, "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("expected count/duration like 20/1m")
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return rateLimit{}, fmt.Errorf("bad count %q", count)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return rateLimit{}, fmt.Errorf("bad duration %q", per)
+	}
+	return rateLimit{Count: n, Per: d}, nil
+}
+
+// tokenBucket holds the sends left under a limit
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last call; a new bucket starts full
+func (b *tokenBucket) refill(l rateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = float64(l.Count)
+	} else if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * float64(l.Count) / l.Per.Seconds()
+		b.tokens = math.Min(b.tokens, float64(l.Count))
+	}
+	b.last = now
+}
+
+// wait is how long until the bucket has a token
+func (b *tokenBucket) wait(l rateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(l.Per) / float64(l.Count))
+}
+
+// rateLimitError is a send refused by a limit
+type rateLimitError struct {
+	Scope      string
+	Limit      rateLimit
+	RetryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s send limit of %s reached, retry after %d seconds", e.Scope, e.Limit, retryAfterSeconds(e.RetryAfter))
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// sendLimiter applies the send limits and the pacing between sends
+type sendLimiter struct {
+	global, perRecipient, newContacts rateLimit
+	minDelay, maxDelay                time.Duration
+
+	mu           sync.Mutex
+	globalTokens tokenBucket
+	newTokens    tokenBucket
+	recipients   map[string]*tokenBucket
+	nextSend     time.Time
+
+	allowed    int
+	refused    map[string]int
+	paced      int
+	pacedTotal time.Duration
+}
+
+const (
+	limitScopeGlobal     = "global"
+	limitScopeRecipient  = "per-recipient"
+	limitScopeNewContact = "new contact"
+)
+
+func newSendLimiter(global, perRecipient, newContacts rateLimit, minDelay, maxDelay time.Duration) *sendLimiter {
+	return &sendLimiter{
+		global:       global,
+		perRecipient: perRecipient,
+		newContacts:  newContacts,
+		minDelay:     minDelay,
+		maxDelay:     maxDelay,
+		recipients:   make(map[string]*tokenBucket),
+		refused:      make(map[string]int),
+	}
+}
+
+// sendLimits is replaced by the configured limits at startup
+var sendLimits = newSendLimiter(
+	rateLimit{Count: 20, Per: time.Minute},
+	rateLimit{Count: 6, Per: time.Minute},
+	rateLimit{Count: 20, Per: 24 * time.Hour},
+	time.Second, 4*time.Second,
+)
+
+// loadSendLimiter reads SEND_LIMIT_GLOBAL, SEND_LIMIT_PER_RECIPIENT and
+// SEND_LIMIT_NEW_CONTACTS (like 20/1m, or off) and SEND_DELAY_MIN and
+// SEND_DELAY_MAX (Go durations), keeping the defaults for unset variables.
+func loadSendLimiter() (*sendLimiter, error) {
+	global, perRecipient, newContacts := sendLimits.global, sendLimits.perRecipient, sendLimits.newContacts
+	minDelay, maxDelay := sendLimits.minDelay, sendLimits.maxDelay
+
+	for name, limit := range map[string]*rateLimit{
+		"SEND_LIMIT_GLOBAL":        &global,
+		"SEND_LIMIT_PER_RECIPIENT": &perRecipient,
+		"SEND_LIMIT_NEW_CONTACTS":  &newContacts,
+	} {
+		if val, ok := os.LookupEnv(name); ok && val != "" {
+			l, err := parseRateLimit(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", name, val, err)
+			}
+			*limit = l
+		}
+	}
+	for name, delay := range map[string]*time.Duration{
+		"SEND_DELAY_MIN": &minDelay,
+		"SEND_DELAY_MAX": &maxDelay,
+	} {
+		if val, ok := os.LookupEnv(name); ok && val != "" {
+			d, err := time.ParseDuration(val)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid %s: %q", name, val)
+			}
+			*delay = d
+		}
+	}
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	return newSendLimiter(global, perRecipient, newContacts, minDelay, maxDelay), nil
+}
+
+// recipientKey is the form a recipient is counted under, so a number and its
+// JID share a bucket
+func recipientKey(messageStore *MessageStore, recipient string) string {
+	if strings.Contains(recipient, "@") {
+		if jid, err := types.ParseJID(recipient); err == nil {
+			return canonicalJID(nil, messageStore, jid).String()
+		}
+		return recipient
+	}
+	if phone, err := normalizePhoneNumber(recipient); err == nil {
+		return types.NewJID(phone, types.DefaultUserServer).String()
+	}
+	return recipient
+}
+
+// isNewContact reports whether no message was ever exchanged with a person.
+// Groups are never new contacts.
+func (store *MessageStore) isNewContact(key string) bool {
+	jid, err := types.ParseJID(key)
+	if err != nil || (jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer) {
+		return false
+	}
+	_, chats := store.contactIdentities(key)
+	args := make([]interface{}, len(chats))
+	for i, chat := range chats {
+		args[i] = chat
+	}
+	var found int
+	err = store.db.QueryRow(rebind("SELECT COUNT(*) FROM messages WHERE chat_jid IN ("+placeholders(len(chats))+")"), args...).Scan(&found)
+	return err == nil && found == 0
+}
+
+// allow takes a send to recipient from the limits, or says how long to wait
+func (s *sendLimiter) allow(messageStore *MessageStore, recipient string, now time.Time) *rateLimitError {
+	key := recipientKey(messageStore, recipient)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+
+	type check struct {
+		scope  string
+		limit  rateLimit
+		bucket *tokenBucket
+	}
+	var checks []check
+	if s.global.enabled() {
+		checks = append(checks, check{limitScopeGlobal, s.global, &s.globalTokens})
+	}
+	bucket, known := s.recipients[key]
+	if !known {
+		bucket = &tokenBucket{}
+	}
+	if s.perRecipient.enabled() {
+		checks = append(checks, check{limitScopeRecipient, s.perRecipient, bucket})
+	} else {
+		bucket.last = now
+	}
+	// A recipient with a bucket was sent to recently, so isn't new any more
+	if s.newContacts.enabled() && !known && messageStore.isNewContact(key) {
+		checks = append(checks, check{limitScopeNewContact, s.newContacts, &s.newTokens})
+	}
+
+	var refused *rateLimitError
+	for _, c := range checks {
+		c.bucket.refill(c.limit, now)
+		if wait := c.bucket.wait(c.limit); wait > 0 && (refused == nil || wait > refused.RetryAfter) {
+			refused = &rateLimitError{Scope: c.scope, Limit: c.limit, RetryAfter: wait}
+		}
+	}
+	if refused != nil {
+		s.refused[refused.Scope]++
+		return refused
+	}
+
+	for _, c := range checks {
+		c.bucket.tokens--
+	}
+	s.recipients[key] = bucket
+	s.allowed++
+	return nil
+}
+
+// prune forgets recipients whose bucket has filled up again. They are kept for
+// at least an hour, by when a first message has usually been delivered and
+// stored, so a new contact isn't counted twice.
+func (s *sendLimiter) prune(now time.Time) {
+	keep := time.Hour
+	if s.perRecipient.Per > keep {
+		keep = s.perRecipient.Per
+	}
+	for key, bucket := range s.recipients {
+		if now.Sub(bucket.last) > keep {
+			delete(s.recipients, key)
+		}
+	}
+}
+
+// pace waits a random delay after the previous send before the next goes out.
+// Concurrent callers each get their own slot.
+func (s *sendLimiter) pace() {
+	s.mu.Lock()
+	delay := s.minDelay
+	if s.maxDelay > s.minDelay {
+		delay += time.Duration(rand.Int63n(int64(s.maxDelay - s.minDelay)))
+	}
+	now := time.Now()
+	next := s.nextSend.Add(delay)
+	if next.Before(now) {
+		next = now
+	}
+	s.nextSend = next
+	wait := next.Sub(now)
+	if wait > 0 {
+		s.paced++
+		s.pacedTotal += wait
+	}
+	s.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// respondRateLimited answers a refused send with 429 and Retry-After
+func respondRateLimited(w http.ResponseWriter, err *rateLimitError) {
+	seconds := retryAfterSeconds(err.RetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"success":     false,
+		"message":     err.Error(),
+		"retry_after": seconds,
+	})
+}
+
+// LimitStatus is the state of one send limit
+type LimitStatus struct {
+	Limit      string   `json:"limit"`
+	Available  *float64 `json:"available,omitempty"`
+	RetryAfter int      `json:"retry_after"`
+	Refused    int      `json:"refused"`
+}
+
+// SendMetrics is the body of GET /api/metrics
+type SendMetrics struct {
+	Limits struct {
+		Global       LimitStatus `json:"global"`
+		PerRecipient LimitStatus `json:"per_recipient"`
+		NewContacts  LimitStatus `json:"new_contacts"`
+	} `json:"limits"`
+	// LimitedRecipients are the recipients that can't be sent to right now,
+	// with the seconds until they can
+	LimitedRecipients map[string]int `json:"limited_recipients"`
+	Sends             struct {
+		Allowed      int     `json:"allowed"`
+		Refused      int     `json:"refused"`
+		Paced        int     `json:"paced"`
+		PacedSeconds float64 `json:"paced_seconds"`
+	} `json:"sends"`
+	Pacing struct {
+		MinDelay string `json:"min_delay"`
+		MaxDelay string `json:"max_delay"`
+	} `json:"pacing"`
+	Outbox map[string]int `json:"outbox"`
+}
+
+// metrics reports the limits as they stand at now
+func (s *sendLimiter) metrics(now time.Time) SendMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+
+	var m SendMetrics
+	status := func(scope string, l rateLimit, bucket *tokenBucket) LimitStatus {
+		st := LimitStatus{Limit: l.String(), Refused: s.refused[scope]}
+		if l.enabled() && bucket != nil {
+			// Look at a copy, so reading doesn't move the bucket on
+			b := *bucket
+			b.refill(l, now)
+			available := math.Floor(b.tokens*100) / 100
+			st.Available = &available
+			st.RetryAfter = retryAfterSeconds(b.wait(l))
+		}
+		return st
+	}
+	m.Limits.Global = status(limitScopeGlobal, s.global, &s.globalTokens)
+	m.Limits.PerRecipient = status(limitScopeRecipient, s.perRecipient, nil)
+	m.Limits.NewContacts = status(limitScopeNewContact, s.newContacts, &s.newTokens)
+
+	m.LimitedRecipients = map[string]int{}
+	if s.perRecipient.enabled() {
+		for key, bucket := range s.recipients {
+			b := *bucket
+			b.refill(s.perRecipient, now)
+			if wait := b.wait(s.perRecipient); wait > 0 {
+				m.LimitedRecipients[key] = retryAfterSeconds(wait)
+			}
+		}
+	}
+
+	m.Sends.Allowed = s.allowed
+	for _, n := range s.refused {
+		m.Sends.Refused += n
+	}
+	m.Sends.Paced = s.paced
+	m.Sends.PacedSeconds = math.Round(s.pacedTotal.Seconds()*10) / 10
+	m.Pacing.MinDelay = s.minDelay.String()
+	m.Pacing.MaxDelay = s.maxDelay.String()
+	return m
+}
+
+// registerMetricsRoutes serves GET /api/metrics
+func registerMetricsRoutes(messageStore *MessageStore) {
+	http.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// The metrics name the recipients being held back
+		if !requireFullAccess(w, r, "Metrics") {
+			return
+		}
+		m := sendLimits.metrics(time.Now())
+		counts, err := messageStore.outboxCounts()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		m.Outbox = counts
+		respondJSON(w, http.StatusOK, m)
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMessageStore is an in-memory store with the tables the limiter reads
+func testMessageStore(t *testing.T) *MessageStore {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		CREATE TABLE messages (id TEXT, chat_jid TEXT, content TEXT);
+		CREATE TABLE lid_mappings (lid TEXT PRIMARY KEY, pn TEXT, updated_at TIMESTAMP);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &MessageStore{db: db}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  rateLimit
+		str   string
+	}{
+		{"20/1m", rateLimit{20, time.Minute}, "20/1m"},
+		{"100/h", rateLimit{100, time.Hour}, "100/1h"},
+		{" 6/m ", rateLimit{6, time.Minute}, "6/1m"},
+		{"20/24h", rateLimit{20, 24 * time.Hour}, "20/24h"},
+		{"5/90s", rateLimit{5, 90 * time.Second}, "5/1m30s"},
+		{"off", rateLimit{}, "off"},
+		{"0", rateLimit{}, "off"},
+		{"0/1m", rateLimit{0, time.Minute}, "off"},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.value)
+		if err != nil {
+			t.Errorf("parseRateLimit(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want || got.String() != tt.str {
+			t.Errorf("parseRateLimit(%q) = %v (%s), want %v (%s)", tt.value, got, got.String(), tt.want, tt.str)
+		}
+	}
+
+	for _, value := range []string{"", "20", "x/1m", "-1/1m", "20/", "20/0s", "20/-1m", "20/fortnight"} {
+		if _, err := parseRateLimit(value); err == nil {
+			t.Errorf("parseRateLimit(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	limit := rateLimit{Count: 3, Per: time.Minute}
+	start := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		bucket   tokenBucket
+		now      time.Time
+		tokens   float64
+		wantWait time.Duration
+	}{
+		{"new bucket starts full", tokenBucket{}, start, 3, 0},
+		{"empty bucket waits a token's time", tokenBucket{0, start}, start, 0, 20 * time.Second},
+		{"part of a token earned", tokenBucket{0, start}, start.Add(5 * time.Second), 0.25, 15 * time.Second},
+		{"a token earned", tokenBucket{0, start}, start.Add(20 * time.Second), 1, 0},
+		{"capped at the count", tokenBucket{2, start}, start.Add(time.Hour), 3, 0},
+		{"clock going back earns nothing", tokenBucket{0.5, start}, start.Add(-time.Minute), 0.5, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		b := tt.bucket
+		b.refill(limit, tt.now)
+		if b.tokens != tt.tokens {
+			t.Errorf("%s: %v tokens, want %v", tt.name, b.tokens, tt.tokens)
+		}
+		if !b.last.Equal(tt.now) {
+			t.Errorf("%s: last %v, want %v", tt.name, b.last, tt.now)
+		}
+		if wait := b.wait(limit); wait != tt.wantWait {
+			t.Errorf("%s: wait %v, want %v", tt.name, wait, tt.wantWait)
+		}
+	}
+}
+
+// send is one call to allow and what it should give
+type send struct {
+	at        time.Duration // after the start
+	recipient string
+	scope     string // refusing limit, or "" when allowed
+	retry     time.Duration
+}
+
+func runSends(t *testing.T, s *sendLimiter, store *MessageStore, sends []send) {
+	t.Helper()
+	start := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	for i, sd := range sends {
+		err := s.allow(store, sd.recipient, start.Add(sd.at))
+		switch {
+		case sd.scope == "" && err != nil:
+			t.Errorf("send %d to %s at %v: refused: %v", i, sd.recipient, sd.at, err)
+		case sd.scope != "" && err == nil:
+			t.Errorf("send %d to %s at %v: allowed, want the %s limit", i, sd.recipient, sd.at, sd.scope)
+		case err != nil && (err.Scope != sd.scope || (err.RetryAfter-sd.retry).Abs() > time.Millisecond):
+			t.Errorf("send %d to %s at %v: %s limit for %v, want %s for %v", i, sd.recipient, sd.at, err.Scope, err.RetryAfter, sd.scope, sd.retry)
+		}
+	}
+}
+
+func TestSendLimiterAllow(t *testing.T) {
+	const (
+		alice = "15550000001"
+		bob   = "15550000002"
+		group = "120363000000000001@g.us"
+	)
+	off := rateLimit{}
+
+	tests := []struct {
+		name                              string
+		global, perRecipient, newContacts rateLimit
+		sends                             []send
+	}{
+		{
+			name:   "global",
+			global: rateLimit{2, time.Minute},
+			sends: []send{
+				{0, alice, "", 0},
+				{0, bob, "", 0},
+				{time.Second, alice, limitScopeGlobal, 29 * time.Second},
+				{31 * time.Second, group, "", 0},
+				{31 * time.Second, bob, limitScopeGlobal, 29 * time.Second},
+			},
+		},
+		{
+			name:         "per recipient",
+			perRecipient: rateLimit{1, time.Minute},
+			sends: []send{
+				{0, alice, "", 0},
+				{0, bob, "", 0},
+				{10 * time.Second, alice, limitScopeRecipient, 50 * time.Second},
+				// A number and its JID are the same recipient
+				{10 * time.Second, alice + "@s.whatsapp.net", limitScopeRecipient, 50 * time.Second},
+				{61 * time.Second, alice, "", 0},
+			},
+		},
+		{
+			name:         "longest wait wins",
+			global:       rateLimit{10, time.Minute},
+			perRecipient: rateLimit{1, time.Hour},
+			sends: []send{
+				{0, alice, "", 0},
+				{0, alice, limitScopeRecipient, time.Hour},
+			},
+		},
+		{
+			name:        "new contacts",
+			newContacts: rateLimit{1, 24 * time.Hour},
+			sends: []send{
+				{0, alice, "", 0},
+				{0, bob, limitScopeNewContact, 24 * time.Hour},
+				// This one has written before, so isn't new
+				{0, "15550000003", "", 0},
+				// Groups are never new contacts
+				{0, group, "", 0},
+				// Alice now has a bucket, so she isn't new either
+				{time.Minute, alice, "", 0},
+			},
+		},
+		{
+			name:         "all off",
+			global:       off,
+			perRecipient: off,
+			newContacts:  off,
+			sends: []send{
+				{0, alice, "", 0},
+				{0, alice, "", 0},
+				{0, bob, "", 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := testMessageStore(t)
+			if _, err := store.db.Exec("INSERT INTO messages (id, chat_jid) VALUES ('m1', '15550000003@s.whatsapp.net')"); err != nil {
+				t.Fatal(err)
+			}
+			s := newSendLimiter(tt.global, tt.perRecipient, tt.newContacts, 0, 0)
+			runSends(t, s, store, tt.sends)
+		})
+	}
+}
+
+func TestSendLimiterCounts(t *testing.T) {
+	s := newSendLimiter(rateLimit{1, time.Minute}, rateLimit{}, rateLimit{}, 0, 0)
+	runSends(t, s, testMessageStore(t), []send{
+		{0, "15550000001", "", 0},
+		{0, "15550000001", limitScopeGlobal, time.Minute},
+		{0, "15550000002", limitScopeGlobal, time.Minute},
+	})
+	if s.allowed != 1 || s.refused[limitScopeGlobal] != 2 {
+		t.Errorf("allowed %d, refused %v", s.allowed, s.refused)
+	}
+}
+
+func TestSendLimiterPace(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	s := newSendLimiter(rateLimit{}, rateLimit{}, rateLimit{}, delay, delay)
+
+	// The first send goes straight out, each of the others gets its own slot
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.pace()
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Errorf("three sends took %v, want at least %v", elapsed, 2*delay)
+	}
+	if s.paced != 2 {
+		t.Errorf("%d sends paced, want 2", s.paced)
+	}
+	if s.pacedTotal < 3*delay-5*time.Millisecond {
+		t.Errorf("paced for %v in all, want about %v", s.pacedTotal, 3*delay)
+	}
+}
+
+func TestSendLimiterPaceRange(t *testing.T) {
+	const minDelay, maxDelay = 5 * time.Millisecond, 15 * time.Millisecond
+	s := newSendLimiter(rateLimit{}, rateLimit{}, rateLimit{}, minDelay, maxDelay)
+	s.pace()
+	prev := s.nextSend
+	for range 5 {
+		s.pace()
+		if gap := s.nextSend.Sub(prev); gap < minDelay || gap >= maxDelay+50*time.Millisecond {
+			t.Errorf("sends %v apart, want between %v and %v", gap, minDelay, maxDelay)
+		}
+		prev = s.nextSend
+	}
+}
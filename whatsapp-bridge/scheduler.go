@@ -0,0 +1,451 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Scheduled messages are stored with the send request and their next run. When a
+// run is due the scheduler puts the message in the outbox, which delivers it.
+// Runs missed while the bridge was down happen once when it is back; recurring
+// schedules then continue from the current time.
+
+const (
+	scheduleActive    = "scheduled"
+	scheduleDone      = "done"
+	scheduleCancelled = "cancelled"
+
+	schedulePollInterval = 15 * time.Second
+)
+
+// scheduleMediaDir holds copies of files to send later
+const scheduleMediaDir = "store/scheduled"
+
+// ScheduleMessageRequest is the body of POST /api/schedule: a send request plus
+// either a time or a cron expression
+type ScheduleMessageRequest struct {
+	SendMessageRequest
+	SendAt   string `json:"send_at,omitempty"`  // RFC 3339, or local time in timezone like 2026-10-19T09:00
+	Cron     string `json:"cron,omitempty"`     // minute hour day month weekday, e.g. "0 9 * * 1-5"
+	Timezone string `json:"timezone,omitempty"` // IANA name like Europe/Berlin; the bridge's zone by default
+}
+
+// ScheduledMessage is a stored schedule
+type ScheduledMessage struct {
+	ID           string     `json:"id"`
+	Recipient    string     `json:"recipient"`
+	Message      string     `json:"message,omitempty"`
+	MediaPath    string     `json:"media_path,omitempty"`
+	Mentions     []string   `json:"mentions,omitempty"`
+	Cron         string     `json:"cron,omitempty"`
+	Timezone     string     `json:"timezone"`
+	NextRunAt    time.Time  `json:"next_run_at"`
+	Status       string     `json:"status"`
+	Runs         int        `json:"runs"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastOutboxID string     `json:"last_outbox_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// parseSendAt reads a time with an offset, or a local time in loc
+func parseSendAt(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid send_at %q, use e.g. 2026-10-19T09:00 or 2026-10-19T09:00:00+02:00", value)
+}
+
+// newRandomID returns a random hex ID for schedules and jobs
+func newRandomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CreateSchedule stores a schedule. Times are stored in UTC, as SQLite compares
+// them as text.
+func (store *MessageStore) CreateSchedule(s *ScheduledMessage) error {
+	request, err := json.Marshal(SendMessageRequest{
+		Recipient: s.Recipient,
+		Message:   s.Message,
+		MediaPath: s.MediaPath,
+		Mentions:  s.Mentions,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(rebind(`
+		INSERT INTO scheduled_messages (id, request, cron, timezone, next_run_at, status, runs, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?)`),
+		s.ID, string(request), s.Cron, s.Timezone, s.NextRunAt.UTC(), s.Status, s.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store schedule: %v", err)
+	}
+	return nil
+}
+
+const scheduleColumns = `id, request, COALESCE(cron, ''), COALESCE(timezone, ''), next_run_at, status, runs,
+	last_run_at, COALESCE(last_outbox_id, ''), created_at`
+
+func scanSchedule(row interface{ Scan(...any) error }) (*ScheduledMessage, error) {
+	var (
+		s         ScheduledMessage
+		request   string
+		lastRunAt sql.NullTime
+	)
+	err := row.Scan(&s.ID, &request, &s.Cron, &s.Timezone, &s.NextRunAt, &s.Status, &s.Runs,
+		&lastRunAt, &s.LastOutboxID, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var req SendMessageRequest
+	if err := json.Unmarshal([]byte(request), &req); err != nil {
+		return nil, fmt.Errorf("invalid stored schedule %s: %v", s.ID, err)
+	}
+	s.Recipient, s.Message, s.MediaPath, s.Mentions = req.Recipient, req.Message, req.MediaPath, req.Mentions
+
+	// Show times in the schedule's zone
+	if loc, err := time.LoadLocation(s.Timezone); err == nil {
+		s.NextRunAt = s.NextRunAt.In(loc)
+		if lastRunAt.Valid {
+			t := lastRunAt.Time.In(loc)
+			s.LastRunAt = &t
+		}
+	}
+	return &s, nil
+}
+
+// GetSchedule returns a schedule by ID
+func (store *MessageStore) GetSchedule(id string) (*ScheduledMessage, error) {
+	return scanSchedule(store.db.QueryRow(rebind("SELECT "+scheduleColumns+" FROM scheduled_messages WHERE id = ?"), id))
+}
+
+// ListSchedules returns the active schedules by next run, or all of them
+func (store *MessageStore) ListSchedules(includeFinished bool) ([]*ScheduledMessage, error) {
+	query := "SELECT " + scheduleColumns + " FROM scheduled_messages"
+	var args []interface{}
+	if !includeFinished {
+		query += " WHERE status = ?"
+		args = append(args, scheduleActive)
+	}
+	return store.querySchedules(query+" ORDER BY next_run_at", args...)
+}
+
+// dueSchedules returns the active schedules whose next run has come
+func (store *MessageStore) dueSchedules(now time.Time) ([]*ScheduledMessage, error) {
+	return store.querySchedules("SELECT "+scheduleColumns+` FROM scheduled_messages
+		WHERE status = ? AND next_run_at <= ? ORDER BY next_run_at`, scheduleActive, now.UTC())
+}
+
+func (store *MessageStore) querySchedules(query string, args ...interface{}) ([]*ScheduledMessage, error) {
+	rows, err := store.db.Query(rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*ScheduledMessage{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// updateSchedule writes the run state of a schedule. It returns false when the
+// schedule was cancelled meanwhile, which it leaves cancelled.
+func (store *MessageStore) updateSchedule(s *ScheduledMessage) (bool, error) {
+	var lastRunAt interface{}
+	if s.LastRunAt != nil {
+		lastRunAt = s.LastRunAt.UTC()
+	}
+	res, err := store.db.Exec(rebind(`
+		UPDATE scheduled_messages SET next_run_at = ?, status = ?, runs = ?, last_run_at = ?, last_outbox_id = ?
+		WHERE id = ? AND status = ?`),
+		s.NextRunAt.UTC(), s.Status, s.Runs, lastRunAt, s.LastOutboxID, s.ID, scheduleActive,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CancelSchedule stops an active schedule. It returns false when there was
+// nothing left to cancel.
+func (store *MessageStore) CancelSchedule(id string) (bool, error) {
+	res, err := store.db.Exec(rebind("UPDATE scheduled_messages SET status = ? WHERE id = ? AND status = ?"),
+		scheduleCancelled, id, scheduleActive)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// runScheduler queues due scheduled messages until the process exits
+func runScheduler(client *whatsmeow.Client, messageStore *MessageStore) {
+	ticker := time.NewTicker(schedulePollInterval)
+	defer ticker.Stop()
+	for {
+		runDueSchedules(client, messageStore, time.Now())
+		<-ticker.C
+	}
+}
+
+func runDueSchedules(client *whatsmeow.Client, messageStore *MessageStore, now time.Time) {
+	schedules, err := messageStore.dueSchedules(now)
+	if err != nil {
+		fmt.Printf("Failed to read scheduled messages: %v\n", err)
+		return
+	}
+	for _, s := range schedules {
+		runSchedule(client, messageStore, s, now)
+	}
+}
+
+// runSchedule puts one run of a schedule in the outbox and works out the next
+func runSchedule(client *whatsmeow.Client, messageStore *MessageStore, s *ScheduledMessage, now time.Time) {
+	if err := sendLimits.allow(messageStore, s.Recipient, now); err != nil {
+		// Leave the schedule due so a later tick sends it
+		fmt.Printf("Holding back scheduled message %s: %v\n", s.ID, err)
+		return
+	}
+
+	id := string(client.GenerateMessageID())
+	req := SendMessageRequest{Recipient: s.Recipient, Message: s.Message, Mentions: s.Mentions}
+
+	var err error
+	if s.MediaPath != "" {
+		req.MediaPath, err = copyOutboxMedia(id, s.MediaPath)
+	}
+	var payload []byte
+	if err == nil {
+		payload, err = json.Marshal(req)
+	}
+	if err == nil {
+		_, err = messageStore.EnqueueOutbox(id, outboxKindMessage, s.Recipient, payload)
+	}
+	if err != nil {
+		// Leave the schedule due so the next tick tries again
+		removeOutboxMedia(id)
+		fmt.Printf("Failed to queue scheduled message %s: %v\n", s.ID, err)
+		return
+	}
+
+	s.Runs++
+	s.LastRunAt = &now
+	s.LastOutboxID = id
+	s.Status = scheduleDone
+	if s.Cron != "" {
+		if cron, err := parseCron(s.Cron); err == nil {
+			loc, err := time.LoadLocation(s.Timezone)
+			if err != nil {
+				loc = time.Local
+			}
+			if next := cron.next(now.In(loc)); !next.IsZero() {
+				s.NextRunAt = next
+				s.Status = scheduleActive
+			}
+		}
+	}
+	updated, err := messageStore.updateSchedule(s)
+	if err != nil {
+		fmt.Printf("Failed to update scheduled message %s: %v\n", s.ID, err)
+	} else if !updated {
+		// Cancelled while this run was being queued, which also removed its media
+		if _, err := messageStore.cancelOutboxItem(id, "schedule cancelled"); err != nil {
+			fmt.Printf("Failed to cancel run %s of cancelled schedule %s: %v\n", id, s.ID, err)
+		}
+		fmt.Printf("Dropped run %s of cancelled schedule %s\n", id, s.ID)
+		return
+	}
+	outbox.notify()
+	if s.Status != scheduleActive {
+		removeScheduleMedia(s.ID)
+	}
+	fmt.Printf("Queued scheduled message %s to %s as %s\n", s.ID, s.Recipient, id)
+}
+
+func removeScheduleMedia(id string) {
+	if err := os.RemoveAll(filepath.Join(scheduleMediaDir, id)); err != nil {
+		fmt.Printf("Failed to remove scheduled media of %s: %v\n", id, err)
+	}
+}
+
+// newSchedule validates a schedule request and works out the first run
+func newSchedule(req ScheduleMessageRequest, now time.Time) (*ScheduledMessage, error) {
+	if req.Recipient == "" {
+		return nil, fmt.Errorf("Recipient is required")
+	}
+	if req.Message == "" && req.MediaPath == "" {
+		return nil, fmt.Errorf("Message or media path is required")
+	}
+	if (req.SendAt == "") == (req.Cron == "") {
+		return nil, fmt.Errorf("Give either send_at or cron")
+	}
+
+	loc := time.Local
+	if req.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(req.Timezone); err != nil {
+			return nil, fmt.Errorf("Unknown timezone %q", req.Timezone)
+		}
+	}
+
+	s := &ScheduledMessage{
+		ID:        newRandomID(),
+		Recipient: req.Recipient,
+		Message:   req.Message,
+		MediaPath: req.MediaPath,
+		Mentions:  req.Mentions,
+		Cron:      strings.TrimSpace(req.Cron),
+		Timezone:  loc.String(),
+		Status:    scheduleActive,
+		CreatedAt: now,
+	}
+	if s.Cron != "" {
+		cron, err := parseCron(s.Cron)
+		if err != nil {
+			return nil, err
+		}
+		s.NextRunAt = cron.next(now.In(loc))
+		if s.NextRunAt.IsZero() {
+			return nil, fmt.Errorf("cron expression %q never fires", s.Cron)
+		}
+	} else {
+		sendAt, err := parseSendAt(req.SendAt, loc)
+		if err != nil {
+			return nil, err
+		}
+		if !sendAt.After(now) {
+			return nil, fmt.Errorf("send_at %s is in the past", sendAt.In(loc).Format(time.RFC3339))
+		}
+		s.NextRunAt = sendAt.In(loc)
+	}
+	return s, nil
+}
+
+// registerScheduleRoutes serves GET and POST /api/schedule and GET and DELETE /api/schedule/{id}
+func registerScheduleRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/schedule", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			schedules, err := messageStore.ListSchedules(r.URL.Query().Get("all") == "true")
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			access := requestAccess(r)
+			schedules = slices.DeleteFunc(schedules, func(s *ScheduledMessage) bool {
+				return !messageStore.contactVisible(access, s.Recipient)
+			})
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"schedules": schedules,
+				"count":     len(schedules),
+			})
+
+		case http.MethodPost:
+			var req ScheduleMessageRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			s, err := newSchedule(req, time.Now())
+			if err == nil {
+				err = checkSendRequest(client, SendMessageRequest{MediaPath: s.MediaPath, Mentions: s.Mentions})
+			}
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			// The caller's file may be gone by the time the message is sent
+			if s.MediaPath != "" {
+				path, err := copyMediaFile(filepath.Join(scheduleMediaDir, s.ID), s.MediaPath)
+				if err != nil {
+					respondError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				s.MediaPath = path
+			}
+			if err := messageStore.CreateSchedule(s); err != nil {
+				removeScheduleMedia(s.ID)
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success":  true,
+				"message":  fmt.Sprintf("Message to %s scheduled for %s", s.Recipient, s.NextRunAt.Format(time.RFC3339)),
+				"schedule": s,
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/schedule/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/schedule/")
+		if id == "" {
+			http.Error(w, "Missing schedule ID", http.StatusBadRequest)
+			return
+		}
+
+		// Schedules to chats hidden from the caller don't exist for it
+		s, err := messageStore.GetSchedule(id)
+		if err == sql.ErrNoRows || (err == nil && !messageStore.contactVisible(requestAccess(r), s.Recipient)) {
+			http.Error(w, "Schedule not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			respondJSON(w, http.StatusOK, map[string]interface{}{"schedule": s})
+
+		case http.MethodDelete:
+			cancelled, err := messageStore.CancelSchedule(id)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !cancelled {
+				http.Error(w, "No active schedule with this ID", http.StatusNotFound)
+				return
+			}
+			removeScheduleMedia(id)
+			respondJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": fmt.Sprintf("Schedule %s cancelled", id),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Locations and contact cards carry no file. They are stored like media, with a
+// type and their details in their own columns, and a readable summary as content.
+const (
+	mediaTypeLocation     = "location"
+	mediaTypeLiveLocation = "live_location"
+	mediaTypeContact      = "contact"
+)
+
+// VCardContact is the part of a vCard worth showing
+type VCardContact struct {
+	Name         string       `json:"name"`
+	Organization string       `json:"organization,omitempty"`
+	Phones       []VCardPhone `json:"phones,omitempty"`
+	Emails       []string     `json:"emails,omitempty"`
+}
+
+// VCardPhone is one phone number of a vCard. WAID is set when the sender's
+// WhatsApp knew the number is registered.
+type VCardPhone struct {
+	Number string `json:"number"`
+	WAID   string `json:"waid,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+// SendLocationRequest is the body of POST /api/send/location
+type SendLocationRequest struct {
+	Recipient string  `json:"recipient"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+// SendContactCardRequest is the body of POST /api/send/contact
+type SendContactCardRequest struct {
+	Recipient    string   `json:"recipient"`
+	Name         string   `json:"name,omitempty"`
+	PhoneNumbers []string `json:"phone_numbers"`
+}
+
+// extractSharedInfo returns location, contact card and poll messages as media info
+func extractSharedInfo(msg *waE2E.Message) *MediaInfo {
+	if poll := extractPoll(msg); poll != nil {
+		return pollMediaInfo(poll)
+	}
+	if loc := msg.GetLocationMessage(); loc != nil {
+		info := &MediaInfo{
+			Type:            mediaTypeLocation,
+			Latitude:        loc.GetDegreesLatitude(),
+			Longitude:       loc.GetDegreesLongitude(),
+			LocationName:    loc.GetName(),
+			LocationAddress: loc.GetAddress(),
+		}
+		if loc.GetIsLive() {
+			info.Type = mediaTypeLiveLocation
+		}
+		info.Caption = describeLocation(info, loc.GetComment())
+		return info
+	}
+	if live := msg.GetLiveLocationMessage(); live != nil {
+		info := &MediaInfo{
+			Type:      mediaTypeLiveLocation,
+			Latitude:  live.GetDegreesLatitude(),
+			Longitude: live.GetDegreesLongitude(),
+		}
+		info.Caption = describeLocation(info, live.GetCaption())
+		return info
+	}
+
+	var cards []*waE2E.ContactMessage
+	if contact := msg.GetContactMessage(); contact != nil {
+		cards = append(cards, contact)
+	} else if array := msg.GetContactsArrayMessage(); array != nil {
+		cards = array.GetContacts()
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+
+	info := &MediaInfo{Type: mediaTypeContact}
+	var vcards, summaries []string
+	for _, card := range cards {
+		contact := parseVCard(card.GetVcard())
+		if contact.Name == "" {
+			contact.Name = card.GetDisplayName()
+		}
+		vcards = append(vcards, card.GetVcard())
+		summaries = append(summaries, describeContact(contact))
+	}
+	info.VCard = strings.Join(vcards, "\n")
+	info.Caption = strings.Join(summaries, "; ")
+	return info
+}
+
+// describeLocation is the text stored and shown for a location, e.g.
+// "Brandenburger Tor, Pariser Platz 1 (52.516275, 13.377704) https://maps.google.com/?q=52.516275,13.377704"
+func describeLocation(info *MediaInfo, comment string) string {
+	var parts []string
+	if info.Type == mediaTypeLiveLocation {
+		parts = append(parts, "Live location")
+	}
+	for _, s := range []string{info.LocationName, info.LocationAddress} {
+		if s = strings.TrimSpace(s); s != "" {
+			parts = append(parts, s)
+		}
+	}
+
+	text := strings.Join(parts, ", ")
+	coords := fmt.Sprintf("%.6f, %.6f", info.Latitude, info.Longitude)
+	if text == "" {
+		text = coords
+	} else {
+		text += " (" + coords + ")"
+	}
+	text += fmt.Sprintf(" https://maps.google.com/?q=%.6f,%.6f", info.Latitude, info.Longitude)
+	if comment = strings.TrimSpace(comment); comment != "" {
+		text += " - " + comment
+	}
+	return text
+}
+
+// describeContact is the text stored and shown for a contact card, e.g.
+// "Jane Doe (+49 151 1234567, jane@example.com)"
+func describeContact(c VCardContact) string {
+	var details []string
+	for _, p := range c.Phones {
+		details = append(details, p.Number)
+	}
+	details = append(details, c.Emails...)
+
+	name := c.Name
+	if name == "" {
+		name = "Contact"
+	}
+	if len(details) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, strings.Join(details, ", "))
+}
+
+// parseVCard reads the name, organization, phone numbers and emails of a vCard
+func parseVCard(vcard string) VCardContact {
+	var c VCardContact
+	var structuredName string
+
+	// Long lines are folded onto lines starting with a space or tab
+	vcard = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(vcard)
+
+	for _, line := range strings.Split(vcard, "\n") {
+		line = strings.TrimRight(line, "\r")
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		params := strings.Split(line[:colon], ";")
+		value := line[colon+1:]
+
+		// Apple exports group properties as item1.TEL
+		name := strings.ToUpper(params[0])
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			name = name[dot+1:]
+		}
+
+		switch name {
+		case "FN":
+			c.Name = unescapeVCard(value)
+		case "N":
+			structuredName = strings.Join(strings.Fields(strings.ReplaceAll(unescapeVCard(value), ";", " ")), " ")
+		case "ORG":
+			c.Organization = strings.Trim(strings.ReplaceAll(unescapeVCard(value), ";", " "), " ")
+		case "EMAIL":
+			if value != "" {
+				c.Emails = append(c.Emails, unescapeVCard(value))
+			}
+		case "TEL":
+			phone := VCardPhone{Number: unescapeVCard(value)}
+			for _, param := range params[1:] {
+				key, val, _ := strings.Cut(param, "=")
+				switch strings.ToLower(key) {
+				case "waid":
+					phone.WAID = val
+				case "type":
+					if phone.Type == "" {
+						phone.Type = strings.ToLower(val)
+					}
+				}
+			}
+			if phone.Number != "" {
+				c.Phones = append(c.Phones, phone)
+			}
+		}
+	}
+	if c.Name == "" {
+		c.Name = structuredName
+	}
+	return c
+}
+
+func unescapeVCard(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+func escapeVCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(s)
+}
+
+// buildVCard writes a vCard for a name and phone numbers in E.164 digits
+func buildVCard(name string, phones []string) string {
+	var sb strings.Builder
+	sb.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	sb.WriteString(fmt.Sprintf("N:;%s;;;\n", escapeVCard(name)))
+	sb.WriteString(fmt.Sprintf("FN:%s\n", escapeVCard(name)))
+	for _, phone := range phones {
+		sb.WriteString(fmt.Sprintf("TEL;type=CELL;type=VOICE;waid=%s:+%s\n", phone, phone))
+	}
+	sb.WriteString("END:VCARD")
+	return sb.String()
+}
+
+// storeSentMessage files a message sent by the bridge, as WhatsApp doesn't echo
+// messages back to the device that sent them
+func storeSentMessage(client *whatsmeow.Client, messageStore *MessageStore, chat types.JID, resp whatsmeow.SendResponse, msg *waE2E.Message) {
+	chatJID := chat.String()
+
+	name := ""
+	if existing, err := messageStore.GetChat(chatJID, false); err == nil && existing != nil {
+		name = existing.Name
+	}
+	if name == "" && chat.Server == types.GroupServer {
+		if info, err := client.GetGroupInfo(context.Background(), chat); err == nil {
+			name = info.Name
+		}
+	}
+	if name == "" {
+		name = messageStore.GetSenderName(chatJID)
+	}
+	if err := messageStore.StoreChat(chatJID, name, resp.Timestamp); err != nil {
+		fmt.Printf("Failed to store chat: %v\n", err)
+		return
+	}
+
+	content := extractTextContent(msg)
+	media := extractMediaInfo(msg, resp.ID)
+	if content == "" && media != nil {
+		content = media.Caption
+	}
+	sender := senderKey(canonicalJID(client, messageStore, *client.Store.ID))
+	if err := messageStore.StoreMessage(resp.ID, chatJID, sender, content, resp.Timestamp, true, media); err != nil {
+		fmt.Printf("Failed to store sent message: %v\n", err)
+		return
+	}
+	storePollCreation(messageStore, resp.ID, chatJID, msg)
+}
+
+// respondSend writes the result of a send like /api/send does
+func respondSend(w http.ResponseWriter, success bool, message string) {
+	status := http.StatusOK
+	if !success {
+		status = http.StatusInternalServerError
+	}
+	respondJSON(w, status, SendMessageResponse{Success: success, Message: message})
+}
+
+// registerSharedMessageRoutes serves POST /api/send/location and POST /api/send/contact
+func registerSharedMessageRoutes(client *whatsmeow.Client, messageStore *MessageStore) {
+	http.HandleFunc("/api/send/location", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SendLocationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		if req.Latitude < -90 || req.Latitude > 90 || req.Longitude < -180 || req.Longitude > 180 {
+			http.Error(w, "Latitude must be between -90 and 90 and longitude between -180 and 180", http.StatusBadRequest)
+			return
+		}
+
+		loc := &waE2E.LocationMessage{
+			DegreesLatitude:  proto.Float64(req.Latitude),
+			DegreesLongitude: proto.Float64(req.Longitude),
+		}
+		if req.Name != "" {
+			loc.Name = proto.String(req.Name)
+		}
+		if req.Address != "" {
+			loc.Address = proto.String(req.Address)
+		}
+		enqueuePrepared(w, r, client, messageStore, req.Recipient, &waE2E.Message{LocationMessage: loc})
+	})
+
+	http.HandleFunc("/api/send/contact", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SendContactCardRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" || len(req.PhoneNumbers) == 0 {
+			http.Error(w, "Recipient and phone numbers are required", http.StatusBadRequest)
+			return
+		}
+
+		phones := make([]string, 0, len(req.PhoneNumbers))
+		for _, input := range req.PhoneNumbers {
+			phone, err := normalizePhoneNumber(input)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid phone number %q: %v", input, err))
+				return
+			}
+			phones = append(phones, phone)
+		}
+
+		// Without a name, use the one we know for the first number
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = messageStore.GetSenderName(phones[0])
+			if name == phones[0] {
+				name = "+" + phones[0]
+			}
+		}
+
+		card := &waE2E.ContactMessage{
+			DisplayName: proto.String(name),
+			Vcard:       proto.String(buildVCard(name, phones)),
+		}
+		enqueuePrepared(w, r, client, messageStore, req.Recipient, &waE2E.Message{ContactMessage: card})
+	})
+}
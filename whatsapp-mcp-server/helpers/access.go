@@ -0,0 +1,106 @@
+package helpers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// The chat profile limits which chats the read tools show the model. The bridge
+// enforces it: every request carries the profile in the X-Chat-Scope header,
+// next to the API key of WHATSAPP_API_KEY, whose own scope the bridge applies as
+// well. The tools also check chat JIDs themselves, so a hidden chat is refused
+// before the bridge is asked and filtered out of what it returns.
+
+// chatProfile holds the visibility rules of this server
+type chatProfile struct {
+	Allow      []string `json:"allow,omitempty"`
+	Deny       []string `json:"deny,omitempty"`
+	GroupsOnly bool     `json:"groups_only,omitempty"`
+	Labels     []string `json:"labels,omitempty"`
+}
+
+var (
+	profile = loadChatProfile()
+	apiKey  = ReadEnv("WHATSAPP_API_KEY", "")
+)
+
+// loadChatProfile reads WHATSAPP_VISIBLE_CHATS and WHATSAPP_HIDDEN_CHATS (comma
+// separated phone numbers or JIDs), WHATSAPP_GROUPS_ONLY and
+// WHATSAPP_VISIBLE_LABELS (comma separated label names)
+func loadChatProfile() chatProfile {
+	return chatProfile{
+		Allow:      splitList(ReadEnv("WHATSAPP_VISIBLE_CHATS", "")),
+		Deny:       splitList(ReadEnv("WHATSAPP_HIDDEN_CHATS", "")),
+		GroupsOnly: envFlag("WHATSAPP_GROUPS_ONLY"),
+		Labels:     splitList(ReadEnv("WHATSAPP_VISIBLE_LABELS", "")),
+	}
+}
+
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (p chatProfile) empty() bool {
+	return len(p.Allow) == 0 && len(p.Deny) == 0 && !p.GroupsOnly && len(p.Labels) == 0
+}
+
+// hides reports whether the profile hides a chat. Labels are only known to the
+// bridge, so a chat that could be visible by its labels is left to it.
+func (p chatProfile) hides(chatJID string) bool {
+	if chatJID == "" {
+		return false
+	}
+	jid := normalizeRecipient(chatJID)
+	for _, entry := range p.Deny {
+		if normalizeRecipient(entry) == jid {
+			return true
+		}
+	}
+	if p.GroupsOnly && !strings.HasSuffix(jid, "@g.us") {
+		return true
+	}
+	if len(p.Allow) == 0 || len(p.Labels) > 0 {
+		return false
+	}
+	for _, entry := range p.Allow {
+		if normalizeRecipient(entry) == jid {
+			return false
+		}
+	}
+	// The bridge also knows a person's chat by LID, which the allow list
+	// names by phone number
+	return !strings.HasSuffix(jid, "@lid")
+}
+
+// visibleOnly drops the items whose key names a chat the profile hides
+func visibleOnly(items []map[string]any, key string) []map[string]any {
+	visible := []map[string]any{}
+	for _, item := range items {
+		if jid, _ := item[key].(string); !profile.hides(jid) {
+			visible = append(visible, item)
+		}
+	}
+	return visible
+}
+
+func hiddenChatError(chatJID string) string {
+	return "chat " + chatJID + " is not visible to this server"
+}
+
+// setAccessHeaders adds the API key and the chat profile to a bridge request
+func setAccessHeaders(req *http.Request) {
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	if !profile.empty() {
+		scope, _ := json.Marshal(profile)
+		req.Header.Set("X-Chat-Scope", string(scope))
+	}
+}
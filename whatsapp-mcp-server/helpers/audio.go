@@ -8,16 +8,146 @@ import (
 	"strings"
 )
 
+// WhatsApp voice messages usually use:
+//
+//	bitrate  → 24k–32k is very common and good quality/size balance
+//	sample rate → 48000 Hz (Opus native)
+const (
+	defaultAudioBitrate    = 32000
+	defaultAudioSampleRate = 48000
+)
+
+// AudioOptions tunes the Opus encoding of a voice message. Zero values fall
+// back to the defaults above.
+type AudioOptions struct {
+	Bitrate    int // bits per second
+	SampleRate int // Hz, one of the rates Opus supports
+}
+
+func (o AudioOptions) withDefaults() (AudioOptions, error) {
+	if o.Bitrate == 0 {
+		o.Bitrate = defaultAudioBitrate
+	}
+	if o.SampleRate == 0 {
+		o.SampleRate = defaultAudioSampleRate
+	}
+	if o.Bitrate < 6000 || o.Bitrate > 510000 {
+		return o, fmt.Errorf("bitrate must be between 6 and 510 kbps, got %d bps", o.Bitrate)
+	}
+	switch o.SampleRate {
+	case 8000, 12000, 16000, 24000, 48000:
+	default:
+		return o, fmt.Errorf("sample rate must be 8000, 12000, 16000, 24000 or 48000 Hz, got %d", o.SampleRate)
+	}
+	return o, nil
+}
+
+// Transcoder converts an audio file into an Opus stream in an Ogg container.
+type Transcoder interface {
+	// Name identifies the backend in errors and in AUDIO_TRANSCODER.
+	Name() string
+	// Formats lists the input extensions the backend accepts, or "*" for any.
+	Formats() []string
+	// Available reports whether the backend can run on this machine.
+	Available() bool
+	Convert(inputFile, outputFile string, opts AudioOptions) error
+}
+
+// transcoders are tried in order; ffmpeg handles every format when it is
+// installed, the native encoder covers uncompressed audio without it.
+var transcoders = []Transcoder{ffmpegTranscoder{}, nativeTranscoder{}}
+
+// TranscoderFor picks the backend that will convert inputFile. The
+// AUDIO_TRANSCODER env var (auto, ffmpeg or native) restricts the choice.
+func TranscoderFor(inputFile string) (Transcoder, error) {
+	ext := strings.ToLower(filepath.Ext(inputFile))
+	want := strings.ToLower(ReadEnv("AUDIO_TRANSCODER", "auto"))
+
+	for _, t := range transcoders {
+		if want != "auto" && want != t.Name() {
+			continue
+		}
+		if t.Available() && supportsFormat(t, ext) {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no audio transcoder can convert %q files (AUDIO_TRANSCODER=%s); available: %s",
+		ext, want, TranscoderCapabilities())
+}
+
+// TranscoderCapabilities describes every backend and what it accepts.
+func TranscoderCapabilities() string {
+	parts := make([]string, 0, len(transcoders))
+	for _, t := range transcoders {
+		status := "installed"
+		if !t.Available() {
+			status = "not installed"
+		}
+		formats := strings.Join(t.Formats(), ", ")
+		if formats == "*" {
+			formats = "any format"
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s; %s)", t.Name(), status, formats))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func supportsFormat(t Transcoder, ext string) bool {
+	for _, f := range t.Formats() {
+		if f == "*" || f == ext {
+			return true
+		}
+	}
+	return false
+}
+
 // ConvertToOpusOggTemp – creates a temporary .ogg file in Opus format
-// Uses reasonable defaults for WhatsApp voice messages
-func ConvertToOpusOggTemp(inputFile string) (string, error) {
-	// WhatsApp voice messages usually use:
-	//   bitrate  → 24k–32k is very common and good quality/size balance
-	//   sample rate → 48000 Hz (Opus native)
-	const defaultBitrate = "32k"
-	const defaultSampleRate = 48000
-
-	return ConvertToOpusOggTempWithParams(inputFile, defaultBitrate, defaultSampleRate)
+// using the first transcoder that can handle the input.
+func ConvertToOpusOggTemp(inputFile string, opts AudioOptions) (string, error) {
+	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+		return "", fmt.Errorf("input file not found: %s", inputFile)
+	}
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return "", err
+	}
+	t, err := TranscoderFor(inputFile)
+	if err != nil {
+		return "", err
+	}
+
+	// Create a temporary file
+	tempFile, err := os.CreateTemp("", "audio-*.ogg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+	if err := tempFile.Close(); err != nil {
+		return "", err
+	} // Close it so the backend can write to the path
+
+	if err := t.Convert(inputFile, tempPath, opts); err != nil {
+		// Clean up on failure
+		_ = os.Remove(tempPath)
+		return "", fmt.Errorf("%s: %w", t.Name(), err)
+	}
+	return tempPath, nil
+}
+
+type ffmpegTranscoder struct{}
+
+func (ffmpegTranscoder) Name() string      { return "ffmpeg" }
+func (ffmpegTranscoder) Formats() []string { return []string{"*"} }
+
+func (ffmpegTranscoder) Available() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+func (ffmpegTranscoder) Convert(inputFile, outputFile string, opts AudioOptions) error {
+	bitrate := fmt.Sprintf("%dk", opts.Bitrate/1000)
+	_, err := ConvertToOpusOgg(inputFile, outputFile, bitrate, opts.SampleRate)
+	return err
 }
 
 // ConvertToOpusOgg converts an audio file to Opus format in an Ogg container.
@@ -63,30 +193,3 @@ func ConvertToOpusOgg(inputFile string, outputFile string, bitrate string, sampl
 
 	return outputFile, nil
 }
-
-// ConvertToOpusOggTempWithParams converts audio to a temporary .ogg file.
-func ConvertToOpusOggTempWithParams(inputFile string, bitrate string, sampleRate int) (string, error) {
-	// Create a temporary file
-	tempFile, err := os.CreateTemp("", "audio-*.ogg")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
-	}
-	tempPath := tempFile.Name()
-	err = tempFile.Close()
-	if err != nil {
-		return "", err
-	} // Close it so ffmpeg can write to the path
-
-	// Convert the audio
-	result, err := ConvertToOpusOgg(inputFile, tempPath, bitrate, sampleRate)
-	if err != nil {
-		// Clean up on failure
-		err := os.Remove(tempPath)
-		if err != nil {
-			return "", err
-		}
-		return "", err
-	}
-
-	return result, nil
-}
@@ -0,0 +1,72 @@
+//go:build ffmpeg
+
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os/exec"
+	"testing"
+
+	"whatsapp-mcp-server/opus"
+)
+
+// Run with -tags ffmpeg to have a real Opus decoder play back what the native
+// encoder writes.
+
+// goertzel returns the power of freq in x
+func goertzel(x []float64, rate, freq float64) float64 {
+	w := 2 * math.Pi * freq / rate
+	var s1, s2 float64
+	for _, v := range x {
+		s1, s2 = v+2*math.Cos(w)*s1-s2, s1
+	}
+	return s1*s1 + s2*s2 - 2*math.Cos(w)*s1*s2
+}
+
+func TestNativeConvertDecodes(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+	for _, sampleRate := range []int{8000, 16000, 24000, 48000} {
+		const samples = 48000 + 500
+		out := nativeConvert(t, samples, AudioOptions{SampleRate: sampleRate})
+
+		cmd := exec.Command("ffmpeg", "-v", "error", "-i", out, "-f", "s16le", "-ac", "1", "-ar", "48000", "-")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		raw, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%d Hz: ffmpeg: %v: %s", sampleRate, err, stderr.String())
+		}
+		if stderr.Len() > 0 {
+			t.Errorf("%d Hz: ffmpeg complained: %s", sampleRate, stderr.String())
+		}
+
+		decoded := make([]float64, len(raw)/2)
+		for i := range decoded {
+			decoded[i] = float64(int16(binary.LittleEndian.Uint16(raw[2*i:]))) / 32768
+		}
+		// The pre-skip and the final granule trim the stream to the input
+		if diff := len(decoded) - samples; diff < -opus.FrameSize || diff > opus.FrameSize {
+			t.Errorf("%d Hz: decoded %d samples, want about %d", sampleRate, len(decoded), samples)
+		}
+
+		var sum float64
+		for _, v := range decoded {
+			sum += v * v
+		}
+		// The input is a sine of amplitude 8000/32768
+		rms := math.Sqrt(sum / float64(len(decoded)))
+		if want := 8000.0 / 32768 / math.Sqrt2; rms < want/2 || rms > want*2 {
+			t.Errorf("%d Hz: RMS %.3f, want about %.3f", sampleRate, rms, want)
+		}
+		tone := goertzel(decoded, opusRate, 440)
+		for _, f := range []float64{220, 880, 1320} {
+			if p := goertzel(decoded, opusRate, f); p*100 > tone {
+				t.Errorf("%d Hz: %v Hz is within 20 dB of the 440 Hz tone", sampleRate, f)
+			}
+		}
+	}
+}
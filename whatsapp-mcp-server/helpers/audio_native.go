@@ -0,0 +1,247 @@
+package helpers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"whatsapp-mcp-server/opus"
+)
+
+// nativeTranscoder encodes uncompressed audio with the built-in Opus
+// encoder, so voice notes work on machines without ffmpeg. Raw .pcm files
+// are read as signed 16-bit little-endian mono at 48 kHz.
+type nativeTranscoder struct{}
+
+const (
+	opusRate      = 48000
+	rawPCMRate    = 48000
+	sincZeros     = 16  // zero crossings on each side of the resampling kernel
+	sincPhases    = 256 // kernel table resolution between input samples
+	waveFormatPCM = 1
+	waveFormatFlt = 3
+	waveFormatExt = 0xFFFE
+)
+
+func (nativeTranscoder) Name() string      { return "native" }
+func (nativeTranscoder) Formats() []string { return []string{".wav", ".pcm"} }
+func (nativeTranscoder) Available() bool   { return true }
+
+func (nativeTranscoder) Convert(inputFile, outputFile string, opts AudioOptions) error {
+	var (
+		samples []float64
+		rate    int
+		err     error
+	)
+	switch strings.ToLower(filepath.Ext(inputFile)) {
+	case ".wav":
+		samples, rate, err = readWAV(inputFile)
+	case ".pcm":
+		samples, rate, err = readRawPCM(inputFile)
+	default:
+		err = fmt.Errorf("unsupported input format: %s", filepath.Ext(inputFile))
+	}
+	if err != nil {
+		return err
+	}
+	if rate != opusRate {
+		samples = resample(samples, rate, opusRate)
+	}
+
+	enc, err := opus.NewEncoder(opts.SampleRate, opts.Bitrate)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer out.Close()
+	bw := bufio.NewWriter(out)
+
+	ogg, err := opus.NewOggWriter(bw, rand.Uint32(), rate)
+	if err != nil {
+		return err
+	}
+
+	// The decoder lags the input by PreSkip samples, so keep feeding silence
+	// until every input sample has come out the other end.
+	total := len(samples) + opus.PreSkip
+	frame := make([]float32, opus.FrameSize)
+	for pos := 0; pos < total; pos += opus.FrameSize {
+		for i := range frame {
+			frame[i] = 0
+			if j := pos + i; j < len(samples) {
+				frame[i] = float32(max(-1, min(1, samples[j])))
+			}
+		}
+		packet, err := enc.Encode(frame)
+		if err != nil {
+			return err
+		}
+		if err := ogg.WritePacket(packet, min(opus.FrameSize, total-pos)); err != nil {
+			return err
+		}
+	}
+	if err := ogg.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// readWAV decodes a RIFF/WAVE file into mono samples in [-1, 1].
+func readWAV(path string) ([]float64, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a RIFF/WAVE file")
+	}
+
+	var (
+		format, channels, bitsPerSample int
+		rate                            int
+		pcm                             []byte
+		haveFmt                         bool
+	)
+	for p := 12; p+8 <= len(data); {
+		id := string(data[p : p+4])
+		size := int(binary.LittleEndian.Uint32(data[p+4:]))
+		body := data[p+8 : min(len(data), p+8+size)]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, errors.New("truncated WAV fmt chunk")
+			}
+			format = int(binary.LittleEndian.Uint16(body[0:]))
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			rate = int(binary.LittleEndian.Uint32(body[4:]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(body[14:]))
+			if format == waveFormatExt && len(body) >= 26 {
+				// The sub-format GUID starts with the real format tag.
+				format = int(binary.LittleEndian.Uint16(body[24:]))
+			}
+			haveFmt = true
+		case "data":
+			pcm = body
+		}
+		p += 8 + size + size&1 // chunks are word aligned
+	}
+	if !haveFmt || pcm == nil {
+		return nil, 0, errors.New("WAV file has no fmt or data chunk")
+	}
+	if channels < 1 || rate <= 0 {
+		return nil, 0, fmt.Errorf("invalid WAV header: %d channels at %d Hz", channels, rate)
+	}
+
+	var sample func([]byte) float64
+	switch {
+	case format == waveFormatPCM && bitsPerSample == 8:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format == waveFormatPCM && bitsPerSample == 16:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case format == waveFormatPCM && bitsPerSample == 24:
+		sample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format == waveFormatPCM && bitsPerSample == 32:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == waveFormatFlt && bitsPerSample == 32:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	case format == waveFormatFlt && bitsPerSample == 64:
+		sample = func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }
+	default:
+		return nil, 0, fmt.Errorf("unsupported WAV encoding: format %d, %d bits", format, bitsPerSample)
+	}
+
+	width := bitsPerSample / 8
+	blockAlign := width * channels
+	out := make([]float64, len(pcm)/blockAlign)
+	for i := range out {
+		sum := 0.0
+		for c := range channels {
+			off := i*blockAlign + c*width
+			sum += sample(pcm[off : off+width])
+		}
+		out[i] = sum / float64(channels)
+	}
+	return out, rate, nil
+}
+
+// readRawPCM reads headerless signed 16-bit little-endian mono audio.
+func readRawPCM(path string) ([]float64, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]float64, len(data)/2)
+	for i := range out {
+		out[i] = float64(int16(binary.LittleEndian.Uint16(data[2*i:]))) / 32768
+	}
+	return out, rawPCMRate, nil
+}
+
+// sincTable holds one side of a Blackman-windowed sinc kernel sampled at
+// sincPhases points per input sample.
+var sincTable = func() []float64 {
+	n := sincZeros * sincPhases
+	t := make([]float64, n+2)
+	for i := 0; i <= n; i++ {
+		x := float64(i) / sincPhases
+		v := 1.0
+		if i != 0 {
+			v = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		w := float64(i)/float64(n)*0.5 + 0.5 // position in the full window
+		v *= 0.42 - 0.5*math.Cos(2*math.Pi*w) + 0.08*math.Cos(4*math.Pi*w)
+		t[i] = v
+	}
+	return t
+}()
+
+// resample converts x from rate "from" to rate "to" with a band-limited
+// interpolator, lowering the cutoff when downsampling to avoid aliasing.
+func resample(x []float64, from, to int) []float64 {
+	if len(x) == 0 {
+		return x
+	}
+	step := float64(from) / float64(to)
+	cutoff := min(1, 1/step)
+	out := make([]float64, int(float64(len(x))/step))
+	span := int(math.Ceil(sincZeros / cutoff))
+
+	kernel := func(d float64) float64 {
+		p := math.Abs(d) * cutoff * sincPhases
+		i := int(p)
+		if i >= sincZeros*sincPhases {
+			return 0
+		}
+		f := p - float64(i)
+		return sincTable[i]*(1-f) + sincTable[i+1]*f
+	}
+
+	for i := range out {
+		t := float64(i) * step
+		c := int(t)
+		sum := 0.0
+		for j := c - span + 1; j <= c+span; j++ {
+			if j < 0 || j >= len(x) {
+				continue
+			}
+			sum += x[j] * kernel(t-float64(j))
+		}
+		out[i] = sum * cutoff
+	}
+	return out
+}
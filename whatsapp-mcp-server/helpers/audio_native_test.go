@@ -0,0 +1,231 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"whatsapp-mcp-server/opus"
+)
+
+// writeTestWAV writes n samples of a 440 Hz tone as 16-bit mono PCM
+func writeTestWAV(t *testing.T, path string, rate, n int) {
+	t.Helper()
+	var data bytes.Buffer
+	for i := range n {
+		v := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/float64(rate)))
+		binary.Write(&data, binary.LittleEndian, v)
+	}
+
+	var wav bytes.Buffer
+	wav.WriteString("RIFF")
+	binary.Write(&wav, binary.LittleEndian, uint32(36+data.Len()))
+	wav.WriteString("WAVEfmt ")
+	binary.Write(&wav, binary.LittleEndian, uint32(16))
+	binary.Write(&wav, binary.LittleEndian, uint16(waveFormatPCM))
+	binary.Write(&wav, binary.LittleEndian, uint16(1))
+	binary.Write(&wav, binary.LittleEndian, uint32(rate))
+	binary.Write(&wav, binary.LittleEndian, uint32(rate*2))
+	binary.Write(&wav, binary.LittleEndian, uint16(2))
+	binary.Write(&wav, binary.LittleEndian, uint16(16))
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(data.Len()))
+	wav.Write(data.Bytes())
+
+	if err := os.WriteFile(path, wav.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type oggPage struct {
+	flags   byte
+	granule uint64
+	seq     uint32
+	packets [][]byte // the packets that end on this page
+}
+
+// readOggPages splits an Ogg stream into pages, checking the CRC of each
+func readOggPages(t *testing.T, data []byte) []oggPage {
+	t.Helper()
+	var (
+		pages   []oggPage
+		partial []byte
+	)
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("page %d: bad capture pattern", len(pages))
+		}
+		nsegs := int(data[26])
+		size := 27 + nsegs
+		for _, s := range data[27 : 27+nsegs] {
+			size += int(s)
+		}
+		page := append([]byte(nil), data[:size]...)
+		want := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if got := oggCRC(page); got != want {
+			t.Fatalf("page %d: CRC %08x, want %08x", len(pages), want, got)
+		}
+		p := oggPage{
+			flags:   page[5],
+			granule: binary.LittleEndian.Uint64(page[6:]),
+			seq:     binary.LittleEndian.Uint32(page[18:]),
+		}
+		body := page[27+nsegs:]
+		for _, s := range page[27 : 27+nsegs] {
+			partial = append(partial, body[:s]...)
+			body = body[s:]
+			if s < 255 {
+				p.packets = append(p.packets, partial)
+				partial = nil
+			}
+		}
+		pages = append(pages, p)
+		data = data[size:]
+	}
+	return pages
+}
+
+// oggCRC is the CRC-32 of RFC 3533: polynomial 0x04C11DB7, no reflection
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// nativeConvert writes a tone of the given length and encodes it with opts,
+// checked and defaulted the way ConvertToOpusOggTemp does it
+func nativeConvert(t *testing.T, samples int, opts AudioOptions) string {
+	t.Helper()
+	opts, err := opts.withDefaults()
+	if err != nil {
+		t.Fatalf("options: %v", err)
+	}
+	dir := t.TempDir()
+	in := filepath.Join(dir, "tone.wav")
+	out := filepath.Join(dir, "tone.ogg")
+	writeTestWAV(t, in, opusRate, samples)
+	if err := (nativeTranscoder{}).Convert(in, out, opts); err != nil {
+		t.Fatalf("Convert: %v", err)
+	}
+	return out
+}
+
+func TestNativeConvertGranule(t *testing.T) {
+	const samples = 48000*2 + 123 // not a whole number of frames
+	data, err := os.ReadFile(nativeConvert(t, samples, AudioOptions{Bitrate: 32000, SampleRate: opusRate}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := readOggPages(t, data)
+	if len(pages) < 3 {
+		t.Fatalf("got %d pages, want the two headers and audio", len(pages))
+	}
+	if pages[0].flags != 0x02 || pages[0].granule != 0 || pages[1].granule != 0 {
+		t.Errorf("header pages: flags %#x, granules %d and %d", pages[0].flags, pages[0].granule, pages[1].granule)
+	}
+	for i, p := range pages {
+		if p.seq != uint32(i) {
+			t.Errorf("page %d has sequence number %d", i, p.seq)
+		}
+	}
+
+	last := pages[len(pages)-1]
+	if last.flags&0x04 == 0 {
+		t.Error("last page lacks the end-of-stream flag")
+	}
+	// Players play granule minus pre-skip samples, which must be the input
+	if want := uint64(samples + opus.PreSkip); last.granule != want {
+		t.Errorf("final granule %d, want %d (%d samples + %d pre-skip)", last.granule, want, samples, opus.PreSkip)
+	}
+}
+
+// Every packet must be a single 20 ms mono CELT frame (RFC 6716 section 3.1)
+// in the bandwidth the sample rate asks for, and the same size at a
+// constant bitrate.
+func TestNativeConvertPackets(t *testing.T) {
+	tests := []struct {
+		sampleRate int
+		bitrate    int
+		bandwidth  byte // TOC config of a 20 ms CELT frame
+	}{
+		{8000, 16000, 19},   // narrowband
+		{16000, 24000, 23},  // wideband
+		{24000, 32000, 27},  // super-wideband
+		{48000, 32000, 31},  // fullband
+		{48000, 128000, 31}, // fullband
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d Hz %d bps", tt.sampleRate, tt.bitrate), func(t *testing.T) {
+			const samples = 48000 + 500
+			data, err := os.ReadFile(nativeConvert(t, samples, AudioOptions{Bitrate: tt.bitrate, SampleRate: tt.sampleRate}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages := readOggPages(t, data)
+			if len(pages) < 3 || len(pages[0].packets) != 1 || len(pages[1].packets) != 1 {
+				t.Fatalf("the headers must be alone on the first two of %d pages", len(pages))
+			}
+
+			var packets [][]byte
+			for _, p := range pages[2:] {
+				packets = append(packets, p.packets...)
+			}
+			if want := (samples + opus.PreSkip + opus.FrameSize - 1) / opus.FrameSize; len(packets) != want {
+				t.Errorf("got %d packets, want %d", len(packets), want)
+			}
+			size := tt.bitrate * opus.FrameSize / opusRate / 8
+			for i, p := range packets {
+				if len(p) == 0 {
+					t.Fatalf("packet %d is empty", i)
+				}
+				toc := p[0]
+				if config := toc >> 3; config != tt.bandwidth {
+					t.Errorf("packet %d: TOC config %d, want %d", i, config, tt.bandwidth)
+				}
+				if toc&0x04 != 0 {
+					t.Errorf("packet %d: stereo flag set", i)
+				}
+				if code := toc & 0x03; code != 0 {
+					t.Errorf("packet %d: frame count code %d, want a single frame", i, code)
+				}
+				if len(p)-1 > 1275 {
+					t.Errorf("packet %d: %d byte frame is longer than Opus allows", i, len(p)-1)
+				}
+				if len(p) != size {
+					t.Errorf("packet %d: %d bytes, want %d at %d bps", i, len(p), size, tt.bitrate)
+				}
+			}
+		})
+	}
+}
+
+func TestAudioOptionsDefaults(t *testing.T) {
+	got, err := AudioOptions{}.withDefaults()
+	if err != nil || got.Bitrate != 32000 || got.SampleRate != 48000 {
+		t.Errorf("zero options: got %+v, %v", got, err)
+	}
+	for _, opts := range []AudioOptions{
+		{Bitrate: 32}, // bits, not kilobits, per second
+		{Bitrate: 600000},
+		{SampleRate: 44100},
+	} {
+		if _, err := opts.withDefaults(); err == nil {
+			t.Errorf("%+v accepted, want an error", opts)
+		}
+	}
+}
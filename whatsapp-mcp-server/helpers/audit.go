@@ -0,0 +1,186 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Every tool call is appended to an audit log as a JSON line: the tool, its
+// arguments with message text and the like redacted, the session and client
+// that called it, whether it succeeded and how long it took. The bridge keeps
+// its own log of the calls that reach it.
+
+// auditEntry is one line of the audit log
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Tool      string    `json:"tool"`
+	Arguments any       `json:"arguments,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+}
+
+// auditLog appends entries to a file that is only ever added to
+type auditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	redacted map[string]bool
+}
+
+// audit is opened when the server starts, nil when disabled
+var audit *auditLog
+
+// defaultAuditLog keeps the log in the store directory, out of the checkout
+const defaultAuditLog = "store/whatsapp-mcp-audit.jsonl"
+
+// openAuditLog opens the file of WHATSAPP_AUDIT_LOG, "off" to disable, and
+// reads the argument names to redact from WHATSAPP_AUDIT_REDACT, a comma
+// separated list or "none"
+func openAuditLog() *auditLog {
+	path := ReadEnv("WHATSAPP_AUDIT_LOG", defaultAuditLog)
+	if path == "" || path == "off" {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	var file *os.File
+	if err == nil {
+		file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	}
+	if err != nil {
+		slog.Error("Failed to open audit log, tool calls are not audited", "path", path, "error", err)
+		return nil
+	}
+
+	redacted := make(map[string]bool)
+	list := ReadEnv("WHATSAPP_AUDIT_REDACT", "message,caption,question,options,variables")
+	if strings.TrimSpace(list) != "none" {
+		for _, name := range splitList(list) {
+			redacted[strings.ToLower(name)] = true
+		}
+	}
+	return &auditLog{file: file, redacted: redacted}
+}
+
+// auditMiddleware records every tool call once it has been answered
+func auditMiddleware(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		call, ok := req.(*mcp.CallToolRequest)
+		if !ok || audit == nil {
+			return next(ctx, method, req)
+		}
+		start := time.Now()
+		res, err := next(ctx, method, req)
+		audit.record(call, res, err, start)
+		return res, err
+	}
+}
+
+func (a *auditLog) record(call *mcp.CallToolRequest, res mcp.Result, err error, start time.Time) {
+	entry := auditEntry{
+		Time:      start,
+		Outcome:   "ok",
+		LatencyMS: time.Since(start).Milliseconds(),
+	}
+	if call.Params != nil {
+		entry.Tool = call.Params.Name
+		var args any
+		if json.Unmarshal(call.Params.Arguments, &args) == nil {
+			entry.Arguments = a.redact(args)
+		}
+	}
+	if call.Session != nil {
+		entry.Session = call.Session.ID()
+		if params := call.Session.InitializeParams(); params != nil && params.ClientInfo != nil {
+			entry.Client = strings.TrimSpace(params.ClientInfo.Name + " " + params.ClientInfo.Version)
+		}
+	}
+	if msg := failure(res, err); msg != "" {
+		entry.Outcome = "error"
+		entry.Error = msg
+	}
+
+	line, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		slog.Warn("Failed to encode audit entry", "tool", entry.Tool, "error", jsonErr)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		slog.Warn("Failed to write audit log", "tool", entry.Tool, "error", err)
+	}
+}
+
+// redact replaces the values of redacted arguments, also inside lists and objects
+func (a *auditLog) redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if !a.redacted[strings.ToLower(key)] {
+				v[key] = a.redact(val)
+				continue
+			}
+			if s, ok := val.(string); ok {
+				v[key] = fmt.Sprintf("[redacted %d chars]", len(s))
+			} else if val != nil {
+				v[key] = "[redacted]"
+			}
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = a.redact(val)
+		}
+	}
+	return v
+}
+
+// failure returns why a tool call failed, or "" if it succeeded. Tools report
+// errors as error results or as structured output with success false.
+func failure(res mcp.Result, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	result, ok := res.(*mcp.CallToolResult)
+	if !ok || result == nil {
+		return ""
+	}
+	if result.IsError {
+		for _, content := range result.Content {
+			if text, ok := content.(*mcp.TextContent); ok {
+				return text.Text
+			}
+		}
+		return "error"
+	}
+
+	data, jsonErr := json.Marshal(result.StructuredContent)
+	if jsonErr != nil {
+		return ""
+	}
+	var out struct {
+		Success *bool  `json:"success"`
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &out) != nil || out.Success == nil || *out.Success {
+		return ""
+	}
+	if out.Error != "" {
+		return out.Error
+	}
+	if out.Message != "" {
+		return out.Message
+	}
+	return "error"
+}
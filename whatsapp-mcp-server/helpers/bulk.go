@@ -0,0 +1,181 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Bulk sends run in the bridge: it renders the template for every recipient and
+// queues them one by one within the send limits, so a large job takes a while.
+// get_bulk_send_status follows its progress.
+
+// registerBulkTools adds the bulk send tools to the server
+func registerBulkTools(server *mcp.Server) {
+	mcp.AddTool[startBulkSendInput, any](server, &mcp.Tool{
+		Name:        "start_bulk_send",
+		Description: "Send a personalized message to many people, one chat each. The message is a template: {{name}}, {{first_name}} and {{phone}} come from contacts, other {{variables}} from the job or the recipient, and {{var|default}} gives a fallback. Recipients a variable is missing for are skipped. Use dry_run to preview every rendered message first. Returns the job id for get_bulk_send_status.",
+	}, startBulkSendHandler)
+
+	mcp.AddTool[bulkJobInput, any](server, &mcp.Tool{
+		Name:        "get_bulk_send_status",
+		Description: "Get the progress of a bulk send: counts by status and, per recipient, the rendered message and whether it is pending, queued, sending, sent, failed or skipped, with the error.",
+	}, getBulkSendStatusHandler)
+
+	mcp.AddTool[bulkJobInput, any](server, &mcp.Tool{
+		Name:        "cancel_bulk_send",
+		Description: "Stop a running bulk send. Recipients already queued are still sent.",
+	}, cancelBulkSendHandler)
+
+	mcp.AddTool[saveRecipientListInput, any](server, &mcp.Tool{
+		Name:        "save_recipient_list",
+		Description: "Save a named list of recipients, with optional variables each, to use as the list of start_bulk_send. Saving under an existing name replaces the list.",
+	}, saveRecipientListHandler)
+}
+
+type bulkRecipientInput struct {
+	Recipient string            `json:"recipient" jsonschema:"description:Phone number with country code or a JID"`
+	Variables map[string]string `json:"variables,omitempty" jsonschema:"description:Template variables for this recipient"`
+}
+
+type startBulkSendInput struct {
+	Recipients []bulkRecipientInput `json:"recipients,omitempty" jsonschema:"description:Recipients, each with optional template variables"`
+	List       string               `json:"list,omitempty" jsonschema:"description:Name of a saved recipient list to send to, besides recipients"`
+	Message    string               `json:"message,omitempty" jsonschema:"description:Message template, e.g. Hi {{first_name|there}}, your code is {{code}}"`
+	MediaPath  string               `json:"media_path,omitempty" jsonschema:"description:Absolute path to a file to send with the message as its caption"`
+	Variables  map[string]string    `json:"variables,omitempty" jsonschema:"description:Template variables for every recipient"`
+	DryRun     bool                 `json:"dry_run,omitempty" jsonschema:"description:Only render and check the messages, sending nothing"`
+}
+
+type bulkJobInput struct {
+	ID string `json:"id" jsonschema:"description:ID of the bulk send"`
+}
+
+type saveRecipientListInput struct {
+	Name       string               `json:"name" jsonschema:"description:Name of the list"`
+	Recipients []bulkRecipientInput `json:"recipients" jsonschema:"description:Recipients, each with optional template variables"`
+}
+
+func startBulkSendHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in startBulkSendInput,
+) (*mcp.CallToolResult, any, error) {
+	if len(in.Recipients) == 0 && in.List == "" {
+		return ErrResult("recipients or list is required"), nil, nil
+	}
+	if in.Message == "" && in.MediaPath == "" {
+		return ErrResult("message or media_path is required"), nil, nil
+	}
+
+	payload := map[string]any{
+		"recipients": in.Recipients,
+		"list":       in.List,
+		"message":    in.Message,
+		"media_path": in.MediaPath,
+		"variables":  in.Variables,
+		"dry_run":    in.DryRun,
+	}
+	// A preview sends nothing, so the policy only applies to real sends
+	if !in.DryRun {
+		var recipients []string
+		for _, r := range in.Recipients {
+			recipients = append(recipients, r.Recipient)
+		}
+		if in.List != "" {
+			list, err := listRecipients(in.List)
+			if err != nil {
+				return ErrResult(err.Error()), nil, nil
+			}
+			recipients = append(recipients, list...)
+		}
+		if res, out := guardSend(ctx, req, "/bulk", recipients, payload); res != nil {
+			return res, out, nil
+		}
+	}
+
+	data, err := callAPI(http.MethodPost, "/bulk", payload)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse bulk send response"), nil, nil
+	}
+	return OkResult(result), nil, nil
+}
+
+func getBulkSendStatusHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in bulkJobInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.ID == "" {
+		return ErrResult("id is required"), nil, nil
+	}
+
+	data, err := callAPI(http.MethodGet, "/bulk/"+url.PathEscape(in.ID), nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Job map[string]any `json:"job"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse bulk send response"), nil, nil
+	}
+	return OkResult(result.Job), nil, nil
+}
+
+func cancelBulkSendHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in bulkJobInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.ID == "" {
+		return ErrResult("id is required"), nil, nil
+	}
+
+	data, err := callAPI(http.MethodDelete, "/bulk/"+url.PathEscape(in.ID), nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse bulk send response"), nil, nil
+	}
+	return OkResult(result.Message), nil, nil
+}
+
+func saveRecipientListHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in saveRecipientListInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.Name == "" || len(in.Recipients) == 0 {
+		return ErrResult("name and recipients are required"), nil, nil
+	}
+
+	data, err := callAPI(http.MethodPut, "/bulk/lists/"+url.PathEscape(in.Name), map[string]any{
+		"recipients": in.Recipients,
+	})
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse recipient list response"), nil, nil
+	}
+	return OkResult(result.Message), nil, nil
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// maxNumberChecks mirrors the bridge's limit per check request
+const maxNumberChecks = 100
+
+// registerContactTools adds the contact lookup tools to the server
+func registerContactTools(server *mcp.Server) {
+	mcp.AddTool[checkWhatsAppNumbersInput, any](server, &mcp.Tool{
+		Name:        "check_whatsapp_numbers",
+		Description: "Check whether phone numbers are registered on WhatsApp and get their JIDs. Numbers need a country code and may contain spaces, dashes or a leading + or 00.",
+	}, checkWhatsAppNumbersHandler)
+}
+
+type checkWhatsAppNumbersInput struct {
+	PhoneNumbers []string `json:"phone_numbers" jsonschema:"description:Phone numbers with country code, e.g. +49 151 1234567"`
+}
+
+func checkWhatsAppNumbersHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in checkWhatsAppNumbersInput,
+) (*mcp.CallToolResult, any, error) {
+	if len(in.PhoneNumbers) == 0 {
+		return ErrResult("phone_numbers is required"), nil, nil
+	}
+
+	// Larger lists are checked in several requests
+	var results []map[string]any
+	for start := 0; start < len(in.PhoneNumbers); start += maxNumberChecks {
+		end := min(start+maxNumberChecks, len(in.PhoneNumbers))
+		payload := map[string]any{"phone_numbers": in.PhoneNumbers[start:end]}
+
+		data, err := callAPI(http.MethodPost, "/contacts/check", payload)
+		if err != nil {
+			return ErrResult(err.Error()), nil, nil
+		}
+
+		var result struct {
+			Results []map[string]any `json:"results"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return ErrResult("failed to parse check response"), nil, nil
+		}
+		results = append(results, result.Results...)
+	}
+
+	return OkResult(results), nil, nil
+}
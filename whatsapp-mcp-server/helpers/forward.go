@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"context"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerForwardTools adds the message forwarding tool to the server
+func registerForwardTools(server *mcp.Server) {
+	mcp.AddTool[forwardMessageInput, map[string]any](server, &mcp.Tool{
+		Name:        "forward_message",
+		Description: "Forward a stored message (text, media, document, location, contact card or poll) to one or more people or groups, marked as forwarded. Returns a result per recipient with the outbox ID of its send.",
+	}, forwardMessageHandler)
+}
+
+type forwardMessageInput struct {
+	ChatJID    string   `json:"chat_jid" jsonschema:"description:Chat JID of the message to forward"`
+	MessageID  string   `json:"message_id" jsonschema:"description:ID of the message to forward"`
+	Recipients []string `json:"recipients" jsonschema:"description:Phone numbers with country code or JIDs to forward to"`
+	Wait       bool     `json:"wait,omitempty" jsonschema:"description:Wait up to 20 seconds for the forwards to be delivered instead of returning once they are queued"`
+}
+
+func forwardMessageHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in forwardMessageInput,
+) (*mcp.CallToolResult, map[string]any, error) {
+	if in.ChatJID == "" || in.MessageID == "" || len(in.Recipients) == 0 {
+		return ErrResult("chat_jid, message_id and recipients are required"), map[string]any{
+			"success": false,
+			"error":   "chat_jid, message_id and recipients are required",
+		}, nil
+	}
+
+	if profile.hides(in.ChatJID) {
+		return ErrResult(hiddenChatError(in.ChatJID)), map[string]any{
+			"success": false,
+			"error":   hiddenChatError(in.ChatJID),
+		}, nil
+	}
+
+	return sendCall(ctx, req, sendPath("/forward", in.Wait), map[string]any{
+		"chat_jid":   in.ChatJID,
+		"message_id": in.MessageID,
+		"recipients": in.Recipients,
+	})
+}
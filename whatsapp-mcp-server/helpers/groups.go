@@ -0,0 +1,372 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerGroupTools adds the group management tools to the server
+func registerGroupTools(server *mcp.Server) {
+	mcp.AddTool[listGroupsInput, any](server, &mcp.Tool{
+		Name:        "list_groups",
+		Description: "List the WhatsApp groups this account is a member of.",
+	}, listGroupsHandler)
+
+	mcp.AddTool[groupInput, any](server, &mcp.Tool{
+		Name:        "get_group_info",
+		Description: "Get a WhatsApp group's name, description, owner and participants, including which participants are admins.",
+	}, getGroupInfoHandler)
+
+	mcp.AddTool[createGroupInput, any](server, &mcp.Tool{
+		Name:        "create_group",
+		Description: "Create a WhatsApp group with the given name and participants.",
+	}, createGroupHandler)
+
+	mcp.AddTool[updateGroupParticipantsInput, any](server, &mcp.Tool{
+		Name:        "update_group_participants",
+		Description: "Add, remove, promote to admin or demote participants of a WhatsApp group. Returns the result for each participant.",
+	}, updateGroupParticipantsHandler)
+
+	mcp.AddTool[setGroupSubjectInput, any](server, &mcp.Tool{
+		Name:        "set_group_subject",
+		Description: "Change the name (subject) of a WhatsApp group.",
+	}, setGroupSubjectHandler)
+
+	mcp.AddTool[setGroupDescriptionInput, any](server, &mcp.Tool{
+		Name:        "set_group_description",
+		Description: "Change the description of a WhatsApp group. An empty description removes it.",
+	}, setGroupDescriptionHandler)
+
+	mcp.AddTool[setGroupPhotoInput, any](server, &mcp.Tool{
+		Name:        "set_group_photo",
+		Description: "Change the photo of a WhatsApp group. The image is cropped to a square. An empty path removes the photo.",
+	}, setGroupPhotoHandler)
+
+	mcp.AddTool[groupInput, any](server, &mcp.Tool{
+		Name:        "leave_group",
+		Description: "Leave a WhatsApp group.",
+	}, leaveGroupHandler)
+
+	mcp.AddTool[getGroupInviteLinkInput, any](server, &mcp.Tool{
+		Name:        "get_group_invite_link",
+		Description: "Get the invite link of a WhatsApp group. With reset=true the current link is revoked and a new one is created. Requires admin rights.",
+	}, getGroupInviteLinkHandler)
+
+	mcp.AddTool[groupLinkInput, any](server, &mcp.Tool{
+		Name:        "preview_group_invite",
+		Description: "Show a WhatsApp group's name, description and participants from an invite link without joining it.",
+	}, previewGroupInviteHandler)
+
+	mcp.AddTool[groupLinkInput, any](server, &mcp.Tool{
+		Name:        "join_group",
+		Description: "Join a WhatsApp group with an invite link (https://chat.whatsapp.com/...) or its code.",
+	}, joinGroupHandler)
+
+	mcp.AddTool[groupInput, any](server, &mcp.Tool{
+		Name:        "list_group_requests",
+		Description: "List pending requests to join a WhatsApp group that has admin approval enabled.",
+	}, listGroupRequestsHandler)
+
+	mcp.AddTool[updateGroupRequestsInput, any](server, &mcp.Tool{
+		Name:        "update_group_requests",
+		Description: "Approve or reject pending requests to join a WhatsApp group. Returns the result for each participant.",
+	}, updateGroupRequestsHandler)
+}
+
+type listGroupsInput struct {
+	Query *string `json:"query,omitempty" jsonschema:"description:Only return groups whose name contains this text"`
+}
+
+type groupInput struct {
+	GroupJid string `json:"group_jid" jsonschema:"description:The group JID like 123@g.us"`
+}
+
+type createGroupInput struct {
+	Name         string   `json:"name" jsonschema:"description:Name of the new group"`
+	Participants []string `json:"participants" jsonschema:"description:Phone numbers with country code (no +) or JIDs to add"`
+}
+
+type updateGroupParticipantsInput struct {
+	GroupJid     string   `json:"group_jid" jsonschema:"description:The group JID like 123@g.us"`
+	Action       string   `json:"action" jsonschema:"enum:add|remove|promote|demote"`
+	Participants []string `json:"participants" jsonschema:"description:Phone numbers with country code (no +) or JIDs"`
+}
+
+type setGroupSubjectInput struct {
+	GroupJid string `json:"group_jid" jsonschema:"description:The group JID like 123@g.us"`
+	Name     string `json:"name" jsonschema:"description:New group name"`
+}
+
+type setGroupDescriptionInput struct {
+	GroupJid    string `json:"group_jid" jsonschema:"description:The group JID like 123@g.us"`
+	Description string `json:"description"`
+}
+
+type setGroupPhotoInput struct {
+	GroupJid  string `json:"group_jid" jsonschema:"description:The group JID like 123@g.us"`
+	ImagePath string `json:"image_path" jsonschema:"description:Absolute path to a JPEG, PNG or GIF image"`
+}
+
+type getGroupInviteLinkInput struct {
+	GroupJid string `json:"group_jid" jsonschema:"description:The group JID like 123@g.us"`
+	Reset    bool   `json:"reset,omitempty" jsonschema:"description:Revoke the current link and create a new one"`
+}
+
+type groupLinkInput struct {
+	Link string `json:"link" jsonschema:"description:Invite link like https://chat.whatsapp.com/AbC123 or just the code"`
+}
+
+type updateGroupRequestsInput struct {
+	GroupJid     string   `json:"group_jid" jsonschema:"description:The group JID like 123@g.us"`
+	Action       string   `json:"action" jsonschema:"enum:approve|reject"`
+	Participants []string `json:"participants" jsonschema:"description:JIDs or phone numbers of the requesters"`
+}
+
+func groupPath(jid string, action string) string {
+	path := "/groups/" + url.PathEscape(jid)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+// groupCall runs a group API call and returns the decoded response
+func groupCall(method, path string, body any) *mcp.CallToolResult {
+	data, err := callAPI(method, path, body)
+	if err != nil {
+		return ErrResult(err.Error())
+	}
+
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse group response")
+	}
+	if group, ok := result["group"]; ok {
+		return OkResult(group)
+	}
+	return OkResult(result)
+}
+
+func listGroupsHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in listGroupsInput,
+) (*mcp.CallToolResult, any, error) {
+	data, err := callAPI(http.MethodGet, "/groups", nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Groups []map[string]any `json:"groups"`
+		Count  int              `json:"count"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse groups response"), nil, nil
+	}
+
+	groups := result.Groups
+	if in.Query != nil && *in.Query != "" {
+		query := strings.ToLower(*in.Query)
+		groups = groups[:0]
+		for _, g := range result.Groups {
+			name, _ := g["name"].(string)
+			if strings.Contains(strings.ToLower(name), query) {
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	return OkResult(groups), nil, nil
+}
+
+func getGroupInfoHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in groupInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+	return groupCall(http.MethodGet, groupPath(in.GroupJid, ""), nil), nil, nil
+}
+
+func createGroupHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in createGroupInput,
+) (*mcp.CallToolResult, any, error) {
+	if strings.TrimSpace(in.Name) == "" {
+		return ErrResult("name is required"), nil, nil
+	}
+
+	payload := map[string]any{
+		"name":         in.Name,
+		"participants": in.Participants,
+	}
+	return groupCall(http.MethodPost, "/groups", payload), nil, nil
+}
+
+func updateGroupParticipantsHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in updateGroupParticipantsInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+	if len(in.Participants) == 0 {
+		return ErrResult("participants is required"), nil, nil
+	}
+
+	switch strings.ToLower(in.Action) {
+	case "add", "remove", "promote", "demote":
+	default:
+		return ErrResult("action must be add, remove, promote or demote"), nil, nil
+	}
+
+	payload := map[string]any{
+		"action":       strings.ToLower(in.Action),
+		"participants": in.Participants,
+	}
+	return groupCall(http.MethodPost, groupPath(in.GroupJid, "participants"), payload), nil, nil
+}
+
+func setGroupSubjectHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in setGroupSubjectInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+	if strings.TrimSpace(in.Name) == "" {
+		return ErrResult("name is required"), nil, nil
+	}
+
+	payload := map[string]any{"name": in.Name}
+	return groupCall(http.MethodPost, groupPath(in.GroupJid, "subject"), payload), nil, nil
+}
+
+func setGroupDescriptionHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in setGroupDescriptionInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+
+	payload := map[string]any{"description": in.Description}
+	return groupCall(http.MethodPost, groupPath(in.GroupJid, "description"), payload), nil, nil
+}
+
+func setGroupPhotoHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in setGroupPhotoInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+
+	imagePath := in.ImagePath
+	if imagePath != "" {
+		absPath, err := filepath.Abs(imagePath)
+		if err != nil {
+			return ErrResult("invalid path: " + err.Error()), nil, nil
+		}
+		imagePath = absPath
+	}
+
+	payload := map[string]any{"image_path": imagePath}
+	return groupCall(http.MethodPost, groupPath(in.GroupJid, "photo"), payload), nil, nil
+}
+
+func leaveGroupHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in groupInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+	return groupCall(http.MethodPost, groupPath(in.GroupJid, "leave"), nil), nil, nil
+}
+
+func getGroupInviteLinkHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in getGroupInviteLinkInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+	if in.Reset {
+		return groupCall(http.MethodPost, groupPath(in.GroupJid, "invite/reset"), nil), nil, nil
+	}
+	return groupCall(http.MethodGet, groupPath(in.GroupJid, "invite"), nil), nil, nil
+}
+
+func previewGroupInviteHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in groupLinkInput,
+) (*mcp.CallToolResult, any, error) {
+	if strings.TrimSpace(in.Link) == "" {
+		return ErrResult("link is required"), nil, nil
+	}
+	return groupCall(http.MethodGet, "/groups/preview?link="+url.QueryEscape(in.Link), nil), nil, nil
+}
+
+func joinGroupHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in groupLinkInput,
+) (*mcp.CallToolResult, any, error) {
+	if strings.TrimSpace(in.Link) == "" {
+		return ErrResult("link is required"), nil, nil
+	}
+	return groupCall(http.MethodPost, "/groups/join", map[string]any{"link": in.Link}), nil, nil
+}
+
+func listGroupRequestsHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in groupInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+	return groupCall(http.MethodGet, groupPath(in.GroupJid, "requests"), nil), nil, nil
+}
+
+func updateGroupRequestsHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in updateGroupRequestsInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.GroupJid == "" {
+		return ErrResult("group_jid is required"), nil, nil
+	}
+	if len(in.Participants) == 0 {
+		return ErrResult("participants is required"), nil, nil
+	}
+
+	action := strings.ToLower(in.Action)
+	if action != "approve" && action != "reject" {
+		return ErrResult("action must be approve or reject"), nil, nil
+	}
+
+	payload := map[string]any{
+		"action":       action,
+		"participants": in.Participants,
+	}
+	return groupCall(http.MethodPost, groupPath(in.GroupJid, "requests"), payload), nil, nil
+}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -21,6 +23,8 @@ func InitMcpTool() {
 		Name:    "whatsapp-mcp",
 		Version: "v1.0.0",
 	}, nil)
+	audit = openAuditLog()
+	server.AddReceivingMiddleware(auditMiddleware)
 
 	mcp.AddTool[searchContactsInput, any](server, &mcp.Tool{
 		Name:        "search_contacts",
@@ -64,7 +68,7 @@ func InitMcpTool() {
 
 	mcp.AddTool[sendMessageInput, map[string]any](server, &mcp.Tool{
 		Name:        "send_message",
-		Description: "Send a text message to a person or group on WhatsApp. For groups use the group JID.",
+		Description: "Send a text message to a person or group on WhatsApp. For groups use the group JID. Pass mentions to @mention group members.",
 	}, sendMessageHandler)
 
 	mcp.AddTool[sendFileInput, map[string]any](server, &mcp.Tool{
@@ -74,14 +78,26 @@ func InitMcpTool() {
 
 	mcp.AddTool[sendAudioMessageInput, map[string]any](server, &mcp.Tool{
 		Name:        "send_audio_message",
-		Description: "Send audio/voice message (converted to Opus .ogg if needed).",
+		Description: "Send audio/voice message (converted to Opus .ogg if needed). Any format converts with ffmpeg installed; without it only WAV and raw PCM are supported.",
 	}, sendAudioMessageHandler)
 
 	mcp.AddTool[downloadMediaInput, map[string]any](server, &mcp.Tool{
 		Name:        "download_media",
-		Description: "Download media from a WhatsApp message and return local file path.",
+		Description: "Download media from a WhatsApp message and return local file path. If the media expired, the phone is asked to re-upload it and the result has pending=true; call again shortly.",
 	}, downloadMediaHandler)
 
+	registerContactTools(server)
+	registerGroupTools(server)
+	registerProfileTools(server)
+	registerPresenceTools(server)
+	registerSharedMessageTools(server)
+	registerPollTools(server)
+	registerMentionTools(server)
+	registerForwardTools(server)
+	registerOutboxTools(server)
+	registerScheduleTools(server)
+	registerBulkTools(server)
+
 	isSSE := strings.ToLower(ReadEnv("IS_SSE", "false")) == "true" ||
 		strings.ToLower(ReadEnv("IS_SSE", "0")) == "1"
 
@@ -159,18 +175,24 @@ type getLastInteractionInput struct {
 }
 
 type sendMessageInput struct {
-	Recipient string `json:"recipient" jsonschema:"description:Phone number (no +) or group JID like 123@g.us"`
-	Message   string `json:"message"`
+	Recipient string   `json:"recipient" jsonschema:"description:Phone number with country code (formatting like + or spaces is fine) or a JID like 123@g.us"`
+	Message   string   `json:"message"`
+	Mentions  []string `json:"mentions,omitempty" jsonschema:"description:Phone numbers or JIDs of people to @mention; missing @number tokens are added to the start of the message"`
+	Wait      bool     `json:"wait,omitempty" jsonschema:"description:Wait up to 20 seconds for the message to be delivered instead of returning once it is queued"`
 }
 
 type sendFileInput struct {
 	Recipient string `json:"recipient"`
 	MediaPath string `json:"media_path" jsonschema:"description:Absolute path to the file"`
+	Wait      bool   `json:"wait,omitempty" jsonschema:"description:Wait up to 20 seconds for the file to be delivered instead of returning once it is queued"`
 }
 
 type sendAudioMessageInput struct {
-	Recipient string `json:"recipient"`
-	MediaPath string `json:"media_path" jsonschema:"description:Absolute path to audio file"`
+	Recipient  string `json:"recipient"`
+	MediaPath  string `json:"media_path" jsonschema:"description:Absolute path to audio file"`
+	Bitrate    int    `json:"bitrate,omitempty" jsonschema:"description:Opus bitrate in kbps when converting (default 32)"`
+	SampleRate int    `json:"sample_rate,omitempty" jsonschema:"description:Opus sample rate in Hz when converting: 8000, 12000, 16000, 24000 or 48000 (default 48000)"`
+	Wait       bool   `json:"wait,omitempty" jsonschema:"description:Wait up to 20 seconds for the message to be delivered instead of returning once it is queued"`
 }
 
 type downloadMediaInput struct {
@@ -194,6 +216,7 @@ func callAPI(method, path string, body any) ([]byte, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	setAccessHeaders(req)
 
 	client := &http.Client{Timeout: apiTimeout}
 	resp, err := client.Do(req)
@@ -235,8 +258,14 @@ func sendMessageHandler(
 		"recipient": in.Recipient,
 		"message":   in.Message,
 	}
+	if len(in.Mentions) > 0 {
+		payload["mentions"] = in.Mentions
+	}
+	if res, out := guardSend(ctx, req, sendPath("/send", in.Wait), []string{in.Recipient}, payload); res != nil {
+		return res, out, nil
+	}
 
-	data, err := callAPI(http.MethodPost, "/send", payload)
+	data, err := callAPI(http.MethodPost, sendPath("/send", in.Wait), payload)
 	if err != nil {
 		return &mcp.CallToolResult{
 				IsError: true,
@@ -286,7 +315,7 @@ func searchContactsHandler(
 		return ErrResult("invalid response format"), nil, nil
 	}
 
-	return OkResult(result.Contacts), nil, nil
+	return OkResult(visibleOnly(result.Contacts, "jid")), nil, nil
 }
 
 // list_messages (similar for all read/list tools that return slices/maps)
@@ -295,6 +324,10 @@ func listMessagesHandler(
 	req *mcp.CallToolRequest,
 	in listMessagesInput,
 ) (*mcp.CallToolResult, any, error) {
+	if in.ChatJid != nil && profile.hides(*in.ChatJid) {
+		return ErrResult(hiddenChatError(*in.ChatJid)), nil, nil
+	}
+
 	q := ""
 	if in.After != nil {
 		q += "&after=" + *in.After
@@ -331,7 +364,20 @@ func downloadMediaHandler(
 	req *mcp.CallToolRequest,
 	in downloadMediaInput,
 ) (*mcp.CallToolResult, map[string]any, error) {
+	if profile.hides(in.ChatJid) {
+		return &mcp.CallToolResult{}, map[string]any{
+			"success": false,
+			"message": hiddenChatError(in.ChatJid),
+		}, nil
+	}
 	path, err := DownloadMedia(in.MessageID, in.ChatJid)
+	if errors.Is(err, ErrMediaPending) {
+		return &mcp.CallToolResult{}, map[string]any{
+			"success": false,
+			"pending": true,
+			"message": err.Error(),
+		}, nil
+	}
 	if err != nil || path == "" {
 		msg := "failed to download media"
 		if err != nil {
@@ -370,6 +416,11 @@ func getMessageContextHandler(
 	if err := json.Unmarshal(data, &ctxData); err != nil {
 		return ErrResult("invalid context response"), nil, nil
 	}
+	if msg, ok := ctxData["message"].(map[string]any); ok {
+		if jid, _ := msg["chat_jid"].(string); profile.hides(jid) {
+			return ErrResult(hiddenChatError(jid)), nil, nil
+		}
+	}
 
 	return OkResult(ctxData), nil, nil
 }
@@ -398,7 +449,7 @@ func listChatsHandler(
 	}
 	_ = json.Unmarshal(data, &result) // best effort
 
-	return OkResult(result.Chats), nil, nil
+	return OkResult(visibleOnly(result.Chats, "jid")), nil, nil
 }
 
 func getChatHandler(
@@ -409,6 +460,9 @@ func getChatHandler(
 	if in.ChatJid == "" {
 		return ErrResult("chat_jid is required"), nil, nil
 	}
+	if profile.hides(in.ChatJid) {
+		return ErrResult(hiddenChatError(in.ChatJid)), nil, nil
+	}
 
 	data, err := callAPI(http.MethodGet, "/chats/"+in.ChatJid, nil)
 	if err != nil {
@@ -457,6 +511,11 @@ func getDirectChatByContactHandler(
 	if !ok {
 		return ErrResult("chat object missing in response"), nil, nil
 	}
+	if c, ok := chat.(map[string]any); ok {
+		if jid, _ := c["jid"].(string); profile.hides(jid) {
+			return ErrResult(hiddenChatError(jid)), nil, nil
+		}
+	}
 
 	return &mcp.CallToolResult{}, chat, nil
 }
@@ -495,7 +554,7 @@ func getContactChatsHandler(
 		return ErrResult("failed to parse chats response"), nil, nil
 	}
 
-	return &mcp.CallToolResult{}, result.Chats, nil
+	return &mcp.CallToolResult{}, visibleOnly(result.Chats, "jid"), nil
 }
 
 func getLastInteractionHandler(
@@ -535,9 +594,15 @@ func sendFileHandler(ctx context.Context,
 		}, nil, nil
 	}
 
-	success, msg := SendFile(in.Recipient, absPath)
+	payload := map[string]any{"recipient": in.Recipient, "media_path": absPath}
+	if res, out := guardSend(ctx, req, sendPath("/send", in.Wait), []string{in.Recipient}, payload); res != nil {
+		return res, out, nil
+	}
 
-	resultData := map[string]any{"success": success, "message": msg}
+	success, msg, resultData := SendFile(in.Recipient, absPath, in.Wait)
+	if resultData == nil {
+		resultData = map[string]any{"success": success, "message": msg}
+	}
 
 	return &mcp.CallToolResult{IsError: !success}, resultData, nil
 }
@@ -546,9 +611,37 @@ func sendAudioMessageHandler(ctx context.Context,
 	req *mcp.CallToolRequest,
 	in sendAudioMessageInput) (*mcp.CallToolResult, map[string]any, error) {
 
-	success, msg := SendAudioVoiceMessage(in.Recipient, in.MediaPath)
+	if in.Recipient == "" {
+		return ErrResult("recipient is required"), nil, nil
+	}
+	if in.MediaPath == "" {
+		return ErrResult("media_path is required"), nil, nil
+	}
+	absPath, err := filepath.Abs(in.MediaPath)
+	if err != nil {
+		return ErrResult(fmt.Sprintf("invalid path: %v", err)), nil, nil
+	}
+	if _, err := os.Stat(absPath); err != nil {
+		return ErrResult("Media file not found: " + absPath), nil, nil
+	}
+
+	// Convert first, so a dry run or a confirmation shows the file that is sent
+	opts := AudioOptions{Bitrate: in.Bitrate * 1000, SampleRate: in.SampleRate}
+	voicePath, cleanup, err := PrepareVoiceMessage(absPath, opts)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+	defer cleanup()
+
+	payload := map[string]any{"recipient": in.Recipient, "media_path": voicePath}
+	if res, out := guardSend(ctx, req, sendPath("/send", in.Wait), []string{in.Recipient}, payload); res != nil {
+		return res, out, nil
+	}
 
-	resultData := map[string]any{"success": success, "message": msg}
+	success, msg, resultData := SendFile(in.Recipient, voicePath, in.Wait)
+	if resultData == nil {
+		resultData = map[string]any{"success": success, "message": msg}
+	}
 
 	return &mcp.CallToolResult{IsError: !success}, resultData, nil
 }
@@ -0,0 +1,55 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerMentionTools adds the mention lookup tool to the server
+func registerMentionTools(server *mcp.Server) {
+	mcp.AddTool[listMentionsInput, any](server, &mcp.Tool{
+		Name:        "list_mentions",
+		Description: "List messages that @mentioned you (or another contact) in the last N days, newest first, with their chat.",
+	}, listMentionsHandler)
+}
+
+type listMentionsInput struct {
+	Days  int    `json:"days,omitempty" jsonschema:"description:How many days back to look (default 7)"`
+	Limit int    `json:"limit,omitempty" jsonschema:"description:Maximum number of messages (default 50)"`
+	Jid   string `json:"jid,omitempty" jsonschema:"description:Phone number or JID of someone else to find mentions of; defaults to you"`
+}
+
+func listMentionsHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in listMentionsInput,
+) (*mcp.CallToolResult, any, error) {
+	q := url.Values{}
+	if in.Days > 0 {
+		q.Set("days", fmt.Sprint(in.Days))
+	}
+	if in.Limit > 0 {
+		q.Set("limit", fmt.Sprint(in.Limit))
+	}
+	if in.Jid != "" {
+		q.Set("jid", in.Jid)
+	}
+
+	data, err := callAPI(http.MethodGet, "/mentions?"+q.Encode(), nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse mentions response"), nil, nil
+	}
+	return OkResult(result.Result), nil, nil
+}
@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Sends are queued in the bridge's outbox and delivered in the background. The
+// send tools return the outbox ID, which get_send_status looks up.
+
+// registerOutboxTools adds the send status tool to the server
+func registerOutboxTools(server *mcp.Server) {
+	mcp.AddTool[sendStatusInput, any](server, &mcp.Tool{
+		Name:        "get_send_status",
+		Description: "Check a queued send by the id a send tool returned: status is queued, sending, sent or failed, with the attempts so far, the last error and, once sent, the message_id and chat_jid.",
+	}, getSendStatusHandler)
+}
+
+type sendStatusInput struct {
+	ID string `json:"id" jsonschema:"description:Outbox ID returned by a send tool"`
+}
+
+// sendPath asks the bridge to wait for delivery before answering
+func sendPath(path string, wait bool) string {
+	if wait {
+		return path + "?wait=true"
+	}
+	return path
+}
+
+func getSendStatusHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in sendStatusInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.ID == "" {
+		return ErrResult("id is required"), nil, nil
+	}
+
+	data, err := callAPI(http.MethodGet, "/outbox/"+url.PathEscape(in.ID), nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Outbox map[string]any `json:"outbox"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse outbox response"), nil, nil
+	}
+	return OkResult(result.Outbox), nil, nil
+}
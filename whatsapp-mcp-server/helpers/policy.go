@@ -0,0 +1,241 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// The send policy sits between the send tools and the bridge. Recipients can be
+// restricted with allow and deny lists, sends to recipients the user hasn't
+// approved yet can require confirmation through MCP elicitation, and a dry run
+// mode answers with the request that would have been sent instead of sending.
+
+// sendPolicy decides whether a tool may send to its recipients
+type sendPolicy struct {
+	allow, deny []string
+	confirmNew  bool
+	dryRun      bool
+
+	mu sync.Mutex
+	// approved are the recipients the user confirmed while the server runs
+	approved map[string]bool
+}
+
+var policy = loadSendPolicy()
+
+// loadSendPolicy reads WHATSAPP_ALLOWED_RECIPIENTS and WHATSAPP_DENIED_RECIPIENTS
+// (comma separated phone numbers, JIDs or patterns like *@g.us or +49*),
+// WHATSAPP_CONFIRM_NEW_RECIPIENTS and WHATSAPP_DRY_RUN
+func loadSendPolicy() *sendPolicy {
+	return &sendPolicy{
+		allow:      recipientPatterns(ReadEnv("WHATSAPP_ALLOWED_RECIPIENTS", "")),
+		deny:       recipientPatterns(ReadEnv("WHATSAPP_DENIED_RECIPIENTS", "")),
+		confirmNew: envFlag("WHATSAPP_CONFIRM_NEW_RECIPIENTS"),
+		dryRun:     envFlag("WHATSAPP_DRY_RUN"),
+		approved:   make(map[string]bool),
+	}
+}
+
+func envFlag(key string) bool {
+	value := strings.ToLower(ReadEnv(key, "false"))
+	return value == "true" || value == "1"
+}
+
+func recipientPatterns(list string) []string {
+	var patterns []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = normalizeRecipient(entry); entry != "" {
+			patterns = append(patterns, entry)
+		}
+	}
+	return patterns
+}
+
+// normalizeRecipient writes phone numbers and phone number JIDs as bare digits
+// and other JIDs in lower case without the device, so the forms match each other
+func normalizeRecipient(recipient string) string {
+	recipient = strings.ToLower(strings.TrimSpace(recipient))
+	if user, server, ok := strings.Cut(recipient, "@"); ok {
+		user, _, _ = strings.Cut(user, ":")
+		if server == "s.whatsapp.net" || server == "c.us" {
+			return user
+		}
+		return user + "@" + server
+	}
+
+	recipient = strings.TrimPrefix(recipient, "00")
+	var b strings.Builder
+	for _, r := range recipient {
+		if (r >= '0' && r <= '9') || r == '*' || r == '?' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func matchesAny(patterns []string, recipient string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, recipient); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// permitted reports whether the lists allow sending to a recipient. The deny
+// list wins; with an allow list, only recipients on it are permitted.
+func (p *sendPolicy) permitted(recipient string) bool {
+	r := normalizeRecipient(recipient)
+	// A LID doesn't show the phone number the deny list may name
+	if matchesAny(p.deny, r) || (len(p.deny) > 0 && isLID(r)) {
+		return false
+	}
+	return len(p.allow) == 0 || matchesAny(p.allow, r)
+}
+
+func isLID(recipient string) bool {
+	return strings.HasSuffix(recipient, "@lid")
+}
+
+// unapproved returns the recipients that need the user's confirmation
+func (p *sendPolicy) unapproved(recipients []string) []string {
+	if !p.confirmNew {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var pending []string
+	seen := make(map[string]bool)
+	for _, recipient := range recipients {
+		r := normalizeRecipient(recipient)
+		// Recipients on the allow list are approved by configuration
+		if p.approved[r] || seen[r] || (len(p.allow) > 0 && matchesAny(p.allow, r)) {
+			continue
+		}
+		seen[r] = true
+		pending = append(pending, recipient)
+	}
+	return pending
+}
+
+func (p *sendPolicy) approve(recipients []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, recipient := range recipients {
+		p.approved[normalizeRecipient(recipient)] = true
+	}
+}
+
+// guardSend applies the send policy before a tool posts payload to path. It
+// returns the result to answer with instead of sending, or nil to go ahead.
+func guardSend(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	apiPath string,
+	recipients []string,
+	payload any,
+) (*mcp.CallToolResult, map[string]any) {
+	var denied []string
+	for _, recipient := range recipients {
+		if !policy.permitted(recipient) {
+			denied = append(denied, recipient)
+		}
+	}
+	if len(denied) > 0 {
+		msg := "Sending to " + strings.Join(denied, ", ") + " is not allowed by the recipient policy"
+		if slices.ContainsFunc(denied, func(r string) bool { return isLID(normalizeRecipient(r)) }) {
+			msg += "; send to the phone number instead of the LID"
+		}
+		return ErrResult(msg), map[string]any{"success": false, "error": msg}
+	}
+
+	if policy.dryRun {
+		out := map[string]any{
+			"success": true,
+			"dry_run": true,
+			"message": "Dry run: nothing was sent",
+			"request": map[string]any{
+				"method": http.MethodPost,
+				"url":    apiBaseURL + apiPath,
+				"body":   payload,
+			},
+		}
+		return OkResult(out), out
+	}
+
+	pending := policy.unapproved(recipients)
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	if err := confirmSend(ctx, req, pending, payload); err != nil {
+		return ErrResult(err.Error()), map[string]any{"success": false, "error": err.Error()}
+	}
+	policy.approve(pending)
+	return nil, nil
+}
+
+// confirmSend asks the user through the client to allow sending to recipients
+func confirmSend(ctx context.Context, req *mcp.CallToolRequest, recipients []string, payload any) error {
+	if req == nil || req.Session == nil {
+		return fmt.Errorf("sending to %s needs the user's confirmation, but there is no client session to ask", strings.Join(recipients, ", "))
+	}
+
+	shown := recipients
+	if len(shown) > 20 {
+		shown = shown[:20]
+	}
+	msg := "Allow sending WhatsApp messages to " + strings.Join(shown, ", ")
+	if len(recipients) > len(shown) {
+		msg += fmt.Sprintf(" and %d more", len(recipients)-len(shown))
+	}
+	msg += "?"
+	if body, err := json.MarshalIndent(payload, "", "  "); err == nil {
+		msg += "\n\n" + string(body)
+	}
+
+	res, err := req.Session.Elicit(ctx, &mcp.ElicitParams{
+		Message: msg,
+		RequestedSchema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("sending to %s needs the user's confirmation, which failed: %v", strings.Join(recipients, ", "), err)
+	}
+	if res.Action != "accept" {
+		return fmt.Errorf("the user did not allow sending to %s (%s)", strings.Join(recipients, ", "), res.Action)
+	}
+	return nil
+}
+
+// listRecipients returns the recipients of a saved bulk send list
+func listRecipients(name string) ([]string, error) {
+	data, err := callAPI(http.MethodGet, "/bulk/lists/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Recipients []struct {
+			Recipient string `json:"recipient"`
+		} `json:"recipients"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse recipient list response")
+	}
+	recipients := make([]string, len(result.Recipients))
+	for i, r := range result.Recipients {
+		recipients[i] = r.Recipient
+	}
+	return recipients, nil
+}
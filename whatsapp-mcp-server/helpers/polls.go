@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerPollTools adds the poll tools to the server
+func registerPollTools(server *mcp.Server) {
+	mcp.AddTool[sendPollInput, map[string]any](server, &mcp.Tool{
+		Name:        "send_poll",
+		Description: "Send a poll with 2 to 12 options to a person or group on WhatsApp. The poll's message ID is the returned id; get_poll_results reads the votes.",
+	}, sendPollHandler)
+
+	mcp.AddTool[pollResultsInput, any](server, &mcp.Tool{
+		Name:        "get_poll_results",
+		Description: "Get the current votes of a poll: each option with its vote count and who voted for it. Works for polls sent and received.",
+	}, getPollResultsHandler)
+}
+
+type sendPollInput struct {
+	Recipient       string   `json:"recipient" jsonschema:"description:Phone number with country code (formatting like + or spaces is fine) or a JID like 123@g.us"`
+	Question        string   `json:"question" jsonschema:"description:The poll question"`
+	Options         []string `json:"options" jsonschema:"description:2 to 12 unique answer options"`
+	MultipleAnswers bool     `json:"multiple_answers,omitempty" jsonschema:"description:Allow voters to choose more than one option"`
+	Wait            bool     `json:"wait,omitempty" jsonschema:"description:Wait up to 20 seconds for the message to be delivered instead of returning once it is queued"`
+}
+
+type pollResultsInput struct {
+	MessageID string `json:"message_id" jsonschema:"description:Message ID of the poll"`
+	ChatJID   string `json:"chat_jid,omitempty" jsonschema:"description:Chat JID of the poll, needed only if the message ID is ambiguous"`
+}
+
+func sendPollHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in sendPollInput,
+) (*mcp.CallToolResult, map[string]any, error) {
+	if in.Recipient == "" || in.Question == "" || len(in.Options) < 2 {
+		return ErrResult("recipient, question and at least 2 options are required"), map[string]any{
+			"success": false,
+			"error":   "recipient, question and at least 2 options are required",
+		}, nil
+	}
+
+	return sendCall(ctx, req, sendPath("/send/poll", in.Wait), map[string]any{
+		"recipient":        in.Recipient,
+		"question":         in.Question,
+		"options":          in.Options,
+		"multiple_answers": in.MultipleAnswers,
+	})
+}
+
+func getPollResultsHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in pollResultsInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.MessageID == "" {
+		return ErrResult("message_id is required"), nil, nil
+	}
+
+	path := "/polls/" + url.PathEscape(in.MessageID)
+	if in.ChatJID != "" {
+		path += "?chat_jid=" + url.QueryEscape(in.ChatJID)
+	}
+	data, err := callAPI(http.MethodGet, path, nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Poll map[string]any `json:"poll"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse poll response"), nil, nil
+	}
+	return OkResult(result.Poll), nil, nil
+}
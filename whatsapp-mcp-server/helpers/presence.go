@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerPresenceTools adds the presence and typing indicator tools to the server
+func registerPresenceTools(server *mcp.Server) {
+	mcp.AddTool[setPresenceInput, any](server, &mcp.Tool{
+		Name:        "set_presence",
+		Description: "Set this account's availability to available (online) or unavailable. Presence updates of other users are only received while available.",
+	}, setPresenceHandler)
+
+	mcp.AddTool[sendChatPresenceInput, any](server, &mcp.Tool{
+		Name:        "send_chat_presence",
+		Description: "Show 'typing...' (composing) or 'recording audio...' (recording) in a chat, or clear it (paused). With duration_seconds the call waits that long while keeping the indicator up, so a message sent afterwards looks naturally typed.",
+	}, sendChatPresenceHandler)
+
+	mcp.AddTool[subscribePresenceInput, any](server, &mcp.Tool{
+		Name:        "subscribe_presence",
+		Description: "Subscribe to a contact's online status and last seen time. get_chat then reports them. Returns what is currently known.",
+	}, subscribePresenceHandler)
+}
+
+type setPresenceInput struct {
+	State string `json:"state" jsonschema:"enum:available|unavailable"`
+}
+
+type sendChatPresenceInput struct {
+	Jid             string `json:"jid" jsonschema:"description:Chat JID or phone number with country code"`
+	State           string `json:"state" jsonschema:"enum:composing|recording|paused"`
+	DurationSeconds int    `json:"duration_seconds,omitempty" jsonschema:"description:Seconds to keep the indicator up before returning (max 20)"`
+}
+
+type subscribePresenceInput struct {
+	Jid string `json:"jid" jsonschema:"description:User JID or phone number with country code"`
+}
+
+// presenceCall runs a presence API call and returns the decoded response
+func presenceCall(path string, body any) *mcp.CallToolResult {
+	data, err := callAPI(http.MethodPost, path, body)
+	if err != nil {
+		return ErrResult(err.Error())
+	}
+
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse presence response")
+	}
+	return OkResult(result)
+}
+
+func setPresenceHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in setPresenceInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.State == "" {
+		return ErrResult("state is required"), nil, nil
+	}
+	return presenceCall("/presence", map[string]any{"state": in.State}), nil, nil
+}
+
+func sendChatPresenceHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in sendChatPresenceInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.Jid == "" || in.State == "" {
+		return ErrResult("jid and state are required"), nil, nil
+	}
+	return presenceCall("/presence/chat", map[string]any{
+		"jid":              in.Jid,
+		"state":            in.State,
+		"duration_seconds": in.DurationSeconds,
+	}), nil, nil
+}
+
+func subscribePresenceHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in subscribePresenceInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.Jid == "" {
+		return ErrResult("jid is required"), nil, nil
+	}
+	return presenceCall("/presence/subscribe", map[string]any{"jid": in.Jid}), nil, nil
+}
@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerProfileTools adds the user and group profile tools to the server
+func registerProfileTools(server *mcp.Server) {
+	mcp.AddTool[profileInput, any](server, &mcp.Tool{
+		Name:        "get_profile_info",
+		Description: "Get a WhatsApp user's or group's profile: about text (group description for groups), verified business name, linked devices and the local path of the profile picture. Results are cached for a day.",
+	}, getProfileInfoHandler)
+
+	mcp.AddTool[profileInput, any](server, &mcp.Tool{
+		Name:        "get_profile_picture",
+		Description: "Download a WhatsApp user's profile picture or a group's photo and return the local file path. picture_status is not_set or hidden when there is no picture to show.",
+	}, getProfilePictureHandler)
+}
+
+type profileInput struct {
+	Jid     string `json:"jid" jsonschema:"description:User JID, group JID or phone number with country code"`
+	Refresh bool   `json:"refresh,omitempty" jsonschema:"description:Fetch from WhatsApp instead of using the cache"`
+}
+
+func profilePath(in profileInput, action string) string {
+	path := "/profiles/" + url.PathEscape(in.Jid)
+	if action != "" {
+		path += "/" + action
+	}
+	if in.Refresh {
+		path += "?refresh=true"
+	}
+	return path
+}
+
+func getProfileInfoHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in profileInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.Jid == "" {
+		return ErrResult("jid is required"), nil, nil
+	}
+
+	data, err := callAPI(http.MethodGet, profilePath(in, ""), nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Profile map[string]any `json:"profile"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse profile response"), nil, nil
+	}
+	return OkResult(result.Profile), nil, nil
+}
+
+func getProfilePictureHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in profileInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.Jid == "" {
+		return ErrResult("jid is required"), nil, nil
+	}
+
+	data, err := callAPI(http.MethodGet, profilePath(in, "picture"), nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse profile picture response"), nil, nil
+	}
+	return OkResult(result), nil, nil
+}
@@ -0,0 +1,138 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerScheduleTools adds the scheduled message tools to the server
+func registerScheduleTools(server *mcp.Server) {
+	mcp.AddTool[scheduleMessageInput, any](server, &mcp.Tool{
+		Name:        "schedule_message",
+		Description: "Schedule a text message or file to send later, either once at send_at or repeatedly on a cron expression like \"0 9 * * 1-5\" (weekdays at 9:00). Times are read in timezone, the bridge's zone by default. Each run is queued like a normal send; get_send_status looks up its last_outbox_id.",
+	}, scheduleMessageHandler)
+
+	mcp.AddTool[listSchedulesInput, any](server, &mcp.Tool{
+		Name:        "list_scheduled_messages",
+		Description: "List scheduled messages by next run, with their id, recipient, cron expression, number of runs and last outbox ID.",
+	}, listSchedulesHandler)
+
+	mcp.AddTool[cancelScheduleInput, any](server, &mcp.Tool{
+		Name:        "cancel_scheduled_message",
+		Description: "Cancel a scheduled message so it does not send again. Runs already queued are not recalled.",
+	}, cancelScheduleHandler)
+}
+
+type scheduleMessageInput struct {
+	Recipient string   `json:"recipient" jsonschema:"description:Phone number with country code (formatting like + or spaces is fine) or a JID like 123@g.us"`
+	Message   string   `json:"message,omitempty" jsonschema:"description:Text to send, or the caption of media_path"`
+	MediaPath string   `json:"media_path,omitempty" jsonschema:"description:Absolute path to a file to send; it is copied when scheduling"`
+	Mentions  []string `json:"mentions,omitempty" jsonschema:"description:Phone numbers or JIDs of people to @mention"`
+	SendAt    string   `json:"send_at,omitempty" jsonschema:"description:When to send once, like 2026-10-19T09:00 (in timezone) or with an offset like 2026-10-19T09:00:00+02:00"`
+	Cron      string   `json:"cron,omitempty" jsonschema:"description:Five-field cron expression (minute hour day month weekday) or @daily/@weekly/@monthly to send repeatedly; give this or send_at"`
+	Timezone  string   `json:"timezone,omitempty" jsonschema:"description:IANA time zone like Europe/Berlin for send_at and cron"`
+}
+
+type listSchedulesInput struct {
+	IncludeFinished bool `json:"include_finished,omitempty" jsonschema:"description:Also list schedules that are done or cancelled"`
+}
+
+type cancelScheduleInput struct {
+	ID string `json:"id" jsonschema:"description:ID of the scheduled message"`
+}
+
+func scheduleMessageHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in scheduleMessageInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.Recipient == "" {
+		return ErrResult("recipient is required"), nil, nil
+	}
+	if in.Message == "" && in.MediaPath == "" {
+		return ErrResult("message or media_path is required"), nil, nil
+	}
+	if (in.SendAt == "") == (in.Cron == "") {
+		return ErrResult("give either send_at or cron"), nil, nil
+	}
+
+	payload := map[string]any{
+		"recipient":  in.Recipient,
+		"message":    in.Message,
+		"media_path": in.MediaPath,
+		"mentions":   in.Mentions,
+		"send_at":    in.SendAt,
+		"cron":       in.Cron,
+		"timezone":   in.Timezone,
+	}
+	if res, out := guardSend(ctx, req, "/schedule", []string{in.Recipient}, payload); res != nil {
+		return res, out, nil
+	}
+
+	data, err := callAPI(http.MethodPost, "/schedule", payload)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Message  string         `json:"message"`
+		Schedule map[string]any `json:"schedule"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse schedule response"), nil, nil
+	}
+	return OkResult(map[string]any{
+		"message":  result.Message,
+		"schedule": result.Schedule,
+	}), nil, nil
+}
+
+func listSchedulesHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in listSchedulesInput,
+) (*mcp.CallToolResult, any, error) {
+	path := "/schedule"
+	if in.IncludeFinished {
+		path += "?all=true"
+	}
+	data, err := callAPI(http.MethodGet, path, nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Schedules []map[string]any `json:"schedules"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse schedule response"), nil, nil
+	}
+	return OkResult(result.Schedules), nil, nil
+}
+
+func cancelScheduleHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in cancelScheduleInput,
+) (*mcp.CallToolResult, any, error) {
+	if in.ID == "" {
+		return ErrResult("id is required"), nil, nil
+	}
+
+	data, err := callAPI(http.MethodDelete, "/schedule/"+url.PathEscape(in.ID), nil)
+	if err != nil {
+		return ErrResult(err.Error()), nil, nil
+	}
+
+	var result struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return ErrResult("failed to parse schedule response"), nil, nil
+	}
+	return OkResult(result.Message), nil, nil
+}
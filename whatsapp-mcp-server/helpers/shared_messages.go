@@ -0,0 +1,109 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// registerSharedMessageTools adds the location and contact card tools to the server
+func registerSharedMessageTools(server *mcp.Server) {
+	mcp.AddTool[sendLocationInput, map[string]any](server, &mcp.Tool{
+		Name:        "send_location",
+		Description: "Send a location pin to a person or group on WhatsApp, optionally with a place name and address.",
+	}, sendLocationHandler)
+
+	mcp.AddTool[sendContactCardInput, map[string]any](server, &mcp.Tool{
+		Name:        "send_contact_card",
+		Description: "Send a contact card (vCard) with one or more phone numbers to a person or group on WhatsApp. Without a name, the stored name of the first number is used.",
+	}, sendContactCardHandler)
+}
+
+type sendLocationInput struct {
+	Recipient string  `json:"recipient" jsonschema:"description:Phone number with country code (formatting like + or spaces is fine) or a JID like 123@g.us"`
+	Latitude  float64 `json:"latitude" jsonschema:"description:Latitude in degrees, -90 to 90"`
+	Longitude float64 `json:"longitude" jsonschema:"description:Longitude in degrees, -180 to 180"`
+	Name      string  `json:"name,omitempty" jsonschema:"description:Name of the place"`
+	Address   string  `json:"address,omitempty" jsonschema:"description:Address of the place"`
+	Wait      bool    `json:"wait,omitempty" jsonschema:"description:Wait up to 20 seconds for the message to be delivered instead of returning once it is queued"`
+}
+
+type sendContactCardInput struct {
+	Recipient    string   `json:"recipient" jsonschema:"description:Phone number with country code (formatting like + or spaces is fine) or a JID like 123@g.us"`
+	Name         string   `json:"name,omitempty" jsonschema:"description:Name shown on the card"`
+	PhoneNumbers []string `json:"phone_numbers" jsonschema:"description:Phone numbers of the contact with country code"`
+	Wait         bool     `json:"wait,omitempty" jsonschema:"description:Wait up to 20 seconds for the message to be delivered instead of returning once it is queued"`
+}
+
+// sendCall posts a send request and returns the bridge's answer
+func sendCall(ctx context.Context, req *mcp.CallToolRequest, path string, payload map[string]any) (*mcp.CallToolResult, map[string]any, error) {
+	var recipients []string
+	if recipient, ok := payload["recipient"].(string); ok {
+		recipients = append(recipients, recipient)
+	}
+	if list, ok := payload["recipients"].([]string); ok {
+		recipients = append(recipients, list...)
+	}
+	if res, out := guardSend(ctx, req, path, recipients, payload); res != nil {
+		return res, out, nil
+	}
+
+	data, err := callAPI(http.MethodPost, path, payload)
+	if err != nil {
+		return ErrResult(err.Error()), map[string]any{
+			"success": false,
+			"error":   err.Error(),
+		}, nil
+	}
+
+	var resp map[string]any
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return ErrResult("failed to parse API response"), map[string]any{
+			"success": false,
+			"error":   "failed to parse API response",
+		}, nil
+	}
+	return &mcp.CallToolResult{}, resp, nil
+}
+
+func sendLocationHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in sendLocationInput,
+) (*mcp.CallToolResult, map[string]any, error) {
+	if in.Recipient == "" {
+		return ErrResult("recipient is required"), map[string]any{
+			"success": false,
+			"error":   "recipient is required",
+		}, nil
+	}
+
+	return sendCall(ctx, req, sendPath("/send/location", in.Wait), map[string]any{
+		"recipient": in.Recipient,
+		"latitude":  in.Latitude,
+		"longitude": in.Longitude,
+		"name":      in.Name,
+		"address":   in.Address,
+	})
+}
+
+func sendContactCardHandler(
+	ctx context.Context,
+	req *mcp.CallToolRequest,
+	in sendContactCardInput,
+) (*mcp.CallToolResult, map[string]any, error) {
+	if in.Recipient == "" || len(in.PhoneNumbers) == 0 {
+		return ErrResult("recipient and phone_numbers are required"), map[string]any{
+			"success": false,
+			"error":   "recipient and phone_numbers are required",
+		}, nil
+	}
+
+	return sendCall(ctx, req, sendPath("/send/contact", in.Wait), map[string]any{
+		"recipient":     in.Recipient,
+		"name":          in.Name,
+		"phone_numbers": in.PhoneNumbers,
+	})
+}
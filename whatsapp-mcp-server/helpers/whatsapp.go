@@ -75,6 +75,7 @@ func SendMessage(recipient, message string) (bool, string) {
 	body, _ := json.Marshal(payload)
 	req, _ := http.NewRequest("POST", apiBaseURL+"/send", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	setAccessHeaders(req)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -102,15 +103,15 @@ func SendMessage(recipient, message string) (bool, string) {
 	return success, msg
 }
 
-func SendFile(recipient, mediaPath string) (bool, string) {
+func SendFile(recipient, mediaPath string, wait bool) (bool, string, map[string]any) {
 	if recipient == "" {
-		return false, "Recipient must be provided"
+		return false, "Recipient must be provided", nil
 	}
 	if mediaPath == "" {
-		return false, "Media path must be provided"
+		return false, "Media path must be provided", nil
 	}
 	if _, err := os.Stat(mediaPath); os.IsNotExist(err) {
-		return false, "Media file not found: " + mediaPath
+		return false, "Media file not found: " + mediaPath, nil
 	}
 
 	payload := map[string]string{
@@ -118,86 +119,75 @@ func SendFile(recipient, mediaPath string) (bool, string) {
 		"media_path": mediaPath,
 	}
 
-	body, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", apiBaseURL+"/send", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return false, "Request error: " + err.Error()
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return false, fmt.Sprintf("HTTP %d - %s", resp.StatusCode, string(body))
-	}
-
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false, "Failed to parse response"
-	}
-
-	success, _ := result["success"].(bool)
-	msg, _ := result["message"].(string)
-	if msg == "" {
-		msg = "Unknown response"
-	}
-
-	return success, msg
+	return postSend(payload, wait)
 }
 
-func SendAudioVoiceMessage(recipient, mediaPath string) (bool, string) {
+func SendAudioVoiceMessage(recipient, mediaPath string, opts AudioOptions, wait bool) (bool, string, map[string]any) {
 	if recipient == "" {
-		return false, "Recipient must be provided"
+		return false, "Recipient must be provided", nil
 	}
 	if mediaPath == "" {
-		return false, "Media path must be provided"
+		return false, "Media path must be provided", nil
 	}
 	if _, err := os.Stat(mediaPath); os.IsNotExist(err) {
-		return false, "Media file not found: " + mediaPath
+		return false, "Media file not found: " + mediaPath, nil
 	}
 
-	finalPath := mediaPath
-	if !strings.HasSuffix(strings.ToLower(mediaPath), ".ogg") {
-		converted, err := ConvertToOpusOggTemp(mediaPath)
-		if err != nil {
-			return false, "Audio conversion failed (ffmpeg required?): " + err.Error()
-		}
-		finalPath = converted
-		defer func(name string) {
-			err := os.Remove(name)
-			if err != nil {
-
-			}
-		}(finalPath)
+	finalPath, cleanup, err := PrepareVoiceMessage(mediaPath, opts)
+	if err != nil {
+		return false, err.Error(), nil
 	}
+	defer cleanup()
 
 	payload := map[string]string{
 		"recipient":  recipient,
 		"media_path": finalPath,
 	}
 
+	return postSend(payload, wait)
+}
+
+// PrepareVoiceMessage returns the Ogg Opus file to send as a voice message.
+// Other formats are converted to a temporary file, which cleanup removes.
+func PrepareVoiceMessage(mediaPath string, opts AudioOptions) (string, func(), error) {
+	if strings.HasSuffix(strings.ToLower(mediaPath), ".ogg") {
+		return mediaPath, func() {}, nil
+	}
+	converted, err := ConvertToOpusOggTemp(mediaPath, opts)
+	if err != nil {
+		return "", nil, fmt.Errorf("audio conversion failed: %w", err)
+	}
+	return converted, func() { _ = os.Remove(converted) }, nil
+}
+
+// postSend posts a file send to the bridge, which queues it in its outbox. The
+// answer carries the outbox ID, and with wait the outcome of the delivery.
+func postSend(payload map[string]string, wait bool) (bool, string, map[string]any) {
+	path := "/send"
+	if wait {
+		path += "?wait=true"
+	}
+
 	body, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", apiBaseURL+"/send", bytes.NewBuffer(body))
+	req, _ := http.NewRequest("POST", apiBaseURL+path, bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	setAccessHeaders(req)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return false, "Request error: " + err.Error()
+		return false, "Request error: " + err.Error(), nil
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return false, fmt.Sprintf("HTTP %d - %s", resp.StatusCode, string(body))
+		return false, fmt.Sprintf("HTTP %d - %s", resp.StatusCode, string(body)), nil
 	}
 
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return false, "Failed to parse response"
+		return false, "Failed to parse response", nil
 	}
 
 	success, _ := result["success"].(bool)
@@ -206,9 +196,12 @@ func SendAudioVoiceMessage(recipient, mediaPath string) (bool, string) {
 		msg = "Unknown response"
 	}
 
-	return success, msg
+	return success, msg, result
 }
 
+// ErrMediaPending means the media expired and is being re-uploaded from the phone
+var ErrMediaPending = errors.New("media expired on the server and is being re-uploaded from the phone; try again in a few seconds")
+
 func DownloadMedia(messageID, chatJID string) (string, error) {
 	payload := map[string]string{
 		"message_id": messageID,
@@ -218,6 +211,7 @@ func DownloadMedia(messageID, chatJID string) (string, error) {
 	body, _ := json.Marshal(payload)
 	req, _ := http.NewRequest("POST", apiBaseURL+"/download", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	setAccessHeaders(req)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -226,6 +220,11 @@ func DownloadMedia(messageID, chatJID string) (string, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusAccepted {
+		// Expired media: the bridge asked the phone to re-upload it
+		return "", ErrMediaPending
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return "", fmt.Errorf("HTTP %d - %s", resp.StatusCode, string(body))
@@ -0,0 +1,295 @@
+package opus
+
+const (
+	allocSteps   = 6
+	fineOffset   = 21
+	defaultTrim  = 5
+	trimBitCost  = 6
+	allocFloor   = 1 << bitRes
+	dynallocLogp = 6
+)
+
+// allocation is the per-band split of a frame's bits between fine energy
+// and PVQ shape, as both encoder and decoder derive it.
+type allocation struct {
+	pulses       [numBands]int // shape budget in 1/8 bits
+	fineQuant    [numBands]int
+	finePriority [numBands]int
+	codedBands   int
+	balance      int
+}
+
+func bandCapsFor(end int) [numBands]int {
+	var caps [numBands]int
+	base := numBands * 2 * frameLM
+	for b := 0; b < end; b++ {
+		width := (eBands[b+1] - eBands[b]) << frameLM
+		caps[b] = (bandCaps[base+b] + 64) * width >> 2
+	}
+	return caps
+}
+
+// computeAllocation mirrors the decoder's allocation for a mono, long-block
+// frame with no dynamic boosts. The only symbol it codes is the band skip
+// decision, which is where the encoder chooses how many bands to keep.
+func computeAllocation(enc *rangeEncoder, end, trim, total int) allocation {
+	var a allocation
+	total = max(total, 0)
+	caps := bandCapsFor(end)
+
+	skipReserved := 0
+	if total >= 1<<bitRes {
+		skipReserved = 1 << bitRes
+	}
+	total -= skipReserved
+
+	var bits1, bits2, threshold, trimOffset [numBands]int
+	for b := 0; b < end; b++ {
+		width := eBands[b+1] - eBands[b]
+		threshold[b] = max(1<<bitRes, (3*width<<frameLM<<bitRes)>>4)
+		trimOffset[b] = width * (trim - defaultTrim - frameLM) * (end - b - 1) * (1 << (frameLM + bitRes)) >> 6
+		if width<<frameLM == 1 {
+			trimOffset[b] -= 1 << bitRes
+		}
+	}
+
+	lo, hi := 1, len(bandAllocation)-1
+	for lo <= hi {
+		mid := (lo + hi) >> 1
+		psum := 0
+		done := false
+		for b := end - 1; b >= 0; b-- {
+			width := eBands[b+1] - eBands[b]
+			bits := width * bandAllocation[mid][b] << frameLM >> 2
+			if bits > 0 {
+				bits = max(0, bits+trimOffset[b])
+			}
+			if bits >= threshold[b] || done {
+				done = true
+				psum += min(bits, caps[b])
+			} else if bits >= 1<<bitRes {
+				psum += 1 << bitRes
+			}
+		}
+		if psum > total {
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+	hi = lo
+	lo--
+
+	for b := 0; b < end; b++ {
+		width := eBands[b+1] - eBands[b]
+		b1 := width * bandAllocation[lo][b] << frameLM >> 2
+		b2 := caps[b]
+		if hi < len(bandAllocation) {
+			b2 = width * bandAllocation[hi][b] << frameLM >> 2
+		}
+		if b1 > 0 {
+			b1 = max(0, b1+trimOffset[b])
+		}
+		if b2 > 0 {
+			b2 = max(0, b2+trimOffset[b])
+		}
+		bits1[b] = b1
+		bits2[b] = max(0, b2-b1)
+	}
+
+	// Interpolate between the two static vectors in 1/64 steps.
+	lo, hi = 0, 1<<allocSteps
+	for range allocSteps {
+		mid := (lo + hi) >> 1
+		psum := 0
+		done := false
+		for b := end - 1; b >= 0; b-- {
+			tmp := bits1[b] + (mid * bits2[b] >> allocSteps)
+			if tmp >= threshold[b] || done {
+				done = true
+				psum += min(tmp, caps[b])
+			} else if tmp >= allocFloor {
+				psum += allocFloor
+			}
+		}
+		if psum > total {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	bits := a.pulses[:]
+	psum := 0
+	done := false
+	for b := end - 1; b >= 0; b-- {
+		tmp := bits1[b] + (lo * bits2[b] >> allocSteps)
+		if tmp < threshold[b] && !done {
+			if tmp >= allocFloor {
+				tmp = allocFloor
+			} else {
+				tmp = 0
+			}
+		} else {
+			done = true
+		}
+		tmp = min(tmp, caps[b])
+		bits[b] = tmp
+		psum += tmp
+	}
+
+	// Drop high bands that would be too thin to be worth coding.
+	codedBands := end
+	for {
+		codedBands--
+		b := codedBands
+		if b <= 0 {
+			total += skipReserved
+			codedBands++
+			break
+		}
+		left := total - psum
+		perCoeff := left / eBands[codedBands+1]
+		left -= eBands[codedBands+1] * perCoeff
+		rem := max(left-eBands[b], 0)
+		width := eBands[codedBands+1] - eBands[b]
+		bandBits := bits[b] + perCoeff*width + rem
+		if bandBits >= max(threshold[b], allocFloor+(1<<bitRes)) {
+			keep := codedBands <= 2 || bandBits > (9*width<<frameLM<<bitRes)>>4
+			enc.encodeBitLogp(keep, 1)
+			if keep {
+				codedBands++
+				break
+			}
+			psum += 1 << bitRes
+			bandBits -= 1 << bitRes
+		}
+		psum -= bits[b]
+		if bandBits >= allocFloor {
+			psum += allocFloor
+			bits[b] = allocFloor
+		} else {
+			bits[b] = 0
+		}
+	}
+
+	left := total - psum
+	perCoeff := left / eBands[codedBands]
+	left -= eBands[codedBands] * perCoeff
+	for b := 0; b < codedBands; b++ {
+		bits[b] += perCoeff * (eBands[b+1] - eBands[b])
+	}
+	for b := 0; b < codedBands; b++ {
+		tmp := min(left, eBands[b+1]-eBands[b])
+		bits[b] += tmp
+		left -= tmp
+	}
+
+	balance := 0
+	b := 0
+	for ; b < codedBands; b++ {
+		n := (eBands[b+1] - eBands[b]) << frameLM
+		bits[b] += balance
+		excess := 0
+		if n > 1 {
+			excess = max(bits[b]-caps[b], 0)
+			bits[b] -= excess
+			den := n
+			ncLogN := den * (logN400[b] + (frameLM << bitRes))
+			offset := (ncLogN >> 1) - den*fineOffset
+			if n == 2 {
+				offset += den << bitRes >> 2
+			}
+			if bits[b]+offset < den*2<<bitRes {
+				offset += ncLogN >> 2
+			} else if bits[b]+offset < den*3<<bitRes {
+				offset += ncLogN >> 3
+			}
+			fq := max(0, (bits[b]+offset+(den<<(bitRes-1)))/(den<<bitRes))
+			if fq > bits[b]>>bitRes {
+				fq = bits[b] >> bitRes
+			}
+			fq = min(fq, maxFineBits)
+			a.fineQuant[b] = fq
+			a.finePriority[b] = boolInt(fq*(den<<bitRes) >= bits[b]+offset)
+			bits[b] -= fq << bitRes
+		} else {
+			excess = max(0, bits[b]-(1<<bitRes))
+			bits[b] -= excess
+			a.fineQuant[b] = 0
+			a.finePriority[b] = 1
+		}
+		if excess > 0 {
+			extra := min(excess>>bitRes, maxFineBits-a.fineQuant[b])
+			a.fineQuant[b] += extra
+			extraBits := extra << bitRes
+			a.finePriority[b] = boolInt(extraBits >= excess-balance)
+			excess -= extraBits
+		}
+		balance = excess
+	}
+	a.balance = balance
+
+	for ; b < end; b++ {
+		a.fineQuant[b] = bits[b] >> bitRes
+		bits[b] = 0
+		a.finePriority[b] = boolInt(a.fineQuant[b] < 1)
+	}
+	a.codedBands = codedBands
+	return a
+}
+
+func getPulses(i int) int {
+	if i < 8 {
+		return i
+	}
+	return (8 + (i & 7)) << ((i >> 3) - 1)
+}
+
+func pulseCache(band, lm int) []int {
+	start := pulseCacheIndex[(lm+1)*numBands+band]
+	if start < 0 {
+		return nil
+	}
+	return pulseCacheBits[start:]
+}
+
+// bitsToPulses finds the pulse count whose cost is closest to bits.
+func bitsToPulses(band, lm, bits int) int {
+	cache := pulseCache(band, lm)
+	if bits <= 0 || cache == nil {
+		return 0
+	}
+	lo, hi := 0, cache[0]
+	bits--
+	for range 6 {
+		mid := (lo + hi + 1) >> 1
+		if cache[mid] >= bits {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	loBits := -1
+	if lo != 0 {
+		loBits = cache[lo]
+	}
+	if bits-loBits <= cache[hi]-bits {
+		return lo
+	}
+	return hi
+}
+
+func pulsesToBits(band, lm, pulses int) int {
+	if pulses == 0 {
+		return 0
+	}
+	return pulseCache(band, lm)[pulses] + 1
+}
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
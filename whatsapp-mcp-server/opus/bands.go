@@ -0,0 +1,329 @@
+package opus
+
+import (
+	"math"
+	"math/bits"
+)
+
+const spreadNormal = 2
+
+// quantAllBands codes the normalised shape of every band. The bit
+// bookkeeping mirrors the decoder exactly; only the choices made within
+// each band's budget (split angles, pulse positions) come from the signal.
+func quantAllBands(enc *rangeEncoder, x []float64, end int, alloc *allocation, totalBits int) {
+	balance := alloc.balance
+	for b := 0; b < end; b++ {
+		tell := enc.tellFrac()
+		if b != 0 {
+			balance -= tell
+		}
+		remaining := totalBits - tell - 1
+		bandBits := 0
+		if b <= alloc.codedBands-1 {
+			cur := balance / min(3, alloc.codedBands-b)
+			bandBits = max(0, min(16383, min(remaining+1, alloc.pulses[b]+cur)))
+		}
+		lo, hi := eBands[b]<<frameLM, eBands[b+1]<<frameLM
+		quantBand(enc, b, x[lo:hi], bandBits, &remaining, frameLM)
+		balance += alloc.pulses[b] + tell
+	}
+}
+
+// quantBand codes one band or half-band, splitting it recursively while the
+// codebook would be too large to index with a single integer.
+func quantBand(enc *rangeEncoder, band int, x []float64, bandBits int, remaining *int, lm int) {
+	n := len(x)
+	if n == 1 {
+		if *remaining >= 1<<bitRes {
+			enc.encodeBits(uint32(boolInt(x[0] < 0)), 1)
+			*remaining -= 1 << bitRes
+		}
+		return
+	}
+
+	if lm != -1 && n > 2 && shouldSplit(band, lm, bandBits) {
+		n >>= 1
+		y := x[n:]
+		x = x[:n]
+		lm--
+
+		pulseCap := logN400[band] + lm*(1<<bitRes)
+		qn := computeQN(n, bandBits, (pulseCap>>1)-4, pulseCap)
+		tell := enc.tellFrac()
+		itheta := 0
+		if qn != 1 {
+			itheta = (splitAngle(x, y)*qn + 8192) >> 14
+			encodeTheta(enc, itheta, qn)
+			itheta = itheta * 16384 / qn
+		}
+		qalloc := enc.tellFrac() - tell
+		bandBits -= qalloc
+
+		delta := 0
+		switch itheta {
+		case 0:
+			delta = -16384
+		case 16384:
+			delta = 16384
+		default:
+			imid := bitexactCos(itheta)
+			iside := bitexactCos(16384 - itheta)
+			delta = fracMul16((n-1)<<7, bitexactLog2Tan(iside, imid))
+		}
+		midBits := max(0, min(bandBits, (bandBits-delta)/2))
+		sideBits := bandBits - midBits
+		*remaining -= qalloc
+
+		rebalance := *remaining
+		if midBits >= sideBits {
+			quantBand(enc, band, x, midBits, remaining, lm)
+			rebalance = midBits - (rebalance - *remaining)
+			if rebalance > 3<<bitRes && itheta != 0 {
+				sideBits += rebalance - (3 << bitRes)
+			}
+			quantBand(enc, band, y, sideBits, remaining, lm)
+		} else {
+			quantBand(enc, band, y, sideBits, remaining, lm)
+			rebalance = sideBits - (rebalance - *remaining)
+			if rebalance > 3<<bitRes && itheta != 16384 {
+				midBits += rebalance - (3 << bitRes)
+			}
+			quantBand(enc, band, x, midBits, remaining, lm)
+		}
+		return
+	}
+
+	q := bitsToPulses(band, lm, bandBits)
+	cost := pulsesToBits(band, lm, q)
+	*remaining -= cost
+	for *remaining < 0 && q > 0 {
+		*remaining += cost
+		q--
+		cost = pulsesToBits(band, lm, q)
+		*remaining -= cost
+	}
+	if q != 0 {
+		algQuant(enc, x, getPulses(q))
+	}
+}
+
+func shouldSplit(band, lm, bandBits int) bool {
+	cache := pulseCache(band, lm)
+	return cache != nil && bandBits > cache[cache[0]]+12
+}
+
+func computeQN(n, b, offset, pulseCap int) int {
+	exp2Table8 := [8]int{16384, 17866, 19483, 21247, 23170, 25267, 27554, 30048}
+	n2 := 2*n - 1
+	qb := min(b-pulseCap-(4<<bitRes), (b+n2*offset)/n2)
+	qb = min(8<<bitRes, qb)
+	if qb < 1<<bitRes>>1 {
+		return 1
+	}
+	return ((exp2Table8[qb&7] >> (14 - (qb >> bitRes))) + 1) >> 1 << 1
+}
+
+// splitAngle returns the angle between the energies of the two halves in
+// Q14 units of pi/2.
+func splitAngle(x, y []float64) int {
+	emid, eside := 1e-27, 1e-27
+	for i := range x {
+		emid += x[i] * x[i]
+		eside += y[i] * y[i]
+	}
+	return int(math.Floor(0.5 + 16384*0.63662*math.Atan2(math.Sqrt(eside), math.Sqrt(emid))))
+}
+
+// encodeTheta codes a split angle with the triangular distribution used for
+// long-block mono bands.
+func encodeTheta(enc *rangeEncoder, itheta, qn int) {
+	half := qn >> 1
+	ft := (half + 1) * (half + 1)
+	var fl, fs int
+	if itheta <= half {
+		fs = itheta + 1
+		fl = itheta * (itheta + 1) >> 1
+	} else {
+		fs = qn + 1 - itheta
+		fl = ft - ((qn + 1 - itheta) * (qn + 2 - itheta) >> 1)
+	}
+	enc.encode(uint32(fl), uint32(fl+fs), uint32(ft))
+}
+
+func bitexactCos(x int) int {
+	x2 := (4096 + x*x) >> 13
+	x2 = (32767 - x2) + fracMul16(x2, -7651+fracMul16(x2, 8277+fracMul16(-626, x2)))
+	return 1 + x2
+}
+
+func bitexactLog2Tan(isin, icos int) int {
+	lc := bits.Len(uint(icos))
+	ls := bits.Len(uint(isin))
+	icos <<= 15 - lc
+	isin <<= 15 - ls
+	return (ls-lc)*(1<<11) +
+		fracMul16(isin, fracMul16(isin, -2597)+7932) -
+		fracMul16(icos, fracMul16(icos, -2597)+7932)
+}
+
+func fracMul16(a, b int) int {
+	return (16384 + int(int16(a))*int(int16(b))) >> 15
+}
+
+// algQuant finds the K-pulse PVQ codeword closest to x and codes its index.
+func algQuant(enc *rangeEncoder, x []float64, k int) {
+	n := len(x)
+	r := make([]float64, n)
+	copy(r, x)
+	expRotation(r, k)
+
+	y := pvqSearch(r, k)
+	u := cwrsRow(n, k)
+	total := u[k] + u[k+1]
+	enc.encodeUint(cwrsIndex(y, k, u), total)
+}
+
+// expRotation applies the spreading rotation the decoder undoes, so that
+// sparse codewords spread their energy over neighbouring bins.
+func expRotation(x []float64, k int) {
+	n := len(x)
+	if 2*k >= n {
+		return
+	}
+	factor := [3]int{15, 10, 5}[spreadNormal-1]
+	gain := float64(n) / float64(n+factor*k)
+	theta := 0.5 * gain * gain
+	c := math.Cos(0.5 * math.Pi * theta)
+	s := math.Sin(0.5 * math.Pi * theta)
+
+	stride2 := 0
+	if n >= 8 {
+		stride2 = 1
+		for stride2*stride2+stride2 < n {
+			stride2++
+		}
+	}
+	rotate(x, 1, c, -s)
+	if stride2 != 0 {
+		rotate(x, stride2, s, -c)
+	}
+}
+
+func rotate(x []float64, stride int, c, s float64) {
+	n := len(x)
+	for i := 0; i < n-stride; i++ {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+	for i := n - 2*stride - 1; i >= 0; i-- {
+		x1, x2 := x[i], x[i+stride]
+		x[i+stride] = c*x2 + s*x1
+		x[i] = c*x1 - s*x2
+	}
+}
+
+// pvqSearch greedily places k unit pulses to maximise the normalised
+// correlation with x.
+func pvqSearch(x []float64, k int) []int {
+	n := len(x)
+	y := make([]int, n)
+	ax := make([]float64, n)
+	sum := 0.0
+	for i, v := range x {
+		ax[i] = math.Abs(v)
+		sum += ax[i]
+	}
+
+	placed := 0
+	xy, yy := 0.0, 0.0
+	if k > n>>1 && sum > 1e-15 {
+		// Project onto the pyramid first to place most pulses at once.
+		scale := float64(k-1) / sum
+		for i := range ax {
+			y[i] = int(math.Floor(scale * ax[i]))
+			placed += y[i]
+			xy += ax[i] * float64(y[i])
+			yy += float64(y[i] * y[i])
+		}
+	}
+	if sum <= 1e-15 {
+		ax[0] = 1
+	}
+
+	for ; placed < k; placed++ {
+		best := 0
+		bestNum, bestDen := -1.0, 1.0
+		for i := range ax {
+			num := xy + ax[i]
+			num *= num
+			den := yy + float64(2*y[i]+1)
+			if num*bestDen > bestNum*den {
+				best, bestNum, bestDen = i, num, den
+			}
+		}
+		xy += ax[best]
+		yy += float64(2*y[best] + 1)
+		y[best]++
+	}
+
+	for i, v := range x {
+		if v < 0 {
+			y[i] = -y[i]
+		}
+	}
+	return y
+}
+
+// cwrsRow returns U(n, 0..k+1), the row of the PVQ codebook size
+// recurrence for n dimensions.
+func cwrsRow(n, k int) []uint32 {
+	u := make([]uint32, k+2)
+	u[1] = 1
+	if n == 1 {
+		for i := 2; i < len(u); i++ {
+			u[i] = 1
+		}
+		return u
+	}
+	for i := 2; i < len(u); i++ {
+		u[i] = uint32(2*i - 1)
+	}
+	for d := 2; d < n; d++ {
+		v := uint32(1)
+		for j := 2; j < len(u); j++ {
+			next := u[j] + u[j-1] + v
+			u[j-1] = v
+			v = next
+		}
+		u[len(u)-1] = v
+	}
+	return u
+}
+
+// cwrsIndex enumerates y in the order the decoder's cwrs walk expects.
+func cwrsIndex(y []int, k int, u []uint32) uint32 {
+	var index uint32
+	for _, v := range y {
+		m := v
+		if m < 0 {
+			index += u[k+1]
+			m = -m
+		}
+		k -= m
+		index += u[k]
+		prevRow(u, k+2)
+	}
+	return index
+}
+
+// prevRow turns U(n, .) into U(n-1, .) over the first count entries.
+func prevRow(u []uint32, count int) {
+	v := uint32(0)
+	for j := 1; j < count; j++ {
+		next := u[j] - u[j-1] - v
+		u[j-1] = v
+		v = next
+	}
+	u[count-1] = v
+}
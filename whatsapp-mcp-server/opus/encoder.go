@@ -0,0 +1,168 @@
+// Package opus is a small pure-Go Opus encoder. It produces mono CELT-only
+// packets of 20 ms at a constant bitrate, which every Opus decoder accepts and
+// is all a WhatsApp voice note needs. There is no SILK, stereo, transient or
+// variable bitrate support.
+package opus
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// FrameSize is the number of 48 kHz samples in each encoded packet.
+	FrameSize = frameSize
+	// PreSkip is the number of decoded samples that precede the first input
+	// sample, to be recorded in the Ogg header.
+	PreSkip = overlap
+
+	preemphasis = 0.85000610
+	minBitrate  = 6000
+	maxBitrate  = 510000
+)
+
+// ErrFrameSize is returned when Encode is not given exactly one frame.
+var ErrFrameSize = errors.New("opus: input must be exactly one frame")
+
+// Encoder holds the state carried between frames of one stream.
+type Encoder struct {
+	toc        byte
+	end        int
+	frameBytes int
+
+	preemphMem float64
+	overlapMem [overlap]float64
+	oldE       [numBands]float64
+	started    bool
+	finalRange uint32
+}
+
+// NewEncoder returns an encoder for 48 kHz mono input. sampleRate is the rate
+// of the original audio and only chooses the coded bandwidth; bitrate is in
+// bits per second and is clamped to what Opus allows.
+func NewEncoder(sampleRate, bitrate int) (*Encoder, error) {
+	e := &Encoder{}
+	switch {
+	case sampleRate <= 0:
+		return nil, fmt.Errorf("opus: invalid sample rate %d", sampleRate)
+	case sampleRate <= 8000:
+		e.toc, e.end = 0x98, 13 // narrowband
+	case sampleRate <= 16000:
+		e.toc, e.end = 0xB8, 17 // wideband
+	case sampleRate <= 24000:
+		e.toc, e.end = 0xD8, 19 // super-wideband
+	default:
+		e.toc, e.end = 0xF8, numBands // fullband
+	}
+	bitrate = max(minBitrate, min(bitrate, maxBitrate))
+	e.frameBytes = bitrate*frameSize/48000/8 - 1
+	return e, nil
+}
+
+// Encode codes one frame of FrameSize samples in [-1, 1] and returns a
+// complete Opus packet.
+func (e *Encoder) Encode(pcm []float32) ([]byte, error) {
+	if len(pcm) != frameSize {
+		return nil, ErrFrameSize
+	}
+
+	var in [overlap + frameSize]float64
+	copy(in[:overlap], e.overlapMem[:])
+	silent := true
+	for i, s := range pcm {
+		v := float64(s) * 32768
+		in[overlap+i] = v - preemphasis*e.preemphMem
+		e.preemphMem = v
+	}
+	for _, v := range in {
+		if v != 0 {
+			silent = false
+			break
+		}
+	}
+	copy(e.overlapMem[:], in[frameSize:])
+
+	enc := newRangeEncoder(e.frameBytes)
+	total := e.frameBytes * 8
+
+	enc.encodeBitLogp(silent, 15)
+	if silent {
+		for b := 0; b < e.end; b++ {
+			e.oldE[b] = -28
+		}
+		return e.packet(enc)
+	}
+
+	var freq [frameSize]float64
+	mdct(in[:], freq[:])
+	logE := bandEnergies(freq[:], e.end)
+
+	if enc.tell()+16 <= total {
+		enc.encodeBitLogp(false, 1) // no pitch post-filter
+	}
+	if enc.tell()+3 <= total {
+		enc.encodeBitLogp(false, 3) // long blocks only
+	}
+	intra := false
+	if enc.tell()+3 <= total {
+		intra = !e.started
+		enc.encodeBitLogp(intra, 3)
+	}
+	e.started = true
+
+	quantCoarseEnergy(enc, logE[:], e.oldE[:], e.end, total, intra)
+	e.encodeAllocationHeader(enc, total)
+
+	bits := total<<bitRes - enc.tellFrac() - 1
+	alloc := computeAllocation(enc, e.end, defaultTrim, bits)
+	quantFineEnergy(enc, logE[:], e.oldE[:], e.end, alloc.fineQuant[:])
+	quantAllBands(enc, freq[:], e.end, &alloc, total<<bitRes)
+	finaliseFineEnergy(enc, logE[:], e.oldE[:], e.end, alloc.fineQuant[:], alloc.finePriority[:], total-enc.tell())
+
+	return e.packet(enc)
+}
+
+// encodeAllocationHeader codes the per-band TF, spread, dynamic allocation
+// and trim symbols, all at their neutral values.
+func (e *Encoder) encodeAllocationHeader(enc *rangeEncoder, total int) {
+	logp := 4
+	budget := total
+	tell := enc.tell()
+	if tell+logp+1 <= budget {
+		budget-- // reserved for tf_select, which long blocks never need
+	}
+	for b := 0; b < e.end; b++ {
+		if tell+logp <= budget {
+			enc.encodeBitLogp(false, uint(logp))
+			tell = enc.tell()
+		}
+		logp = 5
+	}
+
+	if enc.tell()+4 <= total {
+		enc.encodeICDF(spreadNormal, icdfSpread)
+	}
+
+	totalEighths := total << bitRes
+	caps := bandCapsFor(e.end)
+	for b := 0; b < e.end; b++ {
+		if enc.tellFrac()+dynallocLogp<<bitRes < totalEighths && caps[b] > 0 {
+			enc.encodeBitLogp(false, dynallocLogp)
+		}
+	}
+
+	if enc.tellFrac()+trimBitCost<<bitRes <= totalEighths {
+		enc.encodeICDF(defaultTrim, icdfTrim)
+	}
+}
+
+func (e *Encoder) packet(enc *rangeEncoder) ([]byte, error) {
+	e.finalRange = enc.rng
+	frame := enc.done()
+	if enc.err {
+		return nil, errors.New("opus: frame overflow")
+	}
+	pkt := make([]byte, 0, len(frame)+1)
+	pkt = append(pkt, e.toc)
+	return append(pkt, frame...), nil
+}
@@ -0,0 +1,118 @@
+package opus
+
+import "math"
+
+const maxFineBits = 8
+
+// bandEnergies splits the spectrum into bands, normalises each band to unit
+// energy in place and returns the mean-removed log2 band amplitudes.
+func bandEnergies(freq []float64, end int) [numBands]float64 {
+	var logE [numBands]float64
+	for b := 0; b < end; b++ {
+		lo, hi := eBands[b]<<frameLM, eBands[b+1]<<frameLM
+		sum := 1e-27
+		for _, v := range freq[lo:hi] {
+			sum += v * v
+		}
+		amp := math.Sqrt(sum)
+		for i := lo; i < hi; i++ {
+			freq[i] /= amp
+		}
+		logE[b] = math.Log2(amp) - eMeans[b]
+	}
+	return logE
+}
+
+// quantCoarseEnergy codes the integer part of each band's energy as a
+// prediction residual, updating oldE to what the decoder will reconstruct.
+func quantCoarseEnergy(enc *rangeEncoder, logE []float64, oldE []float64, end, totalBits int, intra bool) {
+	probs := eProbModel[frameLM][0]
+	coef, beta := predCoef[frameLM], betaCoef[frameLM]
+	if intra {
+		probs = eProbModel[frameLM][1]
+		coef, beta = 0, betaIntra
+	}
+
+	prev := 0.0
+	for b := 0; b < end; b++ {
+		x := logE[b]
+		oldEb := math.Max(-9, oldE[b])
+		f := x - coef*oldEb - prev
+		qi := int(math.Floor(f + 0.5))
+
+		decayBound := math.Max(-28, oldE[b]) - 16
+		if qi < 0 && x < decayBound {
+			qi += int(decayBound - x)
+			qi = min(qi, 0)
+		}
+
+		tell := enc.tell()
+		bitsLeft := totalBits - tell - 3*(end-b)
+		if b != 0 && bitsLeft < 30 {
+			if bitsLeft < 24 {
+				qi = min(1, qi)
+			}
+			if bitsLeft < 16 {
+				qi = max(-1, qi)
+			}
+		}
+
+		switch {
+		case totalBits-tell >= 15:
+			qi = enc.encodeLaplace(qi, probs[2*b]<<7, probs[2*b+1]<<6)
+		case totalBits-tell >= 2:
+			qi = max(-1, min(qi, 1))
+			sym := 0
+			switch qi {
+			case -1:
+				sym = 1
+			case 1:
+				sym = 2
+			}
+			enc.encodeICDF(sym, icdfSmallEnergy)
+		case totalBits-tell >= 1:
+			qi = max(-1, min(0, qi))
+			enc.encodeBitLogp(qi != 0, 1)
+		default:
+			qi = -1
+		}
+
+		q := float64(qi)
+		oldE[b] = coef*oldEb + prev + q
+		prev += q - beta*q
+	}
+}
+
+// quantFineEnergy refines each band's energy with fineQuant raw bits.
+func quantFineEnergy(enc *rangeEncoder, logE, oldE []float64, end int, fineQuant []int) {
+	for b := 0; b < end; b++ {
+		fq := fineQuant[b]
+		if fq <= 0 {
+			continue
+		}
+		levels := 1 << uint(fq)
+		q2 := int(math.Floor((logE[b] - oldE[b] + 0.5) * float64(levels)))
+		q2 = max(0, min(q2, levels-1))
+		enc.encodeBits(uint32(q2), uint(fq))
+		oldE[b] += (float64(q2)+0.5)*float64(int(1)<<uint(14-fq))/16384 - 0.5
+	}
+}
+
+// finaliseFineEnergy spends the bits left after shape coding on one more
+// refinement bit per band, in priority order.
+func finaliseFineEnergy(enc *rangeEncoder, logE, oldE []float64, end int, fineQuant, finePriority []int, bitsLeft int) {
+	for prio := 0; prio < 2; prio++ {
+		for b := 0; b < end && bitsLeft >= 1; b++ {
+			if fineQuant[b] >= maxFineBits || finePriority[b] != prio {
+				continue
+			}
+			q2 := 0
+			if logE[b] >= oldE[b] {
+				q2 = 1
+			}
+			enc.encodeBits(uint32(q2), 1)
+			oldE[b] += (float64(q2) - 0.5) * float64(int(1)<<uint(14-fineQuant[b]-1)) / 16384
+			bitsLeft--
+		}
+	}
+}
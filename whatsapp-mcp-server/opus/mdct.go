@@ -0,0 +1,115 @@
+package opus
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// mdctScale maps the transform onto the amplitude the decoder's inverse
+// MDCT expects, so band energies come out in the same units.
+const mdctScale = 2.0 / frameSize
+
+// Twiddles for the frameSize-point DCT-IV computed through a complex FFT of
+// half that length.
+var (
+	dctPreTwiddle  [frameSize / 2]complex128
+	dctPostTwiddle [frameSize / 2]complex128
+	fftTwiddle     [frameSize / 2]complex128
+)
+
+func init() {
+	const m = frameSize
+	for n := range dctPreTwiddle {
+		dctPreTwiddle[n] = cmplx.Exp(complex(0, -math.Pi*(float64(n)+0.25)/m))
+		dctPostTwiddle[n] = cmplx.Exp(complex(0, -math.Pi*float64(n)/m))
+		fftTwiddle[n] = cmplx.Exp(complex(0, -2*math.Pi*float64(n)/(m/2)))
+	}
+}
+
+// mdct computes the forward low-overlap MDCT of one long block. in holds
+// overlap+frameSize pre-emphasised samples, the first overlap of which are
+// the tail of the previous frame; out receives frameSize coefficients.
+func mdct(in []float64, out []float64) {
+	const (
+		m    = frameSize
+		half = m / 2
+		pad  = half - overlap/2
+	)
+
+	// Window the block into the zero-padded 2*m transform input.
+	var z [2 * m]float64
+	for i := 0; i < m+overlap; i++ {
+		w := 1.0
+		switch {
+		case i < overlap:
+			w = window[i]
+		case i >= m:
+			w = window[m+overlap-1-i]
+		}
+		z[pad+i] = w * in[i]
+	}
+
+	// Fold the 2*m windowed samples into m DCT-IV inputs.
+	var u [m]float64
+	for n := 0; n < half; n++ {
+		u[n] = -z[3*half-1-n] - z[3*half+n]
+	}
+	for n := half; n < m; n++ {
+		u[n] = z[n-half] - z[3*half-1-n]
+	}
+
+	var v [half]complex128
+	for n := range v {
+		v[n] = complex(u[2*n], u[m-1-2*n]) * dctPreTwiddle[n]
+	}
+	spec := fft(v[:], 1)
+	for k, c := range spec {
+		c *= dctPostTwiddle[k]
+		out[2*k] = real(c) * mdctScale
+		out[m-1-2*k] = -imag(c) * mdctScale
+	}
+}
+
+// fft is a recursive mixed-radix DFT over the frameSize/2 point twiddle
+// table; stride selects the twiddles for the sub-transform length.
+func fft(x []complex128, stride int) []complex128 {
+	n := len(x)
+	if n == 1 {
+		return []complex128{x[0]}
+	}
+	p := smallestFactor(n)
+	m := n / p
+
+	subs := make([][]complex128, p)
+	for r := range subs {
+		in := make([]complex128, m)
+		for j := range in {
+			in[j] = x[j*p+r]
+		}
+		subs[r] = fft(in, stride*p)
+	}
+
+	out := make([]complex128, n)
+	for k := 0; k < n; k++ {
+		var sum complex128
+		for r := 0; r < p; r++ {
+			sum += subs[r][k%m] * fftTwiddle[(r*k*stride)%len(fftTwiddle)]
+		}
+		out[k] = sum
+	}
+	return out
+}
+
+func smallestFactor(n int) int {
+	for _, p := range []int{4, 2, 3, 5} {
+		if n%p == 0 {
+			return p
+		}
+	}
+	for p := 7; p*p <= n; p += 2 {
+		if n%p == 0 {
+			return p
+		}
+	}
+	return n
+}
@@ -0,0 +1,144 @@
+package opus
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	pageMaxSegments = 255
+	// pageDuration bounds how many 48 kHz samples one Ogg page carries.
+	pageDuration = 48000
+	vendor       = "whatsapp-mcp-go"
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// OggWriter muxes Opus packets into an Ogg Opus stream as described in
+// RFC 7845. Packets are grouped into pages of up to a second of audio.
+type OggWriter struct {
+	w       io.Writer
+	serial  uint32
+	seq     uint32
+	granule uint64
+
+	segments []byte
+	body     []byte
+	pending  int
+	err      error
+}
+
+// NewOggWriter writes the Opus identification and comment headers for a mono
+// stream. inputRate is the original sample rate, kept for information only.
+func NewOggWriter(w io.Writer, serial uint32, inputRate int) (*OggWriter, error) {
+	// The granule counts every decoded sample, the pre-skip included. Callers
+	// pass the pre-skip in with their packets, so it starts at zero.
+	ow := &OggWriter{w: w, serial: serial}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // version
+	head[9] = 1 // channels
+	binary.LittleEndian.PutUint16(head[10:], PreSkip)
+	binary.LittleEndian.PutUint32(head[12:], uint32(inputRate))
+	ow.writePage(head, 0, 0x02)
+
+	tags := make([]byte, 0, 16+len(vendor))
+	tags = append(tags, "OpusTags"...)
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(vendor)))
+	tags = append(tags, vendor...)
+	tags = binary.LittleEndian.AppendUint32(tags, 0)
+	ow.writePage(tags, 0, 0)
+
+	return ow, ow.err
+}
+
+// WritePacket queues one Opus packet holding samples 48 kHz samples of audio.
+// The last packet of a stream may report fewer samples than it decodes to so
+// that players trim the padding.
+func (ow *OggWriter) WritePacket(packet []byte, samples int) error {
+	lacing := len(packet)/255 + 1
+	if len(ow.segments)+lacing > pageMaxSegments {
+		ow.flush(0)
+	}
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			ow.segments = append(ow.segments, byte(n))
+			break
+		}
+		ow.segments = append(ow.segments, 255)
+	}
+	ow.body = append(ow.body, packet...)
+	ow.granule += uint64(samples)
+	ow.pending += samples
+	if len(ow.segments) >= pageMaxSegments || ow.pending >= pageDuration {
+		ow.flush(0)
+	}
+	return ow.err
+}
+
+// Close writes the final page with the end-of-stream flag set.
+func (ow *OggWriter) Close() error {
+	ow.flush(0x04)
+	return ow.err
+}
+
+func (ow *OggWriter) flush(flags byte) {
+	if len(ow.segments) == 0 && flags&0x04 == 0 {
+		return
+	}
+	ow.writeSegments(ow.segments, ow.body, ow.granule, flags)
+	ow.segments = ow.segments[:0]
+	ow.body = ow.body[:0]
+	ow.pending = 0
+}
+
+func (ow *OggWriter) writePage(packet []byte, granule uint64, flags byte) {
+	var segs []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			segs = append(segs, byte(n))
+			break
+		}
+		segs = append(segs, 255)
+	}
+	ow.writeSegments(segs, packet, granule, flags)
+}
+
+func (ow *OggWriter) writeSegments(segs, body []byte, granule uint64, flags byte) {
+	if ow.err != nil {
+		return
+	}
+	page := make([]byte, 27, 27+len(segs)+len(body))
+	copy(page, "OggS")
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], ow.serial)
+	binary.LittleEndian.PutUint32(page[18:], ow.seq)
+	page[26] = byte(len(segs))
+	page = append(page, segs...)
+	page = append(page, body...)
+
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+
+	ow.seq++
+	_, ow.err = ow.w.Write(page)
+}
@@ -0,0 +1,254 @@
+package opus
+
+import "math/bits"
+
+// Range coder constants from RFC 6716 section 5.1.
+const (
+	symBits   = 8
+	codeBits  = 32
+	symMax    = (1 << symBits) - 1
+	codeShift = codeBits - symBits - 1
+	codeTop   = uint32(1) << (codeBits - 1)
+	codeBot   = codeTop >> symBits
+	uintBits  = 8
+	windowLen = 32
+)
+
+// rangeEncoder is the RFC 6716 section 5.1 entropy encoder. Range coded
+// symbols are written from the front of buf, raw bits from the back.
+type rangeEncoder struct {
+	buf       []byte
+	offs      int
+	endOffs   int
+	endWindow uint32
+	nendBits  int
+	nbitsTot  int
+	rng       uint32
+	val       uint32
+	rem       int
+	ext       int
+	err       bool
+}
+
+func newRangeEncoder(size int) *rangeEncoder {
+	return &rangeEncoder{
+		buf:      make([]byte, size),
+		nbitsTot: codeBits + 1,
+		rng:      codeTop,
+		rem:      -1,
+	}
+}
+
+func (e *rangeEncoder) writeByte(b uint32) {
+	if e.offs+e.endOffs >= len(e.buf) {
+		e.err = true
+		return
+	}
+	e.buf[e.offs] = byte(b)
+	e.offs++
+}
+
+func (e *rangeEncoder) writeByteAtEnd(b uint32) {
+	if e.offs+e.endOffs >= len(e.buf) {
+		e.err = true
+		return
+	}
+	e.endOffs++
+	e.buf[len(e.buf)-e.endOffs] = byte(b)
+}
+
+// carryOut buffers output bytes so a carry can still propagate into them.
+func (e *rangeEncoder) carryOut(c uint32) {
+	if c == symMax {
+		e.ext++
+		return
+	}
+	carry := c >> symBits
+	if e.rem >= 0 {
+		e.writeByte(uint32(e.rem) + carry)
+	}
+	for ; e.ext > 0; e.ext-- {
+		e.writeByte((symMax + carry) & symMax)
+	}
+	e.rem = int(c & symMax)
+}
+
+func (e *rangeEncoder) normalize() {
+	for e.rng <= codeBot {
+		e.carryOut(e.val >> codeShift)
+		e.val = (e.val << symBits) & (codeTop - 1)
+		e.rng <<= symBits
+		e.nbitsTot += symBits
+	}
+}
+
+// encode codes the symbol occupying [fl, fh) of a distribution totalling ft.
+func (e *rangeEncoder) encode(fl, fh, ft uint32) {
+	r := e.rng / ft
+	if fl > 0 {
+		e.val += e.rng - r*(ft-fl)
+		e.rng = r * (fh - fl)
+	} else {
+		e.rng -= r * (ft - fh)
+	}
+	e.normalize()
+}
+
+// encodeBitLogp codes a binary symbol whose probability of being 1 is 1/2^logp.
+func (e *rangeEncoder) encodeBitLogp(bit bool, logp uint) {
+	s := e.rng >> logp
+	r := e.rng - s
+	if bit {
+		e.val += r
+		e.rng = s
+	} else {
+		e.rng = r
+	}
+	e.normalize()
+}
+
+// encodeICDF codes symbol s of a table laid out as {total, cumulative highs...}.
+func (e *rangeEncoder) encodeICDF(s int, table []uint32) {
+	total := table[0]
+	cdf := table[1:]
+	low := uint32(0)
+	if s > 0 {
+		low = cdf[s-1]
+	}
+	e.encode(low, cdf[s], total)
+}
+
+// encodeUint codes v uniformly in [0, ft), spilling low bits to raw bits
+// when ft needs more than eight bits.
+func (e *rangeEncoder) encodeUint(v, ft uint32) {
+	ft--
+	ftb := bits.Len32(ft)
+	if ftb > uintBits {
+		ftb -= uintBits
+		ft1 := (ft >> uint(ftb)) + 1
+		e.encode(v>>uint(ftb), (v>>uint(ftb))+1, ft1)
+		e.encodeBits(v&((1<<uint(ftb))-1), uint(ftb))
+		return
+	}
+	e.encode(v, v+1, ft+1)
+}
+
+// encodeBits appends raw bits at the end of the frame.
+func (e *rangeEncoder) encodeBits(v uint32, n uint) {
+	window := e.endWindow
+	used := e.nendBits
+	if used+int(n) > windowLen {
+		for used >= symBits {
+			e.writeByteAtEnd(window & symMax)
+			window >>= symBits
+			used -= symBits
+		}
+	}
+	window |= v << uint(used)
+	used += int(n)
+	e.endWindow = window
+	e.nendBits = used
+	e.nbitsTot += int(n)
+}
+
+// encodeLaplace codes a coarse energy delta with the CELT Laplace model and
+// returns the value actually coded, which may be clamped at the tails.
+func (e *rangeEncoder) encodeLaplace(value int, fs, decay uint32) int {
+	const minP = 1
+	fl := uint32(0)
+	if value != 0 {
+		s := 0
+		if value < 0 {
+			s = -1
+		}
+		v := (value + s) ^ s
+		fl = fs
+		fs = (32768 - minP*2*16 - fs) * (16384 - decay) >> 15
+		i := 1
+		for ; fs > 0 && i < v; i++ {
+			fs *= 2
+			fl += fs + 2*minP
+			fs = (fs * decay) >> 15
+		}
+		if fs == 0 {
+			ndiMax := int(32768 - fl + minP - 1)
+			ndiMax = (ndiMax - s) >> 1
+			di := min(v-i, ndiMax-1)
+			fl += uint32(2*di+1+s) * minP
+			fs = min(minP, 32768-fl)
+			value = (i + di + s) ^ s
+		} else {
+			fs += minP
+			if s == 0 {
+				fl += fs
+			}
+		}
+	}
+	e.encode(fl, fl+fs, 32768)
+	return value
+}
+
+// tell reports the number of whole bits written so far, rounded up.
+func (e *rangeEncoder) tell() int {
+	return e.nbitsTot - bits.Len32(e.rng)
+}
+
+// tellFrac reports the bits written so far in 1/8 bit units.
+func (e *rangeEncoder) tellFrac() int {
+	nbits := e.nbitsTot << bitRes
+	l := bits.Len32(e.rng)
+	r := e.rng >> uint(l-16)
+	for i := bitRes; i > 0; i-- {
+		r = (r * r) >> 15
+		b := int(r >> 16)
+		l = l<<1 | b
+		r >>= uint(b)
+	}
+	return nbits - l
+}
+
+// done flushes the coder so the frame decodes to exactly the coded symbols.
+func (e *rangeEncoder) done() []byte {
+	l := codeBits - bits.Len32(e.rng)
+	msk := (codeTop - 1) >> uint(l)
+	end := (e.val + msk) &^ msk
+	if (end | msk) >= e.val+e.rng {
+		l++
+		msk >>= 1
+		end = (e.val + msk) &^ msk
+	}
+	for l > 0 {
+		e.carryOut(end >> codeShift)
+		end = (end << symBits) & (codeTop - 1)
+		l -= symBits
+	}
+	if e.rem >= 0 || e.ext > 0 {
+		e.carryOut(0)
+	}
+
+	window := e.endWindow
+	used := e.nendBits
+	for used >= symBits {
+		e.writeByteAtEnd(window & symMax)
+		window >>= symBits
+		used -= symBits
+	}
+	if !e.err {
+		for i := e.offs; i < len(e.buf)-e.endOffs; i++ {
+			e.buf[i] = 0
+		}
+		if used > 0 {
+			if e.endOffs >= len(e.buf) {
+				e.err = true
+			} else {
+				l = -l
+				if e.offs+e.endOffs >= len(e.buf) && l < used {
+					window &= (1 << uint(l)) - 1
+					e.err = true
+				}
+				e.buf[len(e.buf)-e.endOffs-1] |= byte(window)
+			}
+		}
+	}
+	return e.buf
+}